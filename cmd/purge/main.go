@@ -0,0 +1,97 @@
+// Command purge hard-deletes orders and order items soft-deleted longer than the retention window ago.
+// It purges in short batches until nothing is left, so it can run nightly next to the live traffic.
+//
+//	DATABASE_URL=postgres://... go run ./cmd/purge -older-than 720h -batch-size 1000
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+)
+
+func main() {
+	var (
+		dsn       = flag.String("dsn", os.Getenv("DATABASE_URL"), "PostgreSQL connection string, defaults to $DATABASE_URL")
+		olderThan = flag.Duration("older-than", 30*24*time.Hour, "purge rows soft-deleted longer than this ago")
+		batchSize = flag.Int("batch-size", 1000, "max orders and max items purged per transaction")
+		pause     = flag.Duration("pause", 100*time.Millisecond, "pause between batches")
+	)
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, *dsn, *olderThan, *batchSize, *pause); err != nil {
+		slog.Error("purge failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, dsn string, olderThan time.Duration, batchSize int, pause time.Duration) error {
+	if dsn == "" {
+		return errors.New("dsn is empty")
+	}
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("pgxpool.New: %w", err)
+	}
+	defer pool.Close()
+
+	repo, err := repository.NewOrder(pool)
+	if err != nil {
+		return fmt.Errorf("repository.NewOrder: %w", err)
+	}
+
+	start := time.Now()
+
+	total, err := purgeAll(ctx, repo, olderThan, batchSize, pause)
+	// report the progress even if a batch failed, the purged batches are committed
+	slog.Info("purge finished",
+		"orders", total.Orders,
+		"items", total.Items,
+		"duration", time.Since(start))
+	if err != nil {
+		return fmt.Errorf("purgeAll: %w", err)
+	}
+
+	return nil
+}
+
+// purgeAll calls Purge until a batch purges nothing or ctx is done.
+func purgeAll(ctx context.Context, repo port.OrderRepository, olderThan time.Duration, batchSize int, pause time.Duration) (domain.PurgeResult, error) {
+	var total domain.PurgeResult
+
+	for {
+		result, err := repo.Purge(ctx, olderThan, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("repo.Purge: %w", err)
+		}
+
+		total = total.Add(result)
+
+		if result.IsZero() {
+			return total, nil
+		}
+
+		slog.Debug("purged batch", "orders", result.Orders, "items", result.Items)
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+}
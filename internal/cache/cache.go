@@ -0,0 +1,172 @@
+// Package cache serves GetOrder of an order repository from a cache, which is an in-process LRU or an external one.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+)
+
+// Cache stores orders by their ID, an implementation for an external cache, i.e. Redis, decides the TTL itself.
+// Get reports a missing or an expired order with false, not with an error.
+type Cache interface {
+	Get(ctx context.Context, orderID uuid.UUID) (domain.Order, bool, error)
+	Set(ctx context.Context, order domain.Order) error
+	Delete(ctx context.Context, orderID uuid.UUID) error
+}
+
+// LRU is an in-process Cache of a fixed number of orders which expire after a TTL,
+// it keeps copies of the orders, so neither the caller of Set nor the one of Get can change a cached order.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	now   func() time.Time
+	items map[uuid.UUID]*list.Element
+	// recency has the most recently used entry at the front
+	recency *list.List
+}
+
+var _ Cache = (*LRU)(nil)
+
+type lruEntry struct {
+	order     domain.Order
+	expiresAt time.Time
+}
+
+type LRUOption func(*LRU)
+
+// WithClock replaces time.Now for the expiry, i.e. in tests.
+func WithClock(now func() time.Time) LRUOption {
+	return func(c *LRU) {
+		c.now = now
+	}
+}
+
+// NewLRU creates a cache of at most size orders, the least recently used one is evicted to make room.
+func NewLRU(size int, ttl time.Duration, opts ...LRUOption) (*LRU, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("size must be positive: %d", size)
+	}
+
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be positive: %s", ttl)
+	}
+
+	c := &LRU{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		items:   make(map[uuid.UUID]*list.Element, size),
+		recency: list.New(),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+func (c *LRU) Get(_ context.Context, orderID uuid.UUID) (domain.Order, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[orderID]
+	if !ok {
+		return domain.Order{}, false, nil
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return domain.Order{}, false, nil
+	}
+
+	c.recency.MoveToFront(elem)
+
+	return cloneOrder(entry.order), true, nil
+}
+
+func (c *LRU) Set(_ context.Context, order domain.Order) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{
+		order:     cloneOrder(order),
+		expiresAt: c.now().Add(c.ttl),
+	}
+
+	if elem, ok := c.items[order.ID]; ok {
+		elem.Value = entry
+		c.recency.MoveToFront(elem)
+		return nil
+	}
+
+	if c.recency.Len() >= c.size {
+		c.remove(c.recency.Back())
+	}
+
+	c.items[order.ID] = c.recency.PushFront(entry)
+
+	return nil
+}
+
+func (c *LRU) Delete(_ context.Context, orderID uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[orderID]; ok {
+		c.remove(elem)
+	}
+
+	return nil
+}
+
+// Len returns the number of cached orders, including the expired ones not evicted yet.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.recency.Len()
+}
+
+func (c *LRU) remove(elem *list.Element) {
+	c.recency.Remove(elem)
+	delete(c.items, elem.Value.(*lruEntry).order.ID)
+}
+
+func cloneOrder(order domain.Order) domain.Order {
+	order.Items = slices.Clone(order.Items)
+	for i := range order.Items {
+		order.Items[i].DeletedAt = cloneTimePtr(order.Items[i].DeletedAt)
+	}
+
+	if order.Url != nil {
+		// url.Userinfo is immutable, so a shallow copy suffices
+		u := *order.Url
+		order.Url = &u
+	}
+
+	order.Tags = slices.Clone(order.Tags)
+	order.Payload = slices.Clone(order.Payload)
+	order.PayloadB = slices.Clone(order.PayloadB)
+	order.DeletedAt = cloneTimePtr(order.DeletedAt)
+
+	return order
+}
+
+func cloneTimePtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	c := *t
+	return &c
+}
@@ -0,0 +1,95 @@
+package cache_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/cache"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := t.Context()
+
+	now := time.Now()
+	c, err := cache.NewLRU(2, time.Minute, cache.WithClock(func() time.Time { return now }))
+	require.NoError(t, err)
+
+	order1, order2, order3 := randomOrder(), randomOrder(), randomOrder()
+
+	require.NoError(t, c.Set(ctx, order1))
+	require.NoError(t, c.Set(ctx, order2))
+
+	// order1 becomes the most recently used, so order2 is evicted for order3
+	cached, ok, err := c.Get(ctx, order1.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, order1, cached)
+
+	require.NoError(t, c.Set(ctx, order3))
+	assert.Equal(t, 2, c.Len())
+
+	_, ok, err = c.Get(ctx, order2.ID)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, c.Delete(ctx, order3.ID))
+	_, ok, err = c.Get(ctx, order3.ID)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+
+	_, ok, err = c.Get(ctx, order1.ID)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestLRU_Copies(t *testing.T) {
+	ctx := t.Context()
+
+	c, err := cache.NewLRU(1, time.Minute)
+	require.NoError(t, err)
+
+	order := randomOrder()
+	expected := order
+	expected.Items = slices.Clone(order.Items)
+	expected.Tags = slices.Clone(order.Tags)
+
+	require.NoError(t, c.Set(ctx, order))
+	order.Tags[0] = "changed"
+	order.Items[0].Price.Amount = order.Items[0].Price.Amount.Add(order.Items[0].Price.Amount)
+
+	cached, ok, err := c.Get(ctx, order.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, expected.Tags, cached.Tags)
+	assert.Equal(t, expected.Items, cached.Items)
+
+	cached.Tags[0] = "changed again"
+
+	cached, _, err = c.Get(ctx, order.ID)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Tags, cached.Tags)
+}
+
+func TestNewLRU(t *testing.T) {
+	_, err := cache.NewLRU(0, time.Minute)
+	require.Error(t, err)
+
+	_, err = cache.NewLRU(1, 0)
+	require.Error(t, err)
+}
+
+func randomOrder() domain.Order {
+	order := repositorytest.RandomOrder()
+	order.ID = uuid.New()
+
+	return order
+}
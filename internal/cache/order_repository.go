@@ -0,0 +1,313 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"golang.org/x/sync/singleflight"
+)
+
+// generationStripes bounds the memory of the invalidation generations, a stripe per value of the last byte of the ID,
+// orders which share a stripe only cost each other a skipped Set
+const generationStripes = 256
+
+// Stats are the counters of an OrderRepository since its creation.
+type Stats struct {
+	Hits   int64
+	Misses int64
+	// Shared are the misses which were served by the query of a concurrent miss of the same order
+	Shared        int64
+	Invalidations int64
+	// Errors are the failed calls of the Cache, a failed Get counts as a miss
+	Errors int64
+}
+
+// OrderRepository serves GetOrder from a Cache and reads through to the next repository on a miss,
+// the concurrent misses of an order share a single GetOrder of next.
+// Every mutating method deletes the order from the cache once next returns, whether it failed or not.
+// GetOrder within a transaction of repository.TxManager bypasses the cache, so nothing uncommitted is cached,
+// and the writes of the transaction invalidate once it has committed, see repository.AfterCommit.
+type OrderRepository struct {
+	next  port.OrderRepository
+	cache Cache
+	group singleflight.Group
+
+	// generations are bumped by every invalidation, a miss only caches what it read if its stripe did not change meanwhile
+	generations [generationStripes]atomic.Uint64
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	shared        atomic.Int64
+	invalidations atomic.Int64
+	errors        atomic.Int64
+}
+
+var _ port.OrderRepository = (*OrderRepository)(nil)
+
+func NewOrderRepository(next port.OrderRepository, cache Cache) (*OrderRepository, error) {
+	if next == nil {
+		return nil, fmt.Errorf("next is nil")
+	}
+
+	if cache == nil {
+		return nil, fmt.Errorf("cache is nil")
+	}
+
+	return &OrderRepository{
+		next:  next,
+		cache: cache,
+	}, nil
+}
+
+func (r *OrderRepository) Stats() Stats {
+	return Stats{
+		Hits:          r.hits.Load(),
+		Misses:        r.misses.Load(),
+		Shared:        r.shared.Load(),
+		Invalidations: r.invalidations.Load(),
+		Errors:        r.errors.Load(),
+	}
+}
+
+// GetOrder does not cache ErrNotFound, the concurrent misses share the context of the first one,
+// so they fail together if it is canceled.
+func (r *OrderRepository) GetOrder(ctx context.Context, orderID uuid.UUID) (domain.Order, error) {
+	if repository.InTx(ctx) {
+		return r.next.GetOrder(ctx, orderID)
+	}
+
+	order, ok, err := r.cache.Get(ctx, orderID)
+	if err != nil {
+		r.errors.Add(1)
+	}
+
+	if ok {
+		r.hits.Add(1)
+		return order, nil
+	}
+
+	r.misses.Add(1)
+
+	// only the closure of the caller which runs the query is called
+	leader := false
+
+	v, err, shared := r.group.Do(orderID.String(), func() (any, error) {
+		leader = true
+		generation := r.generation(orderID).Load()
+
+		order, err := r.next.GetOrder(ctx, orderID)
+		if err != nil {
+			return domain.Order{}, err
+		}
+
+		if r.generation(orderID).Load() != generation {
+			return order, nil
+		}
+
+		if err := r.cache.Set(ctx, order); err != nil {
+			r.errors.Add(1)
+		}
+
+		// an invalidation between the check and Set may have deleted the entry before Set stored it
+		if r.generation(orderID).Load() != generation {
+			if err := r.cache.Delete(context.WithoutCancel(ctx), orderID); err != nil {
+				r.errors.Add(1)
+			}
+		}
+
+		return order, nil
+	})
+	if shared && !leader {
+		r.shared.Add(1)
+	}
+
+	if err != nil {
+		return domain.Order{}, err
+	}
+
+	// the callers sharing a query must not share the slices of the order
+	return cloneOrder(v.(domain.Order)), nil
+}
+
+func (r *OrderRepository) generation(orderID uuid.UUID) *atomic.Uint64 {
+	return &r.generations[orderID[len(orderID)-1]]
+}
+
+// invalidate deletes the orders from the cache, once the transaction of ctx has committed if there is one.
+// A failed Delete is only counted in Stats, as the write itself has succeeded and the entry expires anyway.
+func (r *OrderRepository) invalidate(ctx context.Context, orderIDs ...uuid.UUID) {
+	if len(orderIDs) == 0 {
+		return
+	}
+
+	repository.AfterCommit(ctx, func() {
+		for _, orderID := range orderIDs {
+			r.evict(ctx, orderID)
+		}
+	})
+}
+
+func (r *OrderRepository) evict(ctx context.Context, orderID uuid.UUID) {
+	r.generation(orderID).Add(1)
+	r.group.Forget(orderID.String())
+	r.invalidations.Add(1)
+
+	// the write may have failed because ctx is done, the entry has to go nevertheless
+	if err := r.cache.Delete(context.WithoutCancel(ctx), orderID); err != nil {
+		r.errors.Add(1)
+	}
+}
+
+func (r *OrderRepository) GetOrderSeparateQueries(ctx context.Context, orderID uuid.UUID) (domain.Order, error) {
+	return r.next.GetOrderSeparateQueries(ctx, orderID)
+}
+
+func (r *OrderRepository) GetOrderForUpdate(ctx context.Context, orderID uuid.UUID, mode domain.LockMode) (domain.Order, error) {
+	return r.next.GetOrderForUpdate(ctx, orderID, mode)
+}
+
+func (r *OrderRepository) LockOrder(ctx context.Context, orderID uuid.UUID) error {
+	return r.next.LockOrder(ctx, orderID)
+}
+
+func (r *OrderRepository) SearchOrders(ctx context.Context, filter domain.OrderFilter) ([]domain.Order, error) {
+	return r.next.SearchOrders(ctx, filter)
+}
+
+func (r *OrderRepository) SearchOrdersPage(ctx context.Context, filter domain.OrderFilter, page domain.PageRequest) (domain.OrderPage, error) {
+	return r.next.SearchOrdersPage(ctx, filter, page)
+}
+
+func (r *OrderRepository) CountOrders(ctx context.Context, filter domain.OrderFilter) (int64, error) {
+	return r.next.CountOrders(ctx, filter)
+}
+
+func (r *OrderRepository) GetOrderFacets(ctx context.Context, filter domain.OrderFilter) (domain.OrderFacets, error) {
+	return r.next.GetOrderFacets(ctx, filter)
+}
+
+// InsertOrder invalidates the ID of the order if it has one, the generated IDs cannot be cached yet.
+func (r *OrderRepository) InsertOrder(ctx context.Context, order domain.Order) (uuid.UUID, error) {
+	if order.ID != uuid.Nil {
+		defer r.invalidate(ctx, order.ID)
+	}
+
+	return r.next.InsertOrder(ctx, order)
+}
+
+// InsertOrders invalidates the IDs of the orders which have one, except the ones whose result has Err.
+func (r *OrderRepository) InsertOrders(ctx context.Context, orders []domain.Order) ([]domain.InsertOrderResult, error) {
+	results, err := r.next.InsertOrders(ctx, orders)
+
+	ids := make([]uuid.UUID, 0, len(orders))
+	for i, order := range orders {
+		// a failed import may have failed at any order, so all of them are invalidated
+		if order.ID == uuid.Nil || (err == nil && i < len(results) && results[i].Err != nil) {
+			continue
+		}
+		ids = append(ids, order.ID)
+	}
+	r.invalidate(ctx, ids...)
+
+	return results, err
+}
+
+func (r *OrderRepository) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status domain.OrderStatus, expectedVersion int64) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.UpdateOrderStatus(ctx, orderID, status, expectedVersion)
+}
+
+func (r *OrderRepository) UpdateOrder(ctx context.Context, order domain.Order) error {
+	defer r.invalidate(ctx, order.ID)
+
+	return r.next.UpdateOrder(ctx, order)
+}
+
+func (r *OrderRepository) AddOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.AddOrderItems(ctx, orderID, items, expectedVersion)
+}
+
+func (r *OrderRepository) ReplaceOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.ReplaceOrderItems(ctx, orderID, items, expectedVersion)
+}
+
+func (r *OrderRepository) SetTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.SetTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *OrderRepository) AddTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.AddTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *OrderRepository) RemoveTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.RemoveTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *OrderRepository) SetURL(ctx context.Context, orderID uuid.UUID, u *url.URL, expectedVersion int64) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.SetURL(ctx, orderID, u, expectedVersion)
+}
+
+func (r *OrderRepository) SetPayload(ctx context.Context, orderID uuid.UUID, payload, payloadB []byte, expectedVersion int64) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.SetPayload(ctx, orderID, payload, payloadB, expectedVersion)
+}
+
+func (r *OrderRepository) SoftDeleteOrder(ctx context.Context, orderID uuid.UUID) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.SoftDeleteOrder(ctx, orderID)
+}
+
+func (r *OrderRepository) SoftDeleteOrderItem(ctx context.Context, orderID, productID uuid.UUID) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.SoftDeleteOrderItem(ctx, orderID, productID)
+}
+
+func (r *OrderRepository) RestoreOrder(ctx context.Context, orderID uuid.UUID) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.RestoreOrder(ctx, orderID)
+}
+
+func (r *OrderRepository) RestoreOrderItem(ctx context.Context, orderID, productID uuid.UUID) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.RestoreOrderItem(ctx, orderID, productID)
+}
+
+func (r *OrderRepository) DeleteOrder(ctx context.Context, orderID uuid.UUID) error {
+	defer r.invalidate(ctx, orderID)
+
+	return r.next.DeleteOrder(ctx, orderID)
+}
+
+// Purge does not invalidate, it only deletes soft-deleted orders and items, which GetOrder does not return.
+func (r *OrderRepository) Purge(ctx context.Context, olderThan time.Duration, batchSize int) (domain.PurgeResult, error) {
+	return r.next.Purge(ctx, olderThan, batchSize)
+}
+
+func (r *OrderRepository) GetOrderHistory(ctx context.Context, orderID uuid.UUID) ([]domain.OrderHistoryEntry, error) {
+	return r.next.GetOrderHistory(ctx, orderID)
+}
@@ -0,0 +1,239 @@
+package cache_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/cache"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/inmemory"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/nikolayk812/sqlcpp/internal/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) port.OrderRepository {
+		return newCachedRepository(t, inmemory.NewOrder())
+	})
+}
+
+func TestOrderRepository(t *testing.T) {
+	ctx := t.Context()
+	repo := newCachedRepository(t, inmemory.NewOrder())
+
+	orderID, err := repo.InsertOrder(ctx, repositorytest.RandomOrder())
+	require.NoError(t, err)
+
+	order, err := repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+
+	cached, err := repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, order, cached)
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 1}, repo.Stats())
+
+	require.NoError(t, repo.SetTags(ctx, orderID, []string{"cached"}, order.Version))
+
+	updated, err := repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cached"}, updated.Tags)
+
+	// a failed write invalidates too
+	err = repo.SetTags(ctx, orderID, []string{"stale"}, order.Version)
+	require.ErrorIs(t, err, repository.ErrConcurrentModification)
+
+	require.NoError(t, repo.DeleteOrder(ctx, orderID))
+
+	_, err = repo.GetOrder(ctx, orderID)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+	_, err = repo.GetOrder(ctx, orderID)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 4, Invalidations: 3}, repo.Stats())
+}
+
+func TestOrderRepository_ConcurrentMisses(t *testing.T) {
+	ctx := t.Context()
+
+	next := &blockingRepository{OrderRepository: inmemory.NewOrder(), release: make(chan struct{})}
+	repo := newCachedRepository(t, next)
+
+	orderID, err := next.InsertOrder(ctx, repositorytest.RandomOrder())
+	require.NoError(t, err)
+
+	const callers = 10
+
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Go(func() {
+			_, err := repo.GetOrder(ctx, orderID)
+			assert.NoError(t, err)
+		})
+	}
+
+	require.Eventually(t, func() bool {
+		return repo.Stats().Misses == callers
+	}, time.Second, time.Millisecond)
+	// let the last callers reach the shared query
+	time.Sleep(10 * time.Millisecond)
+
+	close(next.release)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), next.calls.Load())
+	assert.Equal(t, cache.Stats{Misses: callers, Shared: callers - 1}, repo.Stats())
+}
+
+func TestOrderRepository_WriteDuringMiss(t *testing.T) {
+	ctx := t.Context()
+
+	next := &blockingRepository{OrderRepository: inmemory.NewOrder(), release: make(chan struct{})}
+	repo := newCachedRepository(t, next)
+
+	orderID, err := next.InsertOrder(ctx, repositorytest.RandomOrder())
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		_, err := repo.GetOrder(ctx, orderID)
+		assert.NoError(t, err)
+	}()
+
+	require.Eventually(t, func() bool {
+		return next.calls.Load() == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, repo.SetTags(ctx, orderID, []string{"written"}, 1))
+
+	close(next.release)
+	<-done
+
+	// the miss read the order before the write, so it must not have cached it
+	order, err := repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"written"}, order.Tags)
+	assert.Equal(t, int64(0), repo.Stats().Hits)
+}
+
+func TestOrderRepository_InsertOrders(t *testing.T) {
+	ctx := t.Context()
+	repo := newCachedRepository(t, inmemory.NewOrder())
+
+	existingID, err := repo.InsertOrder(ctx, randomOrder())
+	require.NoError(t, err)
+
+	_, err = repo.GetOrder(ctx, existingID)
+	require.NoError(t, err)
+
+	taken := randomOrder()
+	taken.ID = existingID
+
+	results, err := repo.InsertOrders(ctx, []domain.Order{taken, randomOrder(), repositorytest.RandomOrder()})
+	require.NoError(t, err)
+	require.ErrorIs(t, results[0].Err, repository.ErrAlreadyExists)
+
+	// the order with an Err and the one without an ID are not invalidated
+	assert.Equal(t, int64(2), repo.Stats().Invalidations)
+
+	_, err = repo.GetOrder(ctx, existingID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), repo.Stats().Hits)
+}
+
+func TestOrderRepository_WriteDuringSet(t *testing.T) {
+	ctx := t.Context()
+
+	lru, err := cache.NewLRU(100, time.Minute)
+	require.NoError(t, err)
+
+	c := &hookedCache{Cache: lru}
+	next := inmemory.NewOrder()
+
+	repo, err := cache.NewOrderRepository(next, c)
+	require.NoError(t, err)
+
+	orderID, err := next.InsertOrder(ctx, repositorytest.RandomOrder())
+	require.NoError(t, err)
+
+	// the write invalidates after the miss checked the generation, but before Set stored the order
+	c.beforeSet = func() {
+		c.beforeSet = nil
+		require.NoError(t, repo.SetTags(ctx, orderID, []string{"written"}, 1))
+	}
+
+	_, err = repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, lru.Len())
+
+	order, err := repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"written"}, order.Tags)
+}
+
+func TestNewOrderRepository(t *testing.T) {
+	c, err := cache.NewLRU(1, time.Minute)
+	require.NoError(t, err)
+
+	_, err = cache.NewOrderRepository(nil, c)
+	require.Error(t, err)
+
+	_, err = cache.NewOrderRepository(inmemory.NewOrder(), nil)
+	require.Error(t, err)
+}
+
+func newCachedRepository(t *testing.T, next port.OrderRepository) *cache.OrderRepository {
+	t.Helper()
+
+	c, err := cache.NewLRU(100, time.Minute)
+	require.NoError(t, err)
+
+	repo, err := cache.NewOrderRepository(next, c)
+	require.NoError(t, err)
+
+	return repo
+}
+
+// blockingRepository holds GetOrder until release is closed.
+type blockingRepository struct {
+	port.OrderRepository
+
+	release chan struct{}
+	calls   atomic.Int64
+}
+
+func (r *blockingRepository) GetOrder(ctx context.Context, orderID uuid.UUID) (domain.Order, error) {
+	r.calls.Add(1)
+
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return domain.Order{}, ctx.Err()
+	}
+
+	return r.OrderRepository.GetOrder(ctx, orderID)
+}
+
+// hookedCache calls beforeSet, if set, at the start of Set.
+type hookedCache struct {
+	cache.Cache
+
+	beforeSet func()
+}
+
+func (c *hookedCache) Set(ctx context.Context, order domain.Order) error {
+	if c.beforeSet != nil {
+		c.beforeSet()
+	}
+
+	return c.Cache.Set(ctx, order)
+}
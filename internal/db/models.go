@@ -12,18 +12,33 @@ import (
 )
 
 type Order struct {
-	ID            uuid.UUID
-	OwnerID       string
-	PriceAmount   decimal.Decimal
-	PriceCurrency string
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	DeletedAt     *time.Time
-	Url           *string
-	Tags          []string
-	Status        string
-	Payload       []byte
-	Payloadb      []byte
+	ID             uuid.UUID
+	OwnerID        string
+	PriceAmount    decimal.Decimal
+	PriceCurrency  string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      *time.Time
+	Url            *string
+	Tags           []string
+	Status         string
+	Payload        []byte
+	Payloadb       []byte
+	Version        int64
+	IdempotencyKey *string
+	RequestHash    *string
+}
+
+type OrderEvent struct {
+	ID        int64
+	OrderID   uuid.UUID
+	ProductID *uuid.UUID
+	EventType string
+	OldValue  string
+	NewValue  string
+	Actor     string
+	Reason    string
+	CreatedAt time.Time
 }
 
 type OrderItem struct {
@@ -34,3 +49,12 @@ type OrderItem struct {
 	CreatedAt     time.Time
 	DeletedAt     *time.Time
 }
+
+type OrderOutbox struct {
+	ID          int64
+	EventType   string
+	OrderID     uuid.UUID
+	Payload     []byte
+	CreatedAt   time.Time
+	PublishedAt *time.Time
+}
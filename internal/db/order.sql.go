@@ -14,6 +14,172 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+const AddOrderItem = `-- name: AddOrderItem :execresult
+INSERT INTO order_items (order_id, product_id, price_amount, price_currency)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (order_id, product_id) DO UPDATE
+    SET price_amount   = excluded.price_amount,
+        price_currency = excluded.price_currency,
+        created_at     = CURRENT_TIMESTAMP,
+        deleted_at     = NULL
+WHERE order_items.deleted_at IS NOT NULL
+`
+
+type AddOrderItemParams struct {
+	OrderID       uuid.UUID
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+}
+
+// revives a soft-deleted item, affects no rows if the item is already in the order
+func (q *Queries) AddOrderItem(ctx context.Context, arg AddOrderItemParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, AddOrderItem,
+		arg.OrderID,
+		arg.ProductID,
+		arg.PriceAmount,
+		arg.PriceCurrency,
+	)
+}
+
+const AddOrderTags = `-- name: AddOrderTags :execresult
+UPDATE orders
+SET tags = COALESCE(tags, '{}') || ARRAY(SELECT tag
+                                         FROM unnest($1::TEXT[]) WITH ORDINALITY AS t(tag, ord)
+                                         WHERE NOT tag = ANY (COALESCE(tags, '{}'))
+                                         ORDER BY ord)
+WHERE id = $2
+  AND deleted_at IS NULL
+`
+
+type AddOrderTagsParams struct {
+	Tags []string
+	ID   uuid.UUID
+}
+
+func (q *Queries) AddOrderTags(ctx context.Context, arg AddOrderTagsParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, AddOrderTags, arg.Tags, arg.ID)
+}
+
+const ClaimOutboxEvents = `-- name: ClaimOutboxEvents :many
+SELECT id, event_type, order_id, payload, created_at
+FROM order_outbox
+WHERE published_at IS NULL
+ORDER BY id
+LIMIT $1 FOR UPDATE SKIP LOCKED
+`
+
+type ClaimOutboxEventsRow struct {
+	ID        int64
+	EventType string
+	OrderID   uuid.UUID
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+func (q *Queries) ClaimOutboxEvents(ctx context.Context, limit int32) ([]ClaimOutboxEventsRow, error) {
+	rows, err := q.db.Query(ctx, ClaimOutboxEvents, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimOutboxEventsRow
+	for rows.Next() {
+		var i ClaimOutboxEventsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventType,
+			&i.OrderID,
+			&i.Payload,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const CountOrders = `-- name: CountOrders :one
+SELECT COUNT(*)
+FROM orders o
+WHERE (
+          ($1::UUID[] IS NULL OR o.id = ANY ($1))
+              AND
+          ($2::VARCHAR[] IS NULL OR o.owner_id = ANY ($2))
+              AND
+          ($3::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                    FROM unnest($3) AS url_pattern
+                                                    WHERE o.url ILIKE '%' || url_pattern || '%'))
+              AND
+          ($4::TEXT[] IS NULL OR o.status = ANY ($4))
+              AND
+          ($5::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                            FROM unnest($5) AS tag
+                                            WHERE tag = ANY (o.tags)))
+              AND
+          (
+              ($6::TIMESTAMP IS NULL OR o.created_at >= $6) AND
+              ($7::TIMESTAMP IS NULL OR o.created_at < $7)
+              )
+              AND
+          (
+              ($8::TIMESTAMP IS NULL OR o.updated_at >= $8) AND
+              ($9::TIMESTAMP IS NULL OR o.updated_at < $9)
+              )
+              AND
+          CASE $10::TEXT
+              WHEN 'include' THEN TRUE
+              WHEN 'only' THEN o.deleted_at IS NOT NULL
+              ELSE o.deleted_at IS NULL
+              END
+              AND
+          (
+              ($11::TIMESTAMP IS NULL OR o.deleted_at >= $11) AND
+              ($12::TIMESTAMP IS NULL OR o.deleted_at < $12)
+              )
+          )
+  AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND oi.deleted_at IS NULL)
+`
+
+type CountOrdersParams struct {
+	Ids           []uuid.UUID
+	OwnerIds      []string
+	UrlPatterns   []string
+	Statuses      []string
+	Tags          []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	DeletedMode   string
+	DeletedAfter  *time.Time
+	DeletedBefore *time.Time
+}
+
+func (q *Queries) CountOrders(ctx context.Context, arg CountOrdersParams) (int64, error) {
+	row := q.db.QueryRow(ctx, CountOrders,
+		arg.Ids,
+		arg.OwnerIds,
+		arg.UrlPatterns,
+		arg.Statuses,
+		arg.Tags,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.UpdatedAfter,
+		arg.UpdatedBefore,
+		arg.DeletedMode,
+		arg.DeletedAfter,
+		arg.DeletedBefore,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const DeleteOrder = `-- name: DeleteOrder :execresult
 DELETE
 FROM orders
@@ -34,6 +200,16 @@ func (q *Queries) DeleteOrderItems(ctx context.Context, orderID uuid.UUID) (pgco
 	return q.db.Exec(ctx, DeleteOrderItems, orderID)
 }
 
+const DeleteOrdersByIDs = `-- name: DeleteOrdersByIDs :execresult
+DELETE
+FROM orders
+WHERE id = ANY ($1::UUID[])
+`
+
+func (q *Queries) DeleteOrdersByIDs(ctx context.Context, ids []uuid.UUID) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, DeleteOrdersByIDs, ids)
+}
+
 const GetOrder = `-- name: GetOrder :one
 SELECT id,
        owner_id,
@@ -46,7 +222,8 @@ SELECT id,
        payloadb,
        deleted_at,
        price_amount,
-       price_currency
+       price_currency,
+       version
 FROM orders
 WHERE id = $1
   AND deleted_at IS NULL
@@ -65,6 +242,7 @@ type GetOrderRow struct {
 	DeletedAt     *time.Time
 	PriceAmount   decimal.Decimal
 	PriceCurrency string
+	Version       int64
 }
 
 func (q *Queries) GetOrder(ctx context.Context, id uuid.UUID) (GetOrderRow, error) {
@@ -83,6 +261,366 @@ func (q *Queries) GetOrder(ctx context.Context, id uuid.UUID) (GetOrderRow, erro
 		&i.DeletedAt,
 		&i.PriceAmount,
 		&i.PriceCurrency,
+		&i.Version,
+	)
+	return i, err
+}
+
+const GetOrderByIdempotencyKey = `-- name: GetOrderByIdempotencyKey :one
+SELECT id, request_hash
+FROM orders
+WHERE owner_id = $1
+  AND idempotency_key = $2
+`
+
+type GetOrderByIdempotencyKeyParams struct {
+	OwnerID        string
+	IdempotencyKey *string
+}
+
+type GetOrderByIdempotencyKeyRow struct {
+	ID          uuid.UUID
+	RequestHash *string
+}
+
+// soft-deleted orders are matched too, a replay must not create the order again
+func (q *Queries) GetOrderByIdempotencyKey(ctx context.Context, arg GetOrderByIdempotencyKeyParams) (GetOrderByIdempotencyKeyRow, error) {
+	row := q.db.QueryRow(ctx, GetOrderByIdempotencyKey, arg.OwnerID, arg.IdempotencyKey)
+	var i GetOrderByIdempotencyKeyRow
+	err := row.Scan(&i.ID, &i.RequestHash)
+	return i, err
+}
+
+const GetOrderEvents = `-- name: GetOrderEvents :many
+SELECT id,
+       order_id,
+       product_id,
+       event_type,
+       old_value,
+       new_value,
+       actor,
+       reason,
+       created_at
+FROM order_events
+WHERE order_id = $1
+ORDER BY created_at, id
+`
+
+func (q *Queries) GetOrderEvents(ctx context.Context, orderID uuid.UUID) ([]OrderEvent, error) {
+	rows, err := q.db.Query(ctx, GetOrderEvents, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OrderEvent
+	for rows.Next() {
+		var i OrderEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.ProductID,
+			&i.EventType,
+			&i.OldValue,
+			&i.NewValue,
+			&i.Actor,
+			&i.Reason,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetOrderFacets = `-- name: GetOrderFacets :many
+WITH filtered AS (SELECT o.id, o.owner_id, o.status, o.tags, o.price_amount, o.price_currency
+                  FROM orders o
+                  WHERE (
+                            ($1::UUID[] IS NULL OR o.id = ANY ($1))
+                                AND
+                            ($2::VARCHAR[] IS NULL OR o.owner_id = ANY ($2))
+                                AND
+                            ($3::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                                      FROM unnest($3) AS url_pattern
+                                                                      WHERE o.url ILIKE '%' || url_pattern || '%'))
+                                AND
+                            ($4::TEXT[] IS NULL OR o.status = ANY ($4))
+                                AND
+                            ($5::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                              FROM unnest($5) AS tag
+                                                              WHERE tag = ANY (o.tags)))
+                                AND
+                            (
+                                ($6::TIMESTAMP IS NULL OR o.created_at >= $6) AND
+                                ($7::TIMESTAMP IS NULL OR o.created_at < $7)
+                                )
+                                AND
+                            (
+                                ($8::TIMESTAMP IS NULL OR o.updated_at >= $8) AND
+                                ($9::TIMESTAMP IS NULL OR o.updated_at < $9)
+                                )
+                                AND
+                            CASE $10::TEXT
+                                WHEN 'include' THEN TRUE
+                                WHEN 'only' THEN o.deleted_at IS NOT NULL
+                                ELSE o.deleted_at IS NULL
+                                END
+                                AND
+                            (
+                                ($11::TIMESTAMP IS NULL OR o.deleted_at >= $11) AND
+                                ($12::TIMESTAMP IS NULL OR o.deleted_at < $12)
+                                )
+                            )
+                    AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND oi.deleted_at IS NULL))
+SELECT 'status'::TEXT AS facet, f.status::TEXT AS value, COUNT(*) AS order_count, 0::DECIMAL AS price_sum
+FROM filtered f
+GROUP BY f.status
+UNION ALL
+SELECT 'currency', f.price_currency, COUNT(*), SUM(f.price_amount)
+FROM filtered f
+GROUP BY f.price_currency
+UNION ALL
+SELECT 'owner', f.owner_id, COUNT(*), 0
+FROM filtered f
+GROUP BY f.owner_id
+UNION ALL
+SELECT 'tag', t.tag, COUNT(DISTINCT f.id), 0
+FROM filtered f
+         CROSS JOIN LATERAL unnest(f.tags) AS t(tag)
+GROUP BY t.tag
+`
+
+type GetOrderFacetsParams struct {
+	Ids           []uuid.UUID
+	OwnerIds      []string
+	UrlPatterns   []string
+	Statuses      []string
+	Tags          []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	DeletedMode   string
+	DeletedAfter  *time.Time
+	DeletedBefore *time.Time
+}
+
+type GetOrderFacetsRow struct {
+	Facet      string
+	Value      string
+	OrderCount int64
+	PriceSum   decimal.Decimal
+}
+
+func (q *Queries) GetOrderFacets(ctx context.Context, arg GetOrderFacetsParams) ([]GetOrderFacetsRow, error) {
+	rows, err := q.db.Query(ctx, GetOrderFacets,
+		arg.Ids,
+		arg.OwnerIds,
+		arg.UrlPatterns,
+		arg.Statuses,
+		arg.Tags,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.UpdatedAfter,
+		arg.UpdatedBefore,
+		arg.DeletedMode,
+		arg.DeletedAfter,
+		arg.DeletedBefore,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetOrderFacetsRow
+	for rows.Next() {
+		var i GetOrderFacetsRow
+		if err := rows.Scan(
+			&i.Facet,
+			&i.Value,
+			&i.OrderCount,
+			&i.PriceSum,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetOrderForUpdate = `-- name: GetOrderForUpdate :one
+SELECT id,
+       owner_id,
+       created_at,
+       updated_at,
+       url,
+       status,
+       tags,
+       payload,
+       payloadb,
+       deleted_at,
+       price_amount,
+       price_currency,
+       version
+FROM orders
+WHERE id = $1
+  AND deleted_at IS NULL
+    FOR UPDATE
+`
+
+type GetOrderForUpdateRow struct {
+	ID            uuid.UUID
+	OwnerID       string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Url           *string
+	Status        string
+	Tags          []string
+	Payload       []byte
+	Payloadb      []byte
+	DeletedAt     *time.Time
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Version       int64
+}
+
+func (q *Queries) GetOrderForUpdate(ctx context.Context, id uuid.UUID) (GetOrderForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, GetOrderForUpdate, id)
+	var i GetOrderForUpdateRow
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Url,
+		&i.Status,
+		&i.Tags,
+		&i.Payload,
+		&i.Payloadb,
+		&i.DeletedAt,
+		&i.PriceAmount,
+		&i.PriceCurrency,
+		&i.Version,
+	)
+	return i, err
+}
+
+const GetOrderForUpdateNoWait = `-- name: GetOrderForUpdateNoWait :one
+SELECT id,
+       owner_id,
+       created_at,
+       updated_at,
+       url,
+       status,
+       tags,
+       payload,
+       payloadb,
+       deleted_at,
+       price_amount,
+       price_currency,
+       version
+FROM orders
+WHERE id = $1
+  AND deleted_at IS NULL
+    FOR UPDATE NOWAIT
+`
+
+type GetOrderForUpdateNoWaitRow struct {
+	ID            uuid.UUID
+	OwnerID       string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Url           *string
+	Status        string
+	Tags          []string
+	Payload       []byte
+	Payloadb      []byte
+	DeletedAt     *time.Time
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Version       int64
+}
+
+func (q *Queries) GetOrderForUpdateNoWait(ctx context.Context, id uuid.UUID) (GetOrderForUpdateNoWaitRow, error) {
+	row := q.db.QueryRow(ctx, GetOrderForUpdateNoWait, id)
+	var i GetOrderForUpdateNoWaitRow
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Url,
+		&i.Status,
+		&i.Tags,
+		&i.Payload,
+		&i.Payloadb,
+		&i.DeletedAt,
+		&i.PriceAmount,
+		&i.PriceCurrency,
+		&i.Version,
+	)
+	return i, err
+}
+
+const GetOrderForUpdateSkipLocked = `-- name: GetOrderForUpdateSkipLocked :one
+SELECT id,
+       owner_id,
+       created_at,
+       updated_at,
+       url,
+       status,
+       tags,
+       payload,
+       payloadb,
+       deleted_at,
+       price_amount,
+       price_currency,
+       version
+FROM orders
+WHERE id = $1
+  AND deleted_at IS NULL
+    FOR UPDATE SKIP LOCKED
+`
+
+type GetOrderForUpdateSkipLockedRow struct {
+	ID            uuid.UUID
+	OwnerID       string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Url           *string
+	Status        string
+	Tags          []string
+	Payload       []byte
+	Payloadb      []byte
+	DeletedAt     *time.Time
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Version       int64
+}
+
+func (q *Queries) GetOrderForUpdateSkipLocked(ctx context.Context, id uuid.UUID) (GetOrderForUpdateSkipLockedRow, error) {
+	row := q.db.QueryRow(ctx, GetOrderForUpdateSkipLocked, id)
+	var i GetOrderForUpdateSkipLockedRow
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Url,
+		&i.Status,
+		&i.Tags,
+		&i.Payload,
+		&i.Payloadb,
+		&i.DeletedAt,
+		&i.PriceAmount,
+		&i.PriceCurrency,
+		&i.Version,
 	)
 	return i, err
 }
@@ -138,6 +676,7 @@ SELECT o.id,
        o.payloadb,
        o.price_amount,
        o.price_currency,
+       o.version,
        oi.product_id,
        oi.price_amount   AS item_price_amount,
        oi.price_currency AS item_price_currency
@@ -160,6 +699,7 @@ type GetOrderJoinItemsRow struct {
 	Payloadb          []byte
 	PriceAmount       decimal.Decimal
 	PriceCurrency     string
+	Version           int64
 	ProductID         uuid.UUID
 	ItemPriceAmount   decimal.Decimal
 	ItemPriceCurrency string
@@ -186,13 +726,65 @@ func (q *Queries) GetOrderJoinItems(ctx context.Context, id uuid.UUID) ([]GetOrd
 			&i.Payloadb,
 			&i.PriceAmount,
 			&i.PriceCurrency,
+			&i.Version,
 			&i.ProductID,
 			&i.ItemPriceAmount,
 			&i.ItemPriceCurrency,
 		); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetOrderVersionForUpdate = `-- name: GetOrderVersionForUpdate :one
+SELECT version
+FROM orders
+WHERE id = $1
+  AND deleted_at IS NULL
+    FOR UPDATE
+`
+
+func (q *Queries) GetOrderVersionForUpdate(ctx context.Context, id uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, GetOrderVersionForUpdate, id)
+	var version int64
+	err := row.Scan(&version)
+	return version, err
+}
+
+const GetPurgeableOrderIDs = `-- name: GetPurgeableOrderIDs :many
+SELECT id
+FROM orders
+WHERE deleted_at < NOW() - make_interval(secs => $1::FLOAT8)
+  AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = orders.id)
+ORDER BY deleted_at
+LIMIT $2 FOR UPDATE SKIP LOCKED
+`
+
+type GetPurgeableOrderIDsParams struct {
+	RetentionSeconds float64
+	BatchSize        int32
+}
+
+// locks up to batch_size orders soft-deleted longer than retention_seconds ago whose items are purged,
+// skipping the orders locked by others
+func (q *Queries) GetPurgeableOrderIDs(ctx context.Context, arg GetPurgeableOrderIDsParams) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, GetPurgeableOrderIDs, arg.RetentionSeconds, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -201,23 +793,29 @@ func (q *Queries) GetOrderJoinItems(ctx context.Context, id uuid.UUID) ([]GetOrd
 }
 
 const InsertOrder = `-- name: InsertOrder :one
-INSERT INTO orders (owner_id, url, tags, payload, payloadb, price_amount, price_currency)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
+INSERT INTO orders (id, owner_id, url, tags, payload, payloadb, price_amount, price_currency, idempotency_key, request_hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT DO NOTHING
 RETURNING id
 `
 
 type InsertOrderParams struct {
-	OwnerID       string
-	Url           *string
-	Tags          []string
-	Payload       []byte
-	Payloadb      []byte
-	PriceAmount   decimal.Decimal
-	PriceCurrency string
+	ID             uuid.UUID
+	OwnerID        string
+	Url            *string
+	Tags           []string
+	Payload        []byte
+	Payloadb       []byte
+	PriceAmount    decimal.Decimal
+	PriceCurrency  string
+	IdempotencyKey *string
+	RequestHash    *string
 }
 
+// returns no rows if the order ID is taken or the owner already has an order with the same idempotency key
 func (q *Queries) InsertOrder(ctx context.Context, arg InsertOrderParams) (uuid.UUID, error) {
 	row := q.db.QueryRow(ctx, InsertOrder,
+		arg.ID,
 		arg.OwnerID,
 		arg.Url,
 		arg.Tags,
@@ -225,12 +823,42 @@ func (q *Queries) InsertOrder(ctx context.Context, arg InsertOrderParams) (uuid.
 		arg.Payloadb,
 		arg.PriceAmount,
 		arg.PriceCurrency,
+		arg.IdempotencyKey,
+		arg.RequestHash,
 	)
 	var id uuid.UUID
 	err := row.Scan(&id)
 	return id, err
 }
 
+const InsertOrderEvent = `-- name: InsertOrderEvent :exec
+INSERT INTO order_events (order_id, product_id, event_type, old_value, new_value, actor, reason)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type InsertOrderEventParams struct {
+	OrderID   uuid.UUID
+	ProductID *uuid.UUID
+	EventType string
+	OldValue  string
+	NewValue  string
+	Actor     string
+	Reason    string
+}
+
+func (q *Queries) InsertOrderEvent(ctx context.Context, arg InsertOrderEventParams) error {
+	_, err := q.db.Exec(ctx, InsertOrderEvent,
+		arg.OrderID,
+		arg.ProductID,
+		arg.EventType,
+		arg.OldValue,
+		arg.NewValue,
+		arg.Actor,
+		arg.Reason,
+	)
+	return err
+}
+
 const InsertOrderItem = `-- name: InsertOrderItem :exec
 INSERT INTO order_items (order_id, product_id, price_amount, price_currency)
 VALUES ($1, $2, $3, $4)
@@ -253,6 +881,158 @@ func (q *Queries) InsertOrderItem(ctx context.Context, arg InsertOrderItemParams
 	return err
 }
 
+const InsertOutboxEvent = `-- name: InsertOutboxEvent :exec
+INSERT INTO order_outbox (event_type, order_id, payload)
+VALUES ($1, $2, $3)
+`
+
+type InsertOutboxEventParams struct {
+	EventType string
+	OrderID   uuid.UUID
+	Payload   []byte
+}
+
+func (q *Queries) InsertOutboxEvent(ctx context.Context, arg InsertOutboxEventParams) error {
+	_, err := q.db.Exec(ctx, InsertOutboxEvent, arg.EventType, arg.OrderID, arg.Payload)
+	return err
+}
+
+const LockOrder = `-- name: LockOrder :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::UUID::TEXT, 0))
+`
+
+// the advisory lock is released at the end of the transaction, its key is a hash of the order ID
+func (q *Queries) LockOrder(ctx context.Context, orderID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, LockOrder, orderID)
+	return err
+}
+
+const MarkOutboxEventsPublished = `-- name: MarkOutboxEventsPublished :execresult
+UPDATE order_outbox
+SET published_at = NOW()
+WHERE id = ANY ($1::BIGINT[])
+`
+
+func (q *Queries) MarkOutboxEventsPublished(ctx context.Context, ids []int64) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, MarkOutboxEventsPublished, ids)
+}
+
+const OrderExists = `-- name: OrderExists :one
+SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1 AND deleted_at IS NULL)::BOOLEAN AS order_exists
+`
+
+func (q *Queries) OrderExists(ctx context.Context, id uuid.UUID) (bool, error) {
+	row := q.db.QueryRow(ctx, OrderExists, id)
+	var order_exists bool
+	err := row.Scan(&order_exists)
+	return order_exists, err
+}
+
+const PurgeOrderItems = `-- name: PurgeOrderItems :execresult
+DELETE
+FROM order_items
+WHERE (order_id, product_id) IN (SELECT oi.order_id, oi.product_id
+                                 FROM order_items oi
+                                          JOIN orders o ON o.id = oi.order_id
+                                 WHERE LEAST(oi.deleted_at, o.deleted_at) < NOW() - make_interval(secs => $1::FLOAT8)
+                                 ORDER BY LEAST(oi.deleted_at, o.deleted_at)
+                                 LIMIT $2 FOR UPDATE OF oi SKIP LOCKED)
+`
+
+type PurgeOrderItemsParams struct {
+	RetentionSeconds float64
+	BatchSize        int32
+}
+
+// hard-deletes up to batch_size items soft-deleted, themselves or with their order, longer than retention_seconds ago
+func (q *Queries) PurgeOrderItems(ctx context.Context, arg PurgeOrderItemsParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, PurgeOrderItems, arg.RetentionSeconds, arg.BatchSize)
+}
+
+const RemoveOrderTags = `-- name: RemoveOrderTags :execresult
+UPDATE orders
+SET tags = ARRAY(SELECT tag
+                 FROM unnest(tags) WITH ORDINALITY AS t(tag, ord)
+                 WHERE NOT tag = ANY ($1::TEXT[])
+                 ORDER BY ord)
+WHERE id = $2
+  AND deleted_at IS NULL
+`
+
+type RemoveOrderTagsParams struct {
+	Tags []string
+	ID   uuid.UUID
+}
+
+func (q *Queries) RemoveOrderTags(ctx context.Context, arg RemoveOrderTagsParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, RemoveOrderTags, arg.Tags, arg.ID)
+}
+
+const ReplaceOrderItem = `-- name: ReplaceOrderItem :exec
+INSERT INTO order_items (order_id, product_id, price_amount, price_currency)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (order_id, product_id) DO UPDATE
+    SET price_amount   = excluded.price_amount,
+        price_currency = excluded.price_currency,
+        created_at     = CASE
+                             WHEN order_items.deleted_at IS NULL THEN order_items.created_at
+                             ELSE CURRENT_TIMESTAMP END,
+        deleted_at     = NULL
+`
+
+type ReplaceOrderItemParams struct {
+	OrderID       uuid.UUID
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+}
+
+// inserts the item, or overwrites it whether it is live or soft-deleted
+func (q *Queries) ReplaceOrderItem(ctx context.Context, arg ReplaceOrderItemParams) error {
+	_, err := q.db.Exec(ctx, ReplaceOrderItem,
+		arg.OrderID,
+		arg.ProductID,
+		arg.PriceAmount,
+		arg.PriceCurrency,
+	)
+	return err
+}
+
+const RestoreOrder = `-- name: RestoreOrder :execresult
+UPDATE orders
+SET deleted_at   = NULL,
+    price_amount = (SELECT COALESCE(SUM(price_amount), 0)
+                    FROM order_items
+                    WHERE order_id = $1
+                      AND deleted_at IS NULL),
+    updated_at   = NOW(),
+    version      = version + 1
+WHERE id = $1
+  AND deleted_at IS NOT NULL
+`
+
+// the price is recomputed from the items which are not deleted
+func (q *Queries) RestoreOrder(ctx context.Context, id uuid.UUID) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, RestoreOrder, id)
+}
+
+const RestoreOrderItem = `-- name: RestoreOrderItem :execresult
+UPDATE order_items
+SET deleted_at = NULL
+WHERE order_id = $1
+  AND product_id = $2
+  AND deleted_at IS NOT NULL
+`
+
+type RestoreOrderItemParams struct {
+	OrderID   uuid.UUID
+	ProductID uuid.UUID
+}
+
+func (q *Queries) RestoreOrderItem(ctx context.Context, arg RestoreOrderItemParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, RestoreOrderItem, arg.OrderID, arg.ProductID)
+}
+
 const SearchOrders = `-- name: SearchOrders :many
 SELECT o.id,
        o.owner_id,
@@ -265,11 +1045,13 @@ SELECT o.id,
        o.payloadb,
        o.price_amount,
        o.price_currency,
+       o.version,
+       o.deleted_at,
        oi.product_id,
        oi.price_amount   AS item_price_amount,
        oi.price_currency AS item_price_currency
 FROM orders o
-         JOIN order_items oi ON o.id = oi.order_id
+         JOIN order_items oi ON o.id = oi.order_id AND oi.deleted_at IS NULL
 WHERE (
           ($1::UUID[] IS NULL OR o.id = ANY ($1))
               AND
@@ -294,7 +1076,19 @@ WHERE (
               ($8::TIMESTAMP IS NULL OR o.updated_at >= $8) AND
               ($9::TIMESTAMP IS NULL OR o.updated_at < $9)
               )
+              AND
+          CASE $10::TEXT
+              WHEN 'include' THEN TRUE
+              WHEN 'only' THEN o.deleted_at IS NOT NULL
+              ELSE o.deleted_at IS NULL
+              END
+              AND
+          (
+              ($11::TIMESTAMP IS NULL OR o.deleted_at >= $11) AND
+              ($12::TIMESTAMP IS NULL OR o.deleted_at < $12)
+              )
           )
+ORDER BY o.created_at, o.id, oi.product_id
 `
 
 type SearchOrdersParams struct {
@@ -307,6 +1101,9 @@ type SearchOrdersParams struct {
 	CreatedBefore *time.Time
 	UpdatedAfter  *time.Time
 	UpdatedBefore *time.Time
+	DeletedMode   string
+	DeletedAfter  *time.Time
+	DeletedBefore *time.Time
 }
 
 type SearchOrdersRow struct {
@@ -321,6 +1118,8 @@ type SearchOrdersRow struct {
 	Payloadb          []byte
 	PriceAmount       decimal.Decimal
 	PriceCurrency     string
+	Version           int64
+	DeletedAt         *time.Time
 	ProductID         uuid.UUID
 	ItemPriceAmount   decimal.Decimal
 	ItemPriceCurrency string
@@ -337,6 +1136,9 @@ func (q *Queries) SearchOrders(ctx context.Context, arg SearchOrdersParams) ([]S
 		arg.CreatedBefore,
 		arg.UpdatedAfter,
 		arg.UpdatedBefore,
+		arg.DeletedMode,
+		arg.DeletedAfter,
+		arg.DeletedBefore,
 	)
 	if err != nil {
 		return nil, err
@@ -357,6 +1159,194 @@ func (q *Queries) SearchOrders(ctx context.Context, arg SearchOrdersParams) ([]S
 			&i.Payloadb,
 			&i.PriceAmount,
 			&i.PriceCurrency,
+			&i.Version,
+			&i.DeletedAt,
+			&i.ProductID,
+			&i.ItemPriceAmount,
+			&i.ItemPriceCurrency,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const SearchOrdersPage = `-- name: SearchOrdersPage :many
+WITH page AS (SELECT o.id,
+                     o.owner_id,
+                     o.created_at,
+                     o.updated_at,
+                     o.url,
+                     o.status,
+                     o.tags,
+                     o.payload,
+                     o.payloadb,
+                     o.price_amount,
+                     o.price_currency,
+                     o.version,
+                     o.deleted_at
+              FROM orders o
+              WHERE (
+                        ($1::UUID[] IS NULL OR o.id = ANY ($1))
+                            AND
+                        ($2::VARCHAR[] IS NULL OR o.owner_id = ANY ($2))
+                            AND
+                        ($3::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                                  FROM unnest($3) AS url_pattern
+                                                                  WHERE o.url ILIKE '%' || url_pattern || '%'))
+                            AND
+                        ($4::TEXT[] IS NULL OR o.status = ANY ($4))
+                            AND
+                        ($5::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                          FROM unnest($5) AS tag
+                                                          WHERE tag = ANY (o.tags)))
+                            AND
+                        (
+                            ($6::TIMESTAMP IS NULL OR o.created_at >= $6) AND
+                            ($7::TIMESTAMP IS NULL OR o.created_at < $7)
+                            )
+                            AND
+                        (
+                            ($8::TIMESTAMP IS NULL OR o.updated_at >= $8) AND
+                            ($9::TIMESTAMP IS NULL OR o.updated_at < $9)
+                            )
+                            AND
+                        CASE $10::TEXT
+                            WHEN 'include' THEN TRUE
+                            WHEN 'only' THEN o.deleted_at IS NOT NULL
+                            ELSE o.deleted_at IS NULL
+                            END
+                            AND
+                        (
+                            ($11::TIMESTAMP IS NULL OR o.deleted_at >= $11) AND
+                            ($12::TIMESTAMP IS NULL OR o.deleted_at < $12)
+                            )
+                        )
+                AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND oi.deleted_at IS NULL)
+                AND ($13::UUID IS NULL OR
+                     CASE $14::TEXT
+                         WHEN 'created_at'
+                             THEN (o.created_at, o.id) > ($15::TIMESTAMP, $13)
+                         WHEN 'updated_at'
+                             THEN (o.updated_at, o.id) > ($15, $13)
+                         WHEN 'price_amount'
+                             THEN (o.price_amount, o.id) > ($16::DECIMAL, $13)
+                         ELSE o.id > $13
+                         END)
+              ORDER BY CASE WHEN $14 = 'created_at' THEN o.created_at END,
+                       CASE WHEN $14 = 'updated_at' THEN o.updated_at END,
+                       CASE WHEN $14 = 'price_amount' THEN o.price_amount END,
+                       o.id
+              LIMIT $17)
+SELECT p.id,
+       p.owner_id,
+       p.created_at,
+       p.updated_at,
+       p.url,
+       p.status,
+       p.tags,
+       p.payload,
+       p.payloadb,
+       p.price_amount,
+       p.price_currency,
+       p.version,
+       p.deleted_at,
+       oi.product_id,
+       oi.price_amount   AS item_price_amount,
+       oi.price_currency AS item_price_currency
+FROM page p
+         JOIN order_items oi ON p.id = oi.order_id AND oi.deleted_at IS NULL
+ORDER BY CASE WHEN $14 = 'created_at' THEN p.created_at END,
+         CASE WHEN $14 = 'updated_at' THEN p.updated_at END,
+         CASE WHEN $14 = 'price_amount' THEN p.price_amount END,
+         p.id,
+         oi.product_id
+`
+
+type SearchOrdersPageParams struct {
+	Ids               []uuid.UUID
+	OwnerIds          []string
+	UrlPatterns       []string
+	Statuses          []string
+	Tags              []string
+	CreatedAfter      *time.Time
+	CreatedBefore     *time.Time
+	UpdatedAfter      *time.Time
+	UpdatedBefore     *time.Time
+	DeletedMode       string
+	DeletedAfter      *time.Time
+	DeletedBefore     *time.Time
+	CursorID          *uuid.UUID
+	SortKey           string
+	CursorTime        *time.Time
+	CursorPriceAmount *decimal.Decimal
+	PageLimit         int32
+}
+
+type SearchOrdersPageRow struct {
+	ID                uuid.UUID
+	OwnerID           string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Url               *string
+	Status            string
+	Tags              []string
+	Payload           []byte
+	Payloadb          []byte
+	PriceAmount       decimal.Decimal
+	PriceCurrency     string
+	Version           int64
+	DeletedAt         *time.Time
+	ProductID         uuid.UUID
+	ItemPriceAmount   decimal.Decimal
+	ItemPriceCurrency string
+}
+
+func (q *Queries) SearchOrdersPage(ctx context.Context, arg SearchOrdersPageParams) ([]SearchOrdersPageRow, error) {
+	rows, err := q.db.Query(ctx, SearchOrdersPage,
+		arg.Ids,
+		arg.OwnerIds,
+		arg.UrlPatterns,
+		arg.Statuses,
+		arg.Tags,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.UpdatedAfter,
+		arg.UpdatedBefore,
+		arg.DeletedMode,
+		arg.DeletedAfter,
+		arg.DeletedBefore,
+		arg.CursorID,
+		arg.SortKey,
+		arg.CursorTime,
+		arg.CursorPriceAmount,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchOrdersPageRow
+	for rows.Next() {
+		var i SearchOrdersPageRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Url,
+			&i.Status,
+			&i.Tags,
+			&i.Payload,
+			&i.Payloadb,
+			&i.PriceAmount,
+			&i.PriceCurrency,
+			&i.Version,
+			&i.DeletedAt,
 			&i.ProductID,
 			&i.ItemPriceAmount,
 			&i.ItemPriceCurrency,
@@ -371,9 +1361,60 @@ func (q *Queries) SearchOrders(ctx context.Context, arg SearchOrdersParams) ([]S
 	return items, nil
 }
 
+const SetOrderPayload = `-- name: SetOrderPayload :execresult
+UPDATE orders
+SET payload  = $2,
+    payloadb = $3
+WHERE id = $1
+  AND deleted_at IS NULL
+`
+
+type SetOrderPayloadParams struct {
+	ID       uuid.UUID
+	Payload  []byte
+	Payloadb []byte
+}
+
+func (q *Queries) SetOrderPayload(ctx context.Context, arg SetOrderPayloadParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, SetOrderPayload, arg.ID, arg.Payload, arg.Payloadb)
+}
+
+const SetOrderTags = `-- name: SetOrderTags :execresult
+UPDATE orders
+SET tags = $2
+WHERE id = $1
+  AND deleted_at IS NULL
+`
+
+type SetOrderTagsParams struct {
+	ID   uuid.UUID
+	Tags []string
+}
+
+func (q *Queries) SetOrderTags(ctx context.Context, arg SetOrderTagsParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, SetOrderTags, arg.ID, arg.Tags)
+}
+
+const SetOrderURL = `-- name: SetOrderURL :execresult
+UPDATE orders
+SET url = $2
+WHERE id = $1
+  AND deleted_at IS NULL
+`
+
+type SetOrderURLParams struct {
+	ID  uuid.UUID
+	Url *string
+}
+
+func (q *Queries) SetOrderURL(ctx context.Context, arg SetOrderURLParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, SetOrderURL, arg.ID, arg.Url)
+}
+
 const SoftDeleteOrder = `-- name: SoftDeleteOrder :execresult
 UPDATE orders
-SET deleted_at = NOW()
+SET deleted_at = NOW(),
+    version    = version + 1
 WHERE id = $1
   AND deleted_at IS NULL
 `
@@ -399,13 +1440,79 @@ func (q *Queries) SoftDeleteOrderItem(ctx context.Context, arg SoftDeleteOrderIt
 	return q.db.Exec(ctx, SoftDeleteOrderItem, arg.OrderID, arg.ProductID)
 }
 
+const SoftDeleteOrderItemsExcept = `-- name: SoftDeleteOrderItemsExcept :many
+UPDATE order_items
+SET deleted_at = NOW()
+WHERE order_id = $1
+  AND deleted_at IS NULL
+  AND NOT (product_id = ANY ($2::UUID[]))
+RETURNING product_id
+`
+
+type SoftDeleteOrderItemsExceptParams struct {
+	OrderID    uuid.UUID
+	ProductIds []uuid.UUID
+}
+
+func (q *Queries) SoftDeleteOrderItemsExcept(ctx context.Context, arg SoftDeleteOrderItemsExceptParams) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, SoftDeleteOrderItemsExcept, arg.OrderID, arg.ProductIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var product_id uuid.UUID
+		if err := rows.Scan(&product_id); err != nil {
+			return nil, err
+		}
+		items = append(items, product_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const UpdateOrderDetails = `-- name: UpdateOrderDetails :execresult
+UPDATE orders
+SET url            = $2,
+    tags           = $3,
+    payload        = $4,
+    payloadb       = $5,
+    price_currency = $6
+WHERE id = $1
+  AND deleted_at IS NULL
+`
+
+type UpdateOrderDetailsParams struct {
+	ID            uuid.UUID
+	Url           *string
+	Tags          []string
+	Payload       []byte
+	Payloadb      []byte
+	PriceCurrency string
+}
+
+func (q *Queries) UpdateOrderDetails(ctx context.Context, arg UpdateOrderDetailsParams) (pgconn.CommandTag, error) {
+	return q.db.Exec(ctx, UpdateOrderDetails,
+		arg.ID,
+		arg.Url,
+		arg.Tags,
+		arg.Payload,
+		arg.Payloadb,
+		arg.PriceCurrency,
+	)
+}
+
 const UpdateOrderPrice = `-- name: UpdateOrderPrice :execresult
 UPDATE orders
-SET price_amount = (SELECT COALESCE(SUM(price), 0)
+SET price_amount = (SELECT COALESCE(SUM(price_amount), 0)
                    FROM order_items
                    WHERE order_id = $1
                      AND deleted_at IS NULL),
-    updated_at  = NOW()
+    updated_at  = NOW(),
+    version     = version + 1
 WHERE id = $1
   AND deleted_at IS NULL
 `
@@ -414,19 +1521,51 @@ func (q *Queries) UpdateOrderPrice(ctx context.Context, orderID uuid.UUID) (pgco
 	return q.db.Exec(ctx, UpdateOrderPrice, orderID)
 }
 
-const UpdateOrderStatus = `-- name: UpdateOrderStatus :execresult
-UPDATE orders
-SET status     = $2,
-    updated_at = NOW()
-WHERE id = $1
-  AND deleted_at IS NULL
+const UpdateOrderStatus = `-- name: UpdateOrderStatus :one
+WITH prev AS (SELECT id, status, version
+              FROM orders
+              WHERE id = $1
+                AND deleted_at IS NULL
+                  FOR UPDATE),
+     upd AS (UPDATE orders o
+         SET status = $2,
+             version = o.version + 1,
+             updated_at = NOW()
+         FROM prev
+         WHERE o.id = prev.id
+           AND prev.version = $3
+           AND prev.status = ANY ($4::TEXT[])
+         RETURNING o.id)
+SELECT prev.status                         AS previous_status,
+       prev.version                        AS previous_version,
+       EXISTS (SELECT 1 FROM upd)::BOOLEAN AS updated
+FROM prev
 `
 
 type UpdateOrderStatusParams struct {
-	ID     uuid.UUID
-	Status string
+	ID              uuid.UUID
+	Status          string
+	ExpectedVersion int64
+	FromStatuses    []string
 }
 
-func (q *Queries) UpdateOrderStatus(ctx context.Context, arg UpdateOrderStatusParams) (pgconn.CommandTag, error) {
-	return q.db.Exec(ctx, UpdateOrderStatus, arg.ID, arg.Status)
+type UpdateOrderStatusRow struct {
+	PreviousStatus  string
+	PreviousVersion int64
+	Updated         bool
+}
+
+// locks the order and moves it to the new status only if it is still at expected_version
+// and its current status is one of from_statuses,
+// returns no rows if the order does not exist
+func (q *Queries) UpdateOrderStatus(ctx context.Context, arg UpdateOrderStatusParams) (UpdateOrderStatusRow, error) {
+	row := q.db.QueryRow(ctx, UpdateOrderStatus,
+		arg.ID,
+		arg.Status,
+		arg.ExpectedVersion,
+		arg.FromStatuses,
+	)
+	var i UpdateOrderStatusRow
+	err := row.Scan(&i.PreviousStatus, &i.PreviousVersion, &i.Updated)
+	return i, err
 }
@@ -10,16 +10,26 @@ SELECT id,
        payloadb,
        deleted_at,
        price_amount,
-       price_currency
+       price_currency,
+       version
 FROM orders
 WHERE id = $1
   AND deleted_at IS NULL;
 
 -- name: InsertOrder :one
-INSERT INTO orders (owner_id, url, tags, payload, payloadb, price_amount, price_currency)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
+-- returns no rows if the order ID is taken or the owner already has an order with the same idempotency key
+INSERT INTO orders (id, owner_id, url, tags, payload, payloadb, price_amount, price_currency, idempotency_key, request_hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT DO NOTHING
 RETURNING id;
 
+-- name: GetOrderByIdempotencyKey :one
+-- soft-deleted orders are matched too, a replay must not create the order again
+SELECT id, request_hash
+FROM orders
+WHERE owner_id = $1
+  AND idempotency_key = $2;
+
 -- name: GetOrderItems :many
 SELECT product_id, price_amount, price_currency, created_at
 FROM order_items
@@ -40,9 +50,36 @@ DELETE
 FROM order_items
 WHERE order_id = $1;
 
+-- name: GetPurgeableOrderIDs :many
+-- locks up to batch_size orders soft-deleted longer than retention_seconds ago whose items are purged,
+-- skipping the orders locked by others
+SELECT id
+FROM orders
+WHERE deleted_at < NOW() - make_interval(secs => @retention_seconds::FLOAT8)
+  AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = orders.id)
+ORDER BY deleted_at
+LIMIT @batch_size FOR UPDATE SKIP LOCKED;
+
+-- name: DeleteOrdersByIDs :execresult
+DELETE
+FROM orders
+WHERE id = ANY (@ids::UUID[]);
+
+-- name: PurgeOrderItems :execresult
+-- hard-deletes up to batch_size items soft-deleted, themselves or with their order, longer than retention_seconds ago
+DELETE
+FROM order_items
+WHERE (order_id, product_id) IN (SELECT oi.order_id, oi.product_id
+                                 FROM order_items oi
+                                          JOIN orders o ON o.id = oi.order_id
+                                 WHERE LEAST(oi.deleted_at, o.deleted_at) < NOW() - make_interval(secs => @retention_seconds::FLOAT8)
+                                 ORDER BY LEAST(oi.deleted_at, o.deleted_at)
+                                 LIMIT @batch_size FOR UPDATE OF oi SKIP LOCKED);
+
 -- name: SoftDeleteOrder :execresult
 UPDATE orders
-SET deleted_at = NOW()
+SET deleted_at = NOW(),
+    version    = version + 1
 WHERE id = $1
   AND deleted_at IS NULL;
 
@@ -53,13 +90,34 @@ WHERE order_id = $1
   AND product_id = $2
   AND deleted_at IS NULL;
 
+-- name: RestoreOrder :execresult
+-- the price is recomputed from the items which are not deleted
+UPDATE orders
+SET deleted_at   = NULL,
+    price_amount = (SELECT COALESCE(SUM(price_amount), 0)
+                    FROM order_items
+                    WHERE order_id = $1
+                      AND deleted_at IS NULL),
+    updated_at   = NOW(),
+    version      = version + 1
+WHERE id = $1
+  AND deleted_at IS NOT NULL;
+
+-- name: RestoreOrderItem :execresult
+UPDATE order_items
+SET deleted_at = NULL
+WHERE order_id = $1
+  AND product_id = $2
+  AND deleted_at IS NOT NULL;
+
 -- name: UpdateOrderPrice :execresult
 UPDATE orders
-SET price_amount = (SELECT COALESCE(SUM(price), 0)
+SET price_amount = (SELECT COALESCE(SUM(price_amount), 0)
                    FROM order_items
                    WHERE order_id = $1
                      AND deleted_at IS NULL),
-    updated_at  = NOW()
+    updated_at  = NOW(),
+    version     = version + 1
 WHERE id = $1
   AND deleted_at IS NULL;
 
@@ -75,6 +133,7 @@ SELECT o.id,
        o.payloadb,
        o.price_amount,
        o.price_currency,
+       o.version,
        oi.product_id,
        oi.price_amount   AS item_price_amount,
        oi.price_currency AS item_price_currency
@@ -84,12 +143,28 @@ WHERE o.id = $1
   ANd o.deleted_at IS NULL
   AND oi.deleted_at IS NULL;
 
--- name: UpdateOrderStatus :execresult
-UPDATE orders
-SET status     = $2,
-    updated_at = NOW()
-WHERE id = $1
-  AND deleted_at IS NULL;
+-- name: UpdateOrderStatus :one
+-- locks the order and moves it to the new status only if it is still at expected_version
+-- and its current status is one of from_statuses,
+-- returns no rows if the order does not exist
+WITH prev AS (SELECT id, status, version
+              FROM orders
+              WHERE id = @id
+                AND deleted_at IS NULL
+                  FOR UPDATE),
+     upd AS (UPDATE orders o
+         SET status = @status,
+             version = o.version + 1,
+             updated_at = NOW()
+         FROM prev
+         WHERE o.id = prev.id
+           AND prev.version = @expected_version
+           AND prev.status = ANY (@from_statuses::TEXT[])
+         RETURNING o.id)
+SELECT prev.status                         AS previous_status,
+       prev.version                        AS previous_version,
+       EXISTS (SELECT 1 FROM upd)::BOOLEAN AS updated
+FROM prev;
 
 -- name: SearchOrders :many
 SELECT o.id,
@@ -103,11 +178,146 @@ SELECT o.id,
        o.payloadb,
        o.price_amount,
        o.price_currency,
+       o.version,
+       o.deleted_at,
        oi.product_id,
        oi.price_amount   AS item_price_amount,
        oi.price_currency AS item_price_currency
 FROM orders o
-         JOIN order_items oi ON o.id = oi.order_id
+         JOIN order_items oi ON o.id = oi.order_id AND oi.deleted_at IS NULL
+WHERE (
+          (@ids::UUID[] IS NULL OR o.id = ANY (@ids))
+              AND
+          (@owner_ids::VARCHAR[] IS NULL OR o.owner_id = ANY (@owner_ids))
+              AND
+          (@url_patterns::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                    FROM unnest(@url_patterns) AS url_pattern
+                                                    WHERE o.url ILIKE '%' || url_pattern || '%'))
+              AND
+          (@statuses::TEXT[] IS NULL OR o.status = ANY (@statuses))
+              AND
+          (@tags::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                            FROM unnest(@tags) AS tag
+                                            WHERE tag = ANY (o.tags)))
+              AND
+          (
+              (sqlc.narg(created_after)::TIMESTAMP IS NULL OR o.created_at >= sqlc.narg(created_after)) AND
+              (sqlc.narg(created_before)::TIMESTAMP IS NULL OR o.created_at < sqlc.narg(created_before))
+              )
+              AND
+          (
+              (sqlc.narg(updated_after)::TIMESTAMP IS NULL OR o.updated_at >= sqlc.narg(updated_after)) AND
+              (sqlc.narg(updated_before)::TIMESTAMP IS NULL OR o.updated_at < sqlc.narg(updated_before))
+              )
+              AND
+          CASE @deleted_mode::TEXT
+              WHEN 'include' THEN TRUE
+              WHEN 'only' THEN o.deleted_at IS NOT NULL
+              ELSE o.deleted_at IS NULL
+              END
+              AND
+          (
+              (sqlc.narg(deleted_after)::TIMESTAMP IS NULL OR o.deleted_at >= sqlc.narg(deleted_after)) AND
+              (sqlc.narg(deleted_before)::TIMESTAMP IS NULL OR o.deleted_at < sqlc.narg(deleted_before))
+              )
+          )
+ORDER BY o.created_at, o.id, oi.product_id;
+
+-- name: SearchOrdersPage :many
+WITH page AS (SELECT o.id,
+                     o.owner_id,
+                     o.created_at,
+                     o.updated_at,
+                     o.url,
+                     o.status,
+                     o.tags,
+                     o.payload,
+                     o.payloadb,
+                     o.price_amount,
+                     o.price_currency,
+                     o.version,
+                     o.deleted_at
+              FROM orders o
+              WHERE (
+                        (@ids::UUID[] IS NULL OR o.id = ANY (@ids))
+                            AND
+                        (@owner_ids::VARCHAR[] IS NULL OR o.owner_id = ANY (@owner_ids))
+                            AND
+                        (@url_patterns::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                                  FROM unnest(@url_patterns) AS url_pattern
+                                                                  WHERE o.url ILIKE '%' || url_pattern || '%'))
+                            AND
+                        (@statuses::TEXT[] IS NULL OR o.status = ANY (@statuses))
+                            AND
+                        (@tags::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                          FROM unnest(@tags) AS tag
+                                                          WHERE tag = ANY (o.tags)))
+                            AND
+                        (
+                            (sqlc.narg(created_after)::TIMESTAMP IS NULL OR o.created_at >= sqlc.narg(created_after)) AND
+                            (sqlc.narg(created_before)::TIMESTAMP IS NULL OR o.created_at < sqlc.narg(created_before))
+                            )
+                            AND
+                        (
+                            (sqlc.narg(updated_after)::TIMESTAMP IS NULL OR o.updated_at >= sqlc.narg(updated_after)) AND
+                            (sqlc.narg(updated_before)::TIMESTAMP IS NULL OR o.updated_at < sqlc.narg(updated_before))
+                            )
+                            AND
+                        CASE @deleted_mode::TEXT
+                            WHEN 'include' THEN TRUE
+                            WHEN 'only' THEN o.deleted_at IS NOT NULL
+                            ELSE o.deleted_at IS NULL
+                            END
+                            AND
+                        (
+                            (sqlc.narg(deleted_after)::TIMESTAMP IS NULL OR o.deleted_at >= sqlc.narg(deleted_after)) AND
+                            (sqlc.narg(deleted_before)::TIMESTAMP IS NULL OR o.deleted_at < sqlc.narg(deleted_before))
+                            )
+                        )
+                -- an order without items is never returned by the JOIN below, so it must not take a slot in the page
+                AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND oi.deleted_at IS NULL)
+                AND (sqlc.narg(cursor_id)::UUID IS NULL OR
+                     CASE @sort_key::TEXT
+                         WHEN 'created_at'
+                             THEN (o.created_at, o.id) > (sqlc.narg(cursor_time)::TIMESTAMP, sqlc.narg(cursor_id))
+                         WHEN 'updated_at'
+                             THEN (o.updated_at, o.id) > (sqlc.narg(cursor_time), sqlc.narg(cursor_id))
+                         WHEN 'price_amount'
+                             THEN (o.price_amount, o.id) > (sqlc.narg(cursor_price_amount)::DECIMAL, sqlc.narg(cursor_id))
+                         ELSE o.id > sqlc.narg(cursor_id)
+                         END)
+              ORDER BY CASE WHEN @sort_key = 'created_at' THEN o.created_at END,
+                       CASE WHEN @sort_key = 'updated_at' THEN o.updated_at END,
+                       CASE WHEN @sort_key = 'price_amount' THEN o.price_amount END,
+                       o.id
+              LIMIT @page_limit)
+SELECT p.id,
+       p.owner_id,
+       p.created_at,
+       p.updated_at,
+       p.url,
+       p.status,
+       p.tags,
+       p.payload,
+       p.payloadb,
+       p.price_amount,
+       p.price_currency,
+       p.version,
+       p.deleted_at,
+       oi.product_id,
+       oi.price_amount   AS item_price_amount,
+       oi.price_currency AS item_price_currency
+FROM page p
+         JOIN order_items oi ON p.id = oi.order_id AND oi.deleted_at IS NULL
+ORDER BY CASE WHEN @sort_key = 'created_at' THEN p.created_at END,
+         CASE WHEN @sort_key = 'updated_at' THEN p.updated_at END,
+         CASE WHEN @sort_key = 'price_amount' THEN p.price_amount END,
+         p.id,
+         oi.product_id;
+
+-- name: CountOrders :one
+SELECT COUNT(*)
+FROM orders o
 WHERE (
           (@ids::UUID[] IS NULL OR o.id = ANY (@ids))
               AND
@@ -132,4 +342,257 @@ WHERE (
               (sqlc.narg(updated_after)::TIMESTAMP IS NULL OR o.updated_at >= sqlc.narg(updated_after)) AND
               (sqlc.narg(updated_before)::TIMESTAMP IS NULL OR o.updated_at < sqlc.narg(updated_before))
               )
-          );
\ No newline at end of file
+              AND
+          CASE @deleted_mode::TEXT
+              WHEN 'include' THEN TRUE
+              WHEN 'only' THEN o.deleted_at IS NOT NULL
+              ELSE o.deleted_at IS NULL
+              END
+              AND
+          (
+              (sqlc.narg(deleted_after)::TIMESTAMP IS NULL OR o.deleted_at >= sqlc.narg(deleted_after)) AND
+              (sqlc.narg(deleted_before)::TIMESTAMP IS NULL OR o.deleted_at < sqlc.narg(deleted_before))
+              )
+          )
+  AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND oi.deleted_at IS NULL);
+
+-- name: GetOrderFacets :many
+WITH filtered AS (SELECT o.id, o.owner_id, o.status, o.tags, o.price_amount, o.price_currency
+                  FROM orders o
+                  WHERE (
+                            (@ids::UUID[] IS NULL OR o.id = ANY (@ids))
+                                AND
+                            (@owner_ids::VARCHAR[] IS NULL OR o.owner_id = ANY (@owner_ids))
+                                AND
+                            (@url_patterns::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                                      FROM unnest(@url_patterns) AS url_pattern
+                                                                      WHERE o.url ILIKE '%' || url_pattern || '%'))
+                                AND
+                            (@statuses::TEXT[] IS NULL OR o.status = ANY (@statuses))
+                                AND
+                            (@tags::TEXT[] IS NULL OR EXISTS (SELECT 1
+                                                              FROM unnest(@tags) AS tag
+                                                              WHERE tag = ANY (o.tags)))
+                                AND
+                            (
+                                (sqlc.narg(created_after)::TIMESTAMP IS NULL OR o.created_at >= sqlc.narg(created_after)) AND
+                                (sqlc.narg(created_before)::TIMESTAMP IS NULL OR o.created_at < sqlc.narg(created_before))
+                                )
+                                AND
+                            (
+                                (sqlc.narg(updated_after)::TIMESTAMP IS NULL OR o.updated_at >= sqlc.narg(updated_after)) AND
+                                (sqlc.narg(updated_before)::TIMESTAMP IS NULL OR o.updated_at < sqlc.narg(updated_before))
+                                )
+                                AND
+                            CASE @deleted_mode::TEXT
+                                WHEN 'include' THEN TRUE
+                                WHEN 'only' THEN o.deleted_at IS NOT NULL
+                                ELSE o.deleted_at IS NULL
+                                END
+                                AND
+                            (
+                                (sqlc.narg(deleted_after)::TIMESTAMP IS NULL OR o.deleted_at >= sqlc.narg(deleted_after)) AND
+                                (sqlc.narg(deleted_before)::TIMESTAMP IS NULL OR o.deleted_at < sqlc.narg(deleted_before))
+                                )
+                            )
+                    AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = o.id AND oi.deleted_at IS NULL))
+SELECT 'status'::TEXT AS facet, f.status::TEXT AS value, COUNT(*) AS order_count, 0::DECIMAL AS price_sum
+FROM filtered f
+GROUP BY f.status
+UNION ALL
+SELECT 'currency', f.price_currency, COUNT(*), SUM(f.price_amount)
+FROM filtered f
+GROUP BY f.price_currency
+UNION ALL
+SELECT 'owner', f.owner_id, COUNT(*), 0
+FROM filtered f
+GROUP BY f.owner_id
+UNION ALL
+-- an order may carry the same tag twice, count it once
+SELECT 'tag', t.tag, COUNT(DISTINCT f.id), 0
+FROM filtered f
+         CROSS JOIN LATERAL unnest(f.tags) AS t(tag)
+GROUP BY t.tag;
+
+-- name: InsertOrderEvent :exec
+INSERT INTO order_events (order_id, product_id, event_type, old_value, new_value, actor, reason)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: GetOrderEvents :many
+SELECT id,
+       order_id,
+       product_id,
+       event_type,
+       old_value,
+       new_value,
+       actor,
+       reason,
+       created_at
+FROM order_events
+WHERE order_id = $1
+ORDER BY created_at, id;
+
+-- name: InsertOutboxEvent :exec
+INSERT INTO order_outbox (event_type, order_id, payload)
+VALUES ($1, $2, $3);
+
+-- name: ClaimOutboxEvents :many
+SELECT id, event_type, order_id, payload, created_at
+FROM order_outbox
+WHERE published_at IS NULL
+ORDER BY id
+LIMIT $1 FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxEventsPublished :execresult
+UPDATE order_outbox
+SET published_at = NOW()
+WHERE id = ANY (@ids::BIGINT[]);
+
+-- name: GetOrderVersionForUpdate :one
+SELECT version
+FROM orders
+WHERE id = $1
+  AND deleted_at IS NULL
+    FOR UPDATE;
+
+-- name: UpdateOrderDetails :execresult
+UPDATE orders
+SET url            = $2,
+    tags           = $3,
+    payload        = $4,
+    payloadb       = $5,
+    price_currency = $6
+WHERE id = $1
+  AND deleted_at IS NULL;
+
+-- name: SetOrderTags :execresult
+UPDATE orders
+SET tags = $2
+WHERE id = $1
+  AND deleted_at IS NULL;
+
+-- name: AddOrderTags :execresult
+UPDATE orders
+SET tags = COALESCE(tags, '{}') || ARRAY(SELECT tag
+                                         FROM unnest(@tags::TEXT[]) WITH ORDINALITY AS t(tag, ord)
+                                         WHERE NOT tag = ANY (COALESCE(tags, '{}'))
+                                         ORDER BY ord)
+WHERE id = @id
+  AND deleted_at IS NULL;
+
+-- name: RemoveOrderTags :execresult
+UPDATE orders
+SET tags = ARRAY(SELECT tag
+                 FROM unnest(tags) WITH ORDINALITY AS t(tag, ord)
+                 WHERE NOT tag = ANY (@tags::TEXT[])
+                 ORDER BY ord)
+WHERE id = @id
+  AND deleted_at IS NULL;
+
+-- name: SetOrderURL :execresult
+UPDATE orders
+SET url = $2
+WHERE id = $1
+  AND deleted_at IS NULL;
+
+-- name: SetOrderPayload :execresult
+UPDATE orders
+SET payload  = $2,
+    payloadb = $3
+WHERE id = $1
+  AND deleted_at IS NULL;
+
+-- name: AddOrderItem :execresult
+-- revives a soft-deleted item, affects no rows if the item is already in the order
+INSERT INTO order_items (order_id, product_id, price_amount, price_currency)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (order_id, product_id) DO UPDATE
+    SET price_amount   = excluded.price_amount,
+        price_currency = excluded.price_currency,
+        created_at     = CURRENT_TIMESTAMP,
+        deleted_at     = NULL
+WHERE order_items.deleted_at IS NOT NULL;
+
+-- name: ReplaceOrderItem :exec
+-- inserts the item, or overwrites it whether it is live or soft-deleted
+INSERT INTO order_items (order_id, product_id, price_amount, price_currency)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (order_id, product_id) DO UPDATE
+    SET price_amount   = excluded.price_amount,
+        price_currency = excluded.price_currency,
+        created_at     = CASE
+                             WHEN order_items.deleted_at IS NULL THEN order_items.created_at
+                             ELSE CURRENT_TIMESTAMP END,
+        deleted_at     = NULL;
+
+-- name: SoftDeleteOrderItemsExcept :many
+UPDATE order_items
+SET deleted_at = NOW()
+WHERE order_id = @order_id
+  AND deleted_at IS NULL
+  AND NOT (product_id = ANY (@product_ids::UUID[]))
+RETURNING product_id;
+
+-- name: GetOrderForUpdate :one
+SELECT id,
+       owner_id,
+       created_at,
+       updated_at,
+       url,
+       status,
+       tags,
+       payload,
+       payloadb,
+       deleted_at,
+       price_amount,
+       price_currency,
+       version
+FROM orders
+WHERE id = $1
+  AND deleted_at IS NULL
+    FOR UPDATE;
+
+-- name: GetOrderForUpdateNoWait :one
+SELECT id,
+       owner_id,
+       created_at,
+       updated_at,
+       url,
+       status,
+       tags,
+       payload,
+       payloadb,
+       deleted_at,
+       price_amount,
+       price_currency,
+       version
+FROM orders
+WHERE id = $1
+  AND deleted_at IS NULL
+    FOR UPDATE NOWAIT;
+
+-- name: GetOrderForUpdateSkipLocked :one
+SELECT id,
+       owner_id,
+       created_at,
+       updated_at,
+       url,
+       status,
+       tags,
+       payload,
+       payloadb,
+       deleted_at,
+       price_amount,
+       price_currency,
+       version
+FROM orders
+WHERE id = $1
+  AND deleted_at IS NULL
+    FOR UPDATE SKIP LOCKED;
+
+-- name: OrderExists :one
+SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1 AND deleted_at IS NULL)::BOOLEAN AS order_exists;
+
+-- name: LockOrder :exec
+-- the advisory lock is released at the end of the transaction, its key is a hash of the order ID
+SELECT pg_advisory_xact_lock(hashtextextended(@order_id::UUID::TEXT, 0));
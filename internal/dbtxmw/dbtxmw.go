@@ -0,0 +1,420 @@
+// Package dbtxmw wraps a db.DBTX, a pool or a transaction, with a chain of middlewares
+// which see every Exec, Query and QueryRow before it reaches the database.
+package dbtxmw
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+)
+
+// Middleware decorates the DBTX which runs the statements, the built-in ones are made with Hook.
+type Middleware func(next db.DBTX) db.DBTX
+
+// Statement is the SQL and the arguments of an Exec, Query or QueryRow call.
+type Statement struct {
+	SQL  string
+	Args []any
+}
+
+// Name is the QueryName of the SQL.
+func (s Statement) Name() string {
+	return QueryName(s.SQL)
+}
+
+// QueryName is the sqlc query name, i.e. SearchOrders for "-- name: SearchOrders :many",
+// otherwise the first keyword of the statement, i.e. BEGIN.
+func QueryName(sql string) string {
+	sql = strings.TrimSpace(sql)
+
+	if rest, ok := strings.CutPrefix(sql, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok && name != "" {
+			return name
+		}
+	}
+
+	keywords := strings.Fields(sql)
+	if len(keywords) == 0 {
+		return ""
+	}
+
+	return strings.ToUpper(keywords[0])
+}
+
+// Wrap runs the statements of dbtx through mws, the first middleware sees a statement first.
+// A wrapped pgx.Tx is a pgx.Tx and a wrapped pool has BeginTx, so the repository can start transactions
+// and savepoints with it, the statements of those go through mws too.
+// SendBatch and CopyFrom of a transaction go through the middlewares made with Hook and bypass the others.
+func Wrap(dbtx db.DBTX, mws ...Middleware) db.DBTX {
+	if len(mws) == 0 {
+		return dbtx
+	}
+
+	chain := dbtx
+	for i := len(mws) - 1; i >= 0; i-- {
+		chain = mws[i](chain)
+	}
+
+	switch d := dbtx.(type) {
+	case pgx.Tx:
+		return &wrappedTx{Tx: d, chain: chain, mws: mws}
+	case txBeginner:
+		return &wrappedBeginner{DBTX: chain, beginner: d, mws: mws}
+	default:
+		return chain
+	}
+}
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+type wrappedBeginner struct {
+	db.DBTX
+
+	beginner txBeginner
+	mws      []Middleware
+}
+
+func (w *wrappedBeginner) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	tx, err := w.beginner.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return Wrap(tx, w.mws...).(pgx.Tx), nil
+}
+
+// wrappedTx runs Exec, Query and QueryRow through the chain, the other methods go to the embedded transaction.
+// The chain finds the transaction in the context of the statements with txFromContext.
+type wrappedTx struct {
+	pgx.Tx
+
+	chain db.DBTX
+	mws   []Middleware
+
+	// statementTimeout is the one set with SET LOCAL in this transaction or savepoint, see StatementTimeout
+	statementTimeout time.Duration
+}
+
+func (t *wrappedTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	tx, err := t.Tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return Wrap(tx, t.mws...).(pgx.Tx), nil
+}
+
+func (t *wrappedTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return t.chain.Exec(contextWithTx(ctx, t), sql, args...)
+}
+
+func (t *wrappedTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return t.chain.Query(contextWithTx(ctx, t), sql, args...)
+}
+
+func (t *wrappedTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return t.chain.QueryRow(contextWithTx(ctx, t), sql, args...)
+}
+
+func (t *wrappedTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	ctx = contextWithTx(ctx, t)
+	return nextBulk(ctx, t.chain).SendBatch(ctx, b)
+}
+
+func (t *wrappedTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	ctx = contextWithTx(ctx, t)
+	return nextBulk(ctx, t.chain).CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+// bulk is implemented by pgx.Tx and by the middlewares made with Hook.
+type bulk interface {
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// nextBulk returns next if it runs batches and copies, otherwise the transaction of ctx,
+// which skips the rest of the chain.
+func nextBulk(ctx context.Context, next db.DBTX) bulk {
+	if b, ok := next.(bulk); ok {
+		return b
+	}
+
+	tx, _ := txFromContext(ctx)
+
+	return tx.Tx
+}
+
+type txKey struct{}
+
+func contextWithTx(ctx context.Context, tx *wrappedTx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// txFromContext returns the transaction or savepoint which runs the statement of ctx.
+func txFromContext(ctx context.Context) (*wrappedTx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*wrappedTx)
+	return tx, ok
+}
+
+// HookFunc is called before a statement runs, it may replace the context and the statement.
+// A non-nil error fails the statement without running it. done, which may be nil, is called once the statement
+// has completed, i.e. when Exec returns, the rows of Query are closed or the row of QueryRow is scanned,
+// rows is the number of rows affected or read and pgx.ErrNoRows does not count as an error.
+type HookFunc func(ctx context.Context, s Statement) (_ context.Context, _ Statement, done func(rows int64, err error), _ error)
+
+// Hook makes a middleware of fn.
+func Hook(fn HookFunc) Middleware {
+	return func(next db.DBTX) db.DBTX {
+		return &hooked{next: next, fn: fn}
+	}
+}
+
+type hooked struct {
+	next db.DBTX
+	fn   HookFunc
+}
+
+func (h *hooked) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	ctx, s, done, err := h.fn(ctx, Statement{SQL: sql, Args: args})
+	if err != nil {
+		complete(done, 0, err)
+		return pgconn.CommandTag{}, err
+	}
+
+	tag, err := h.next.Exec(ctx, s.SQL, s.Args...)
+	complete(done, tag.RowsAffected(), err)
+
+	return tag, err
+}
+
+func (h *hooked) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	ctx, s, done, err := h.fn(ctx, Statement{SQL: sql, Args: args})
+	if err != nil {
+		complete(done, 0, err)
+		return nil, err
+	}
+
+	rows, err := h.next.Query(ctx, s.SQL, s.Args...)
+	if err != nil {
+		complete(done, 0, err)
+		return nil, err
+	}
+
+	if done == nil {
+		return rows, nil
+	}
+
+	return &hookedRows{Rows: rows, done: done}, nil
+}
+
+func (h *hooked) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	ctx, s, done, err := h.fn(ctx, Statement{SQL: sql, Args: args})
+	if err != nil {
+		complete(done, 0, err)
+		return errRow{err: err}
+	}
+
+	row := h.next.QueryRow(ctx, s.SQL, s.Args...)
+	if done == nil {
+		return row
+	}
+
+	return &hookedRow{row: row, done: done}
+}
+
+// SendBatch calls fn for every queued statement, the context returned by fn is not used.
+// A statement failed by fn fails the whole batch without sending it.
+func (h *hooked) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	hookedBatch := &pgx.Batch{QueuedQueries: make([]*pgx.QueuedQuery, 0, len(b.QueuedQueries))}
+	dones := make([]func(rows int64, err error), 0, len(b.QueuedQueries))
+
+	for _, q := range b.QueuedQueries {
+		_, s, done, err := h.fn(ctx, Statement{SQL: q.SQL, Args: q.Arguments})
+		dones = append(dones, done)
+
+		if err != nil {
+			for _, done := range dones {
+				complete(done, 0, err)
+			}
+			return errBatchResults{err: err}
+		}
+
+		hookedBatch.QueuedQueries = append(hookedBatch.QueuedQueries, &pgx.QueuedQuery{SQL: s.SQL, Arguments: s.Args, Fn: q.Fn})
+	}
+
+	return &hookedBatchResults{
+		BatchResults: nextBulk(ctx, h.next).SendBatch(ctx, hookedBatch),
+		dones:        dones,
+	}
+}
+
+// CopyFrom calls fn with a COPY statement of tableName without arguments, fn cannot replace it.
+func (h *hooked) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	ctx, _, done, err := h.fn(ctx, Statement{SQL: "COPY " + tableName.Sanitize()})
+	if err != nil {
+		complete(done, 0, err)
+		return 0, err
+	}
+
+	n, err := nextBulk(ctx, h.next).CopyFrom(ctx, tableName, columnNames, rowSrc)
+	complete(done, n, err)
+
+	return n, err
+}
+
+func complete(done func(rows int64, err error), rows int64, err error) {
+	if done != nil {
+		done(rows, err)
+	}
+}
+
+// hookedRows completes the statement once the rows are read or closed.
+type hookedRows struct {
+	pgx.Rows
+
+	done func(rows int64, err error)
+	read int64
+	once sync.Once
+}
+
+func (r *hookedRows) Next() bool {
+	if r.Rows.Next() {
+		r.read++
+		return true
+	}
+
+	r.complete()
+
+	return false
+}
+
+func (r *hookedRows) Close() {
+	r.Rows.Close()
+	r.complete()
+}
+
+func (r *hookedRows) complete() {
+	r.once.Do(func() {
+		r.done(r.read, r.Rows.Err())
+	})
+}
+
+type hookedRow struct {
+	row  pgx.Row
+	done func(rows int64, err error)
+}
+
+func (r *hookedRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+
+	switch {
+	case err == nil:
+		r.done(1, nil)
+	case errors.Is(err, pgx.ErrNoRows):
+		r.done(0, nil)
+	default:
+		r.done(0, err)
+	}
+
+	return err
+}
+
+// hookedBatchResults completes the queued statements in order as their results are read,
+// the unread ones when the results are closed.
+type hookedBatchResults struct {
+	pgx.BatchResults
+
+	dones []func(rows int64, err error)
+	next  int
+}
+
+func (r *hookedBatchResults) Exec() (pgconn.CommandTag, error) {
+	tag, err := r.BatchResults.Exec()
+	complete(r.nextDone(), tag.RowsAffected(), err)
+
+	return tag, err
+}
+
+func (r *hookedBatchResults) Query() (pgx.Rows, error) {
+	done := r.nextDone()
+
+	rows, err := r.BatchResults.Query()
+	if err != nil {
+		complete(done, 0, err)
+		return rows, err
+	}
+
+	if done == nil {
+		return rows, nil
+	}
+
+	return &hookedRows{Rows: rows, done: done}, nil
+}
+
+func (r *hookedBatchResults) QueryRow() pgx.Row {
+	done := r.nextDone()
+
+	row := r.BatchResults.QueryRow()
+	if done == nil {
+		return row
+	}
+
+	return &hookedRow{row: row, done: done}
+}
+
+func (r *hookedBatchResults) Close() error {
+	err := r.BatchResults.Close()
+
+	for r.next < len(r.dones) {
+		complete(r.nextDone(), 0, err)
+	}
+
+	return err
+}
+
+func (r *hookedBatchResults) nextDone() func(rows int64, err error) {
+	if r.next >= len(r.dones) {
+		return nil
+	}
+
+	done := r.dones[r.next]
+	r.next++
+
+	return done
+}
+
+type errBatchResults struct {
+	err error
+}
+
+func (r errBatchResults) Exec() (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, r.err
+}
+
+func (r errBatchResults) Query() (pgx.Rows, error) {
+	return nil, r.err
+}
+
+func (r errBatchResults) QueryRow() pgx.Row {
+	return errRow{err: r.err}
+}
+
+func (r errBatchResults) Close() error {
+	return r.err
+}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...any) error {
+	return r.err
+}
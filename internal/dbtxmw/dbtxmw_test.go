@@ -0,0 +1,332 @@
+package dbtxmw_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/dbtxmw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrap_Order(t *testing.T) {
+	fake := &fakeDBTX{}
+
+	var seen []string
+	trace := func(name string) dbtxmw.Middleware {
+		return dbtxmw.Hook(func(ctx context.Context, s dbtxmw.Statement) (context.Context, dbtxmw.Statement, func(int64, error), error) {
+			seen = append(seen, name+":"+s.SQL)
+			return ctx, s, nil, nil
+		})
+	}
+
+	dbtx := dbtxmw.Wrap(fake, trace("first"), dbtxmw.Tag(map[string]string{"app": "orders"}), trace("last"))
+
+	_, err := dbtx.Exec(t.Context(), "SELECT 1")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"first:SELECT 1", "last:SELECT 1 /*app='orders'*/"}, seen)
+	assert.Equal(t, []string{"SELECT 1 /*app='orders'*/"}, fake.sqls)
+}
+
+func TestWrap_Tx(t *testing.T) {
+	rec := &dbtxmw.Recorder{}
+	fake := &fakeTx{}
+
+	wrapped, ok := dbtxmw.Wrap(fake, rec.Middleware()).(pgx.Tx)
+	require.True(t, ok, "a wrapped pgx.Tx is a pgx.Tx")
+
+	savepoint, err := wrapped.Begin(t.Context())
+	require.NoError(t, err)
+
+	_, err = savepoint.Exec(t.Context(), "SELECT 1")
+	require.NoError(t, err)
+
+	statements := rec.Statements()
+	require.Len(t, statements, 1)
+	assert.Equal(t, "SELECT", statements[0].Name())
+
+	// the pool side is covered by the repository suite, it begins the transactions with BeginTx
+	_, ok = dbtxmw.Wrap(&fakeDBTX{}, rec.Middleware()).(interface {
+		BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error)
+	})
+	assert.False(t, ok, "a wrapper cannot begin transactions if the wrapped DBTX cannot")
+}
+
+func TestWrap_TxBatchAndCopy(t *testing.T) {
+	rec := &dbtxmw.Recorder{}
+	fake := &fakeTx{}
+	tx := dbtxmw.Wrap(fake, dbtxmw.Tag(map[string]string{"app": "orders"}), rec.Middleware()).(pgx.Tx)
+
+	batch := &pgx.Batch{}
+	batch.Queue(db.InsertOrderItem, "first")
+	batch.Queue(db.InsertOrderItem, "second")
+
+	results := tx.SendBatch(t.Context(), batch)
+	_, err := results.Exec()
+	require.NoError(t, err)
+	require.NoError(t, results.Close())
+
+	require.Len(t, fake.sqls, 2)
+	assert.True(t, strings.HasSuffix(fake.sqls[0], "/*app='orders'*/"), fake.sqls[0])
+	assert.False(t, strings.HasSuffix(batch.QueuedQueries[0].SQL, "/*app='orders'*/"), "the batch of the caller is kept")
+
+	n, err := tx.CopyFrom(t.Context(), pgx.Identifier{"orders_staging"}, []string{"id"}, pgx.CopyFromRows([][]any{{1}, {2}}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	statements := rec.Statements()
+	require.Len(t, statements, 3)
+	assert.Equal(t, "InsertOrderItem", statements[0].Name())
+	assert.Equal(t, []any{"first"}, statements[0].Args)
+	assert.Equal(t, int64(1), statements[0].Rows)
+	assert.Equal(t, "InsertOrderItem", statements[1].Name(), "an unread result completes on Close")
+	assert.Equal(t, `COPY "orders_staging"`, statements[2].SQL)
+	assert.Equal(t, int64(2), statements[2].Rows)
+
+	// a fault fails the whole batch without sending it
+	errInjected := errors.New("injected")
+	fake = &fakeTx{}
+	tx = dbtxmw.Wrap(fake, dbtxmw.Fault(func(context.Context, dbtxmw.Statement) error { return errInjected })).(pgx.Tx)
+
+	results = tx.SendBatch(t.Context(), batch)
+	_, err = results.Exec()
+	require.ErrorIs(t, err, errInjected)
+	require.ErrorIs(t, results.Close(), errInjected)
+	assert.Empty(t, fake.sqls)
+}
+
+func TestStatementTimeout(t *testing.T) {
+	fake := &fakeTx{}
+	tx := dbtxmw.Wrap(fake, dbtxmw.StatementTimeout(1500*time.Millisecond)).(pgx.Tx)
+
+	for range 2 {
+		_, err := tx.Exec(t.Context(), "SELECT 1")
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"SET LOCAL statement_timeout = 1500", "SELECT 1", "SELECT 1"}, fake.sqls)
+	assert.Empty(t, fake.deadlines, "the server enforces the timeout, not the context")
+
+	// a savepoint sets it again, so it holds after a rollback to the savepoint
+	savepoint, err := tx.Begin(t.Context())
+	require.NoError(t, err)
+
+	_, err = savepoint.Exec(t.Context(), "SELECT 1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SET LOCAL statement_timeout = 1500", "SELECT 1"}, fake.savepoint.sqls)
+
+	// outside of a transaction the timeout of the connection applies
+	pool := &fakeDBTX{}
+	_, err = dbtxmw.Wrap(pool, dbtxmw.StatementTimeout(time.Second)).Exec(t.Context(), "SELECT 1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SELECT 1"}, pool.sqls)
+}
+
+func TestTag(t *testing.T) {
+	fake := &fakeDBTX{}
+	dbtx := dbtxmw.Wrap(fake, dbtxmw.Tag(map[string]string{"app": "orders", "route": "static"}))
+
+	ctx := dbtxmw.ContextWithTags(t.Context(), map[string]string{"route": "/checkout"})
+
+	_, err := dbtx.Exec(ctx, db.InsertOrderItem)
+	require.NoError(t, err)
+
+	require.Len(t, fake.sqls, 1)
+	assert.True(t, strings.HasPrefix(fake.sqls[0], "-- name: InsertOrderItem :exec"))
+	assert.True(t, strings.HasSuffix(fake.sqls[0], ") /*app='orders',route='%2Fcheckout'*/"), fake.sqls[0])
+}
+
+func TestFault(t *testing.T) {
+	errInjected := errors.New("injected")
+
+	fake := &fakeDBTX{}
+	dbtx := dbtxmw.Wrap(fake, dbtxmw.Fault(func(_ context.Context, s dbtxmw.Statement) error {
+		if s.Name() == "InsertOrderItem" {
+			return errInjected
+		}
+		return nil
+	}))
+
+	_, err := dbtx.Exec(t.Context(), db.InsertOrderItem)
+	require.ErrorIs(t, err, errInjected)
+
+	_, err = dbtx.Query(t.Context(), db.InsertOrderItem)
+	require.ErrorIs(t, err, errInjected)
+
+	err = dbtx.QueryRow(t.Context(), db.InsertOrderItem).Scan()
+	require.ErrorIs(t, err, errInjected)
+
+	assert.Empty(t, fake.sqls)
+
+	_, err = dbtx.Exec(t.Context(), "SELECT 1")
+	require.NoError(t, err)
+	assert.Len(t, fake.sqls, 1)
+}
+
+func TestRecorder(t *testing.T) {
+	rec := &dbtxmw.Recorder{}
+	fake := &fakeDBTX{rows: 2, rowErr: pgx.ErrNoRows}
+	dbtx := dbtxmw.Wrap(fake, rec.Middleware())
+
+	rows, err := dbtx.Query(t.Context(), "SELECT id FROM orders WHERE owner_id = $1", "owner")
+	require.NoError(t, err)
+	for rows.Next() {
+	}
+	rows.Close()
+
+	err = dbtx.QueryRow(t.Context(), "SELECT id FROM orders").Scan()
+	require.ErrorIs(t, err, pgx.ErrNoRows)
+
+	statements := rec.Statements()
+	require.Len(t, statements, 2)
+
+	assert.Equal(t, []any{"owner"}, statements[0].Args)
+	assert.Equal(t, int64(2), statements[0].Rows)
+	assert.NoError(t, statements[0].Err)
+
+	assert.Equal(t, int64(0), statements[1].Rows)
+	assert.NoError(t, statements[1].Err, "no rows is not an error")
+
+	rec.Reset()
+	assert.Empty(t, rec.Statements())
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	fake := &fakeDBTX{execErr: errors.New("boom")}
+	dbtx := dbtxmw.Wrap(fake, dbtxmw.Log(logger))
+
+	_, err := dbtx.Exec(t.Context(), db.InsertOrderItem, "secret")
+	require.Error(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "level=ERROR")
+	assert.Contains(t, out, "query=InsertOrderItem")
+	assert.Contains(t, out, "error=boom")
+	assert.NotContains(t, out, "secret")
+}
+
+type fakeDBTX struct {
+	sqls      []string
+	deadlines []time.Time
+	execErr   error
+	rows      int
+	rowErr    error
+}
+
+func (f *fakeDBTX) record(ctx context.Context, sql string) {
+	f.sqls = append(f.sqls, sql)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		f.deadlines = append(f.deadlines, deadline)
+	}
+}
+
+func (f *fakeDBTX) Exec(ctx context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	f.record(ctx, sql)
+	return pgconn.NewCommandTag("SELECT 1"), f.execErr
+}
+
+func (f *fakeDBTX) Query(ctx context.Context, sql string, _ ...any) (pgx.Rows, error) {
+	f.record(ctx, sql)
+	return &fakeRows{left: f.rows}, nil
+}
+
+func (f *fakeDBTX) QueryRow(ctx context.Context, sql string, _ ...any) pgx.Row {
+	f.record(ctx, sql)
+	return fakeRow{err: f.rowErr}
+}
+
+// fakeTx only implements the methods used by the tests.
+type fakeTx struct {
+	pgx.Tx
+	fakeDBTX
+
+	savepoint *fakeTx
+}
+
+func (f *fakeTx) Begin(context.Context) (pgx.Tx, error) {
+	f.savepoint = &fakeTx{}
+	return f.savepoint, nil
+}
+
+func (f *fakeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return f.fakeDBTX.Exec(ctx, sql, args...)
+}
+
+func (f *fakeTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return f.fakeDBTX.Query(ctx, sql, args...)
+}
+
+func (f *fakeTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return f.fakeDBTX.QueryRow(ctx, sql, args...)
+}
+
+func (f *fakeTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	for _, q := range b.QueuedQueries {
+		f.record(ctx, q.SQL)
+	}
+	return &fakeBatchResults{}
+}
+
+func (f *fakeTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, _ []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	f.record(ctx, "COPY "+tableName.Sanitize())
+
+	var n int64
+	for rowSrc.Next() {
+		n++
+	}
+	return n, nil
+}
+
+// fakeBatchResults only implements the methods used by the tests.
+type fakeBatchResults struct {
+	pgx.BatchResults
+}
+
+func (r *fakeBatchResults) Exec() (pgconn.CommandTag, error) {
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+func (r *fakeBatchResults) Close() error {
+	return nil
+}
+
+// fakeRows only implements the methods used by the middlewares.
+type fakeRows struct {
+	pgx.Rows
+	left int
+}
+
+func (r *fakeRows) Next() bool {
+	if r.left == 0 {
+		return false
+	}
+	r.left--
+	return true
+}
+
+func (r *fakeRows) Close() {}
+
+func (r *fakeRows) Err() error {
+	return nil
+}
+
+type fakeRow struct {
+	err error
+}
+
+func (r fakeRow) Scan(...any) error {
+	return r.err
+}
@@ -0,0 +1,164 @@
+package dbtxmw
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatementTimeout makes the server cancel a statement of a transaction which runs longer than d
+// with SQLSTATE 57014 query_canceled, unlike a context deadline it keeps the connection.
+// It runs SET LOCAL statement_timeout once per transaction or savepoint before its first statement,
+// statements outside of a transaction keep the statement_timeout of the connection,
+// i.e. set it with RuntimeParams of pgxpool.Config.ConnConfig.
+func StatementTimeout(d time.Duration) Middleware {
+	setTimeout := "SET LOCAL statement_timeout = " + strconv.FormatInt(max(d.Milliseconds(), 1), 10)
+
+	return Hook(func(ctx context.Context, s Statement) (context.Context, Statement, func(int64, error), error) {
+		tx, ok := txFromContext(ctx)
+		if !ok || tx.statementTimeout == d {
+			return ctx, s, nil, nil
+		}
+
+		// the statement runs on the same connection, so it is bound by the timeout
+		if _, err := tx.Tx.Exec(ctx, setTimeout); err != nil {
+			return ctx, s, nil, fmt.Errorf("tx.Exec: %w", err)
+		}
+		tx.statementTimeout = d
+
+		return ctx, s, nil, nil
+	})
+}
+
+type tagsKey struct{}
+
+// ContextWithTags adds tags to the ones of ctx for the Tag middleware, i.e. the route of a request.
+func ContextWithTags(ctx context.Context, tags map[string]string) context.Context {
+	merged := make(map[string]string)
+
+	if parent, ok := ctx.Value(tagsKey{}).(map[string]string); ok {
+		maps.Copy(merged, parent)
+	}
+	maps.Copy(merged, tags)
+
+	return context.WithValue(ctx, tagsKey{}, merged)
+}
+
+// Tag appends a comment in the sqlcommenter format with tags and the tags of the context to every statement,
+// so they show up in pg_stat_activity and in the server logs, the tags of the context win.
+// Each distinct SQL text is prepared separately by pgx, so the values should have a low cardinality.
+func Tag(tags map[string]string) Middleware {
+	return Hook(func(ctx context.Context, s Statement) (context.Context, Statement, func(int64, error), error) {
+		merged := maps.Clone(tags)
+		if merged == nil {
+			merged = make(map[string]string)
+		}
+
+		if ctxTags, ok := ctx.Value(tagsKey{}).(map[string]string); ok {
+			maps.Copy(merged, ctxTags)
+		}
+
+		if len(merged) == 0 {
+			return ctx, s, nil, nil
+		}
+
+		s.SQL = strings.TrimRight(s.SQL, " \n") + " " + sqlComment(merged)
+
+		return ctx, s, nil, nil
+	})
+}
+
+// sqlComment formats tags like /*key='value'*/, the keys are sorted and the keys and the values are URL encoded.
+func sqlComment(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, url.QueryEscape(key)+"='"+url.QueryEscape(tags[key])+"'")
+	}
+
+	return "/*" + strings.Join(pairs, ",") + "*/"
+}
+
+// Log logs every statement at debug level, and the failed ones at error level, without their arguments.
+func Log(logger *slog.Logger) Middleware {
+	return Hook(func(ctx context.Context, s Statement) (context.Context, Statement, func(int64, error), error) {
+		start := time.Now()
+
+		return ctx, s, func(rows int64, err error) {
+			attrs := []slog.Attr{
+				slog.String("query", s.Name()),
+				slog.Duration("duration", time.Since(start)),
+				slog.Int64("rows", rows),
+			}
+
+			if err != nil {
+				logger.LogAttrs(ctx, slog.LevelError, "sql statement failed", append(attrs, slog.String("error", err.Error()))...)
+				return
+			}
+
+			logger.LogAttrs(ctx, slog.LevelDebug, "sql statement", attrs...)
+		}, nil
+	})
+}
+
+// Fault fails the statements for which fn returns an error with that error, without running them,
+// i.e. to test how the callers cope with a failing database.
+func Fault(fn func(ctx context.Context, s Statement) error) Middleware {
+	return Hook(func(ctx context.Context, s Statement) (context.Context, Statement, func(int64, error), error) {
+		return ctx, s, nil, fn(ctx, s)
+	})
+}
+
+// RecordedStatement is a statement seen by a Recorder and its outcome.
+type RecordedStatement struct {
+	Statement
+	Rows     int64
+	Err      error
+	Duration time.Duration
+}
+
+// Recorder keeps the statements which went through its middleware in the order of their completion.
+type Recorder struct {
+	mu         sync.Mutex
+	statements []RecordedStatement
+}
+
+func (r *Recorder) Middleware() Middleware {
+	return Hook(func(ctx context.Context, s Statement) (context.Context, Statement, func(int64, error), error) {
+		start := time.Now()
+
+		return ctx, s, func(rows int64, err error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			r.statements = append(r.statements, RecordedStatement{
+				Statement: s,
+				Rows:      rows,
+				Err:       err,
+				Duration:  time.Since(start),
+			})
+		}, nil
+	})
+}
+
+// Statements returns a copy of the recorded statements.
+func (r *Recorder) Statements() []RecordedStatement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.statements)
+}
+
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.statements = nil
+}
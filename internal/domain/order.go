@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"errors"
+	"fmt"
 	"net/url"
 	"time"
 
@@ -17,6 +19,11 @@ type Order struct {
 	Tags     []string
 	Payload  []byte
 	PayloadB []byte
+	// Version is bumped on every change of the order, the first version is 1
+	Version int64
+	// IdempotencyKey makes retries of InsertOrder safe, it is unique per owner and optional.
+	// It is only written, reads leave it empty.
+	IdempotencyKey string
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -30,3 +37,40 @@ type OrderItem struct {
 	CreatedAt time.Time
 	DeletedAt *time.Time
 }
+
+// ValidateOrderItems requires at least one item and a distinct, non-empty product ID for each of them.
+func ValidateOrderItems(items []OrderItem) error {
+	if len(items) == 0 {
+		return errors.New("no items in order")
+	}
+
+	seen := make(map[uuid.UUID]struct{}, len(items))
+	for _, item := range items {
+		if item.ProductID == uuid.Nil {
+			return errors.New("item productID is empty")
+		}
+
+		if _, ok := seen[item.ProductID]; ok {
+			return fmt.Errorf("product[%s] is listed twice", item.ProductID)
+		}
+		seen[item.ProductID] = struct{}{}
+	}
+
+	return nil
+}
+
+// URLString is the stored form of an order URL, nil is stored as an empty string.
+func URLString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+// PayloadOrEmpty is the stored form of an order payload, nil is stored as an empty JSON object.
+func PayloadOrEmpty(payload []byte) []byte {
+	if payload == nil {
+		return []byte(`{}`)
+	}
+	return payload
+}
@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type OrderEventType string
+
+const (
+	OrderEventCreated       OrderEventType = "OrderCreated"
+	OrderEventStatusChanged OrderEventType = "OrderStatusChanged"
+	OrderEventItemRemoved   OrderEventType = "OrderItemRemoved"
+	OrderEventDeleted       OrderEventType = "OrderDeleted"
+	OrderEventRestored      OrderEventType = "OrderRestored"
+	OrderEventItemRestored  OrderEventType = "OrderItemRestored"
+	OrderEventPurged        OrderEventType = "OrderPurged"
+)
+
+// OrderEvent is a domain event published to downstream services, Payload holds one of the *Payload structs as JSON.
+type OrderEvent struct {
+	ID        int64
+	Type      OrderEventType
+	OrderID   uuid.UUID
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+type OrderCreatedPayload struct {
+	OrderID       uuid.UUID       `json:"order_id"`
+	OwnerID       string          `json:"owner_id"`
+	Status        OrderStatus     `json:"status"`
+	PriceAmount   decimal.Decimal `json:"price_amount"`
+	PriceCurrency string          `json:"price_currency"`
+	ProductIDs    []uuid.UUID     `json:"product_ids"`
+}
+
+type OrderStatusChangedPayload struct {
+	OrderID uuid.UUID   `json:"order_id"`
+	From    OrderStatus `json:"from"`
+	To      OrderStatus `json:"to"`
+}
+
+type OrderItemRemovedPayload struct {
+	OrderID   uuid.UUID `json:"order_id"`
+	ProductID uuid.UUID `json:"product_id"`
+}
+
+// OrderDeletedPayload is emitted for soft and hard deletes, Hard is true when the order is gone for good.
+// Purging a soft-deleted order emits OrderPurgedPayload instead.
+type OrderDeletedPayload struct {
+	OrderID uuid.UUID `json:"order_id"`
+	Hard    bool      `json:"hard"`
+}
+
+type OrderRestoredPayload struct {
+	OrderID uuid.UUID `json:"order_id"`
+}
+
+type OrderItemRestoredPayload struct {
+	OrderID   uuid.UUID `json:"order_id"`
+	ProductID uuid.UUID `json:"product_id"`
+}
+
+// OrderPurgedPayload is emitted when Purge hard-deletes an order whose soft delete was emitted before.
+type OrderPurgedPayload struct {
+	OrderID uuid.UUID `json:"order_id"`
+}
@@ -0,0 +1,16 @@
+package domain
+
+import (
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
+)
+
+// OrderFacets holds order counts per facet value for the orders matching an OrderFilter.
+// An order is counted once per tag, PriceSums holds the total order price per currency.
+type OrderFacets struct {
+	Statuses   map[OrderStatus]int64
+	Currencies map[currency.Unit]int64
+	Tags       map[string]int64
+	Owners     map[string]int64
+	PriceSums  map[currency.Unit]decimal.Decimal
+}
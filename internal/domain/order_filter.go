@@ -17,11 +17,31 @@ type OrderFilter struct {
 	Tags        []string
 	CreatedAt   *TimeRange
 	UpdatedAt   *TimeRange
-	// TODO: add DeletedAt
+	DeletedAt   *TimeRange
+	Deleted     DeletedMode
 }
 
+// DeletedMode tells whether soft-deleted orders are matched by OrderFilter, they are excluded by default.
+type DeletedMode string
+
+// remember to add new modes to the validDeletedModes map
+const (
+	ExcludeDeleted DeletedMode = ""
+	IncludeDeleted DeletedMode = "include"
+	OnlyDeleted    DeletedMode = "only"
+)
+
+var validDeletedModes = map[DeletedMode]struct{}{
+	ExcludeDeleted: {},
+	IncludeDeleted: {},
+	OnlyDeleted:    {},
+}
+
+// Validate requires at least one criterion, a Deleted mode other than ExcludeDeleted is one,
+// i.e. OnlyDeleted alone lists every soft-deleted order.
 func (f OrderFilter) Validate() error {
-	if len(f.IDs) == 0 && len(f.OwnerIDs) == 0 && len(f.UrlPatterns) == 0 && len(f.Statuses) == 0 && len(f.Tags) == 0 && f.CreatedAt == nil && f.UpdatedAt == nil {
+	if len(f.IDs) == 0 && len(f.OwnerIDs) == 0 && len(f.UrlPatterns) == 0 && len(f.Statuses) == 0 && len(f.Tags) == 0 &&
+		f.CreatedAt == nil && f.UpdatedAt == nil && f.DeletedAt == nil && f.Deleted == ExcludeDeleted {
 		return errors.New("all fields are empty")
 	}
 
@@ -37,6 +57,20 @@ func (f OrderFilter) Validate() error {
 		}
 	}
 
+	if _, ok := validDeletedModes[f.Deleted]; !ok {
+		return fmt.Errorf("invalid deleted mode[%s]", f.Deleted)
+	}
+
+	if f.DeletedAt != nil {
+		if f.Deleted == ExcludeDeleted {
+			return errors.New("deletedAt requires IncludeDeleted or OnlyDeleted")
+		}
+
+		if err := f.DeletedAt.Validate(); err != nil {
+			return fmt.Errorf("deletedAt: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OrderChangeType string
+
+const (
+	OrderChangeStatus       OrderChangeType = "status_changed"
+	OrderChangeDeleted      OrderChangeType = "order_deleted"
+	OrderChangeItemDeleted  OrderChangeType = "item_deleted"
+	OrderChangeRestored     OrderChangeType = "order_restored"
+	OrderChangeItemRestored OrderChangeType = "item_restored"
+)
+
+// values of OrderHistoryEntry.OldValue and NewValue for deletions and restores
+const (
+	OrderStateActive  = "active"
+	OrderStateDeleted = "deleted"
+)
+
+// OrderHistoryEntry records a single change of an order, ProductID is set for changes of an order item.
+type OrderHistoryEntry struct {
+	ID        int64
+	OrderID   uuid.UUID
+	ProductID *uuid.UUID
+	Type      OrderChangeType
+	OldValue  string
+	NewValue  string
+	Actor     string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// AuditInfo tells who changed an order and why, it is recorded in the order history.
+type AuditInfo struct {
+	Actor  string
+	Reason string
+}
+
+type auditInfoKey struct{}
+
+// WithAuditInfo returns a copy of ctx carrying info for the order changes made with it.
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+// AuditInfoFromContext returns the AuditInfo stored by WithAuditInfo, or a zero AuditInfo.
+func AuditInfoFromContext(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditInfoKey{}).(AuditInfo)
+	return info
+}
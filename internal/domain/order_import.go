@@ -0,0 +1,10 @@
+package domain
+
+import "github.com/google/uuid"
+
+// InsertOrderResult is the outcome of inserting one order of a bulk import,
+// ID is set when Err is nil.
+type InsertOrderResult struct {
+	ID  uuid.UUID
+	Err error
+}
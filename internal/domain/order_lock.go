@@ -0,0 +1,27 @@
+package domain
+
+import "fmt"
+
+// LockMode tells GetOrderForUpdate what to do if another transaction holds the order lock, it waits by default.
+type LockMode string
+
+// remember to add new modes to the validLockModes map
+const (
+	LockWait       LockMode = ""
+	LockNoWait     LockMode = "nowait"
+	LockSkipLocked LockMode = "skip_locked"
+)
+
+var validLockModes = map[LockMode]struct{}{
+	LockWait:       {},
+	LockNoWait:     {},
+	LockSkipLocked: {},
+}
+
+func (m LockMode) Validate() error {
+	if _, ok := validLockModes[m]; !ok {
+		return fmt.Errorf("invalid lock mode[%s]", m)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+const MaxPageSize = 1000
+
+type OrderSortKey string
+
+// remember to add new sort keys to the validOrderSortKeys map
+const (
+	OrderSortByCreatedAt   OrderSortKey = "created_at"
+	OrderSortByUpdatedAt   OrderSortKey = "updated_at"
+	OrderSortByPriceAmount OrderSortKey = "price_amount"
+	OrderSortByID          OrderSortKey = "id"
+)
+
+var validOrderSortKeys = map[OrderSortKey]struct{}{
+	OrderSortByCreatedAt:   {},
+	OrderSortByUpdatedAt:   {},
+	OrderSortByPriceAmount: {},
+	OrderSortByID:          {},
+}
+
+// PageRequest asks for a page of orders sorted ascending by SortKey, ties are broken by order ID.
+// Cursor is the opaque OrderPage.NextCursor of the previous page, empty for the first page.
+type PageRequest struct {
+	Size    int
+	SortKey OrderSortKey
+	Cursor  string
+}
+
+func (p PageRequest) Validate() error {
+	if p.Size <= 0 {
+		return errors.New("size must be positive")
+	}
+
+	if p.Size > MaxPageSize {
+		return fmt.Errorf("size must not exceed %d", MaxPageSize)
+	}
+
+	if _, ok := validOrderSortKeys[p.SortKey]; !ok {
+		return fmt.Errorf("invalid sort key[%s]", p.SortKey)
+	}
+
+	return nil
+}
+
+// OrderPage holds a page of orders, NextCursor is empty when there are no more pages.
+type OrderPage struct {
+	Orders     []Order
+	NextCursor string
+}
@@ -0,0 +1,19 @@
+package domain
+
+// PurgeResult tells how many soft-deleted rows were hard-deleted, Items includes the items of the purged orders,
+// each of Orders and Items is at most the batch size of a single Purge.
+type PurgeResult struct {
+	Orders int64
+	Items  int64
+}
+
+func (r PurgeResult) Add(other PurgeResult) PurgeResult {
+	return PurgeResult{
+		Orders: r.Orders + other.Orders,
+		Items:  r.Items + other.Items,
+	}
+}
+
+func (r PurgeResult) IsZero() bool {
+	return r.Orders == 0 && r.Items == 0
+}
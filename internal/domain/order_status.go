@@ -2,11 +2,14 @@ package domain
 
 import (
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
 )
 
 type OrderStatus string
 
-// remember to add new statuses to the validOrderStatuses map
+// remember to add new statuses to the validOrderStatuses map and to the orderStatusTransitions graph
 const (
 	OrderStatusPending   OrderStatus = "pending"
 	OrderStatusShipped   OrderStatus = "shipped"
@@ -21,6 +24,29 @@ var validOrderStatuses = map[OrderStatus]struct{}{
 	OrderStatusCancelled: {},
 }
 
+// orderStatusTransitions maps a status to the statuses it can move to, delivered and cancelled are terminal
+var orderStatusTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending: {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped: {OrderStatusDelivered, OrderStatusCancelled},
+}
+
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// InvalidTransitionError is returned when an order cannot move from its current status to the requested one,
+// it matches ErrInvalidTransition with errors.Is.
+type InvalidTransitionError struct {
+	From OrderStatus
+	To   OrderStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("order status transition from[%s] to[%s] is not allowed", e.From, e.To)
+}
+
+func (e *InvalidTransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
 func ToOrderStatus(s string) (OrderStatus, error) {
 	status := OrderStatus(s)
 	if _, ok := validOrderStatuses[status]; ok {
@@ -29,3 +55,21 @@ func ToOrderStatus(s string) (OrderStatus, error) {
 
 	return "", errors.New("invalid order status")
 }
+
+// CanTransition reports whether an order in status from can be moved to status to.
+func CanTransition(from, to OrderStatus) bool {
+	return slices.Contains(orderStatusTransitions[from], to)
+}
+
+// TransitionSources returns the statuses an order can be moved to status to from.
+func TransitionSources(to OrderStatus) []OrderStatus {
+	var sources []OrderStatus
+
+	for _, from := range slices.Sorted(maps.Keys(orderStatusTransitions)) {
+		if CanTransition(from, to) {
+			sources = append(sources, from)
+		}
+	}
+
+	return sources
+}
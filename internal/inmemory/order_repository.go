@@ -0,0 +1,850 @@
+package inmemory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/samber/lo"
+)
+
+// OrderRepository is an OrderRepository which keeps the orders in memory, it is meant for tests.
+// It follows the semantics of the PostgreSQL repository and returns the same repository errors,
+// each call is atomic and transactions are available through NewTxManager.
+type OrderRepository struct {
+	clock func() time.Time
+
+	// writeMu serializes the writes, a transaction holds it until it ends
+	writeMu sync.Mutex
+	// mu guards committed, which is replaced by every write
+	mu        sync.RWMutex
+	committed *state
+}
+
+var _ port.OrderRepository = (*OrderRepository)(nil)
+
+// Option configures the repository created by NewOrder.
+type Option func(*OrderRepository)
+
+// WithClock sets the source of the timestamps, the default is time.Now.
+func WithClock(clock func() time.Time) Option {
+	return func(r *OrderRepository) {
+		if clock != nil {
+			r.clock = clock
+		}
+	}
+}
+
+func NewOrder(opts ...Option) *OrderRepository {
+	r := &OrderRepository{
+		clock:     time.Now,
+		committed: newState(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// now returns the time truncated to the precision of a PostgreSQL TIMESTAMP.
+func (r *OrderRepository) now() time.Time {
+	return r.clock().UTC().Truncate(time.Microsecond)
+}
+
+func (r *OrderRepository) snapshot() *state {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.committed
+}
+
+func (r *OrderRepository) publish(st *state) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.committed = st
+}
+
+// read returns the state seen by ctx, the one of its transaction or the committed one.
+func (r *OrderRepository) read(ctx context.Context) *state {
+	if t, ok := r.txFromContext(ctx); ok {
+		return t.snapshot()
+	}
+
+	return r.snapshot()
+}
+
+// write runs fn on a clone of the state seen by ctx and keeps the clone only if fn succeeds,
+// now is the start time of the transaction, like NOW() in PostgreSQL.
+func (r *OrderRepository) write(ctx context.Context, fn func(st *state, now time.Time) error) error {
+	if t, ok := r.txFromContext(ctx); ok {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		next := t.st.clone()
+		if err := fn(next, t.now); err != nil {
+			return err
+		}
+		t.st = next
+
+		return nil
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	next := r.snapshot().clone()
+	if err := fn(next, r.now()); err != nil {
+		return err
+	}
+	r.publish(next)
+
+	return nil
+}
+
+func (r *OrderRepository) GetOrder(ctx context.Context, orderID uuid.UUID) (domain.Order, error) {
+	rec, ok := r.read(ctx).orders[orderID]
+	if !ok || rec.order.DeletedAt != nil {
+		return domain.Order{}, repository.ErrNotFound
+	}
+
+	order := rec.view()
+	if len(order.Items) == 0 {
+		// the items are joined to the order
+		return domain.Order{}, repository.ErrNotFound
+	}
+
+	// the join only reads the created_at of the order
+	for i := range order.Items {
+		order.Items[i].CreatedAt = order.CreatedAt
+	}
+
+	return order, nil
+}
+
+func (r *OrderRepository) GetOrderSeparateQueries(ctx context.Context, orderID uuid.UUID) (domain.Order, error) {
+	rec, ok := r.read(ctx).orders[orderID]
+	if !ok || rec.order.DeletedAt != nil {
+		return domain.Order{}, repository.ErrNotFound
+	}
+
+	return rec.view(), nil
+}
+
+// GetOrderForUpdate needs a transaction of NewTxManager like the PostgreSQL repository,
+// the transactions run one at a time, so the order is never locked by another one.
+func (r *OrderRepository) GetOrderForUpdate(ctx context.Context, orderID uuid.UUID, mode domain.LockMode) (domain.Order, error) {
+	if orderID == uuid.Nil {
+		return domain.Order{}, fmt.Errorf("orderID is empty")
+	}
+
+	if err := mode.Validate(); err != nil {
+		return domain.Order{}, fmt.Errorf("mode.Validate: %w", err)
+	}
+
+	if _, ok := r.txFromContext(ctx); !ok {
+		return domain.Order{}, repository.ErrTxRequired
+	}
+
+	return r.GetOrderSeparateQueries(ctx, orderID)
+}
+
+// LockOrder needs a transaction of NewTxManager, it never waits as the transactions run one at a time.
+func (r *OrderRepository) LockOrder(ctx context.Context, orderID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if _, ok := r.txFromContext(ctx); !ok {
+		return repository.ErrTxRequired
+	}
+
+	return nil
+}
+
+func (r *OrderRepository) InsertOrder(ctx context.Context, order domain.Order) (uuid.UUID, error) {
+	if err := domain.ValidateOrderItems(order.Items); err != nil {
+		return uuid.Nil, fmt.Errorf("domain.ValidateOrderItems: %w", err)
+	}
+
+	if err := validatePayloads(order.Payload, order.PayloadB); err != nil {
+		return uuid.Nil, err
+	}
+
+	if order.ID == uuid.Nil {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("uuid.NewV7: %w", err)
+		}
+		order.ID = id
+	}
+
+	var orderID uuid.UUID
+	err := r.write(ctx, func(st *state, now time.Time) error {
+		id, err := insertOrder(st, now, order)
+		if err != nil {
+			return err
+		}
+
+		orderID = id
+		return nil
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return orderID, nil
+}
+
+// insertOrder returns the ID of the original order for a replay of an idempotency key.
+func insertOrder(st *state, now time.Time, order domain.Order) (uuid.UUID, error) {
+	requestHash, err := orderRequestHash(order)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("orderRequestHash: %w", err)
+	}
+
+	_, idTaken := st.orders[order.ID]
+	keyTaken := order.IdempotencyKey != "" && st.findByIdempotencyKey(order.OwnerID, order.IdempotencyKey) != nil
+
+	if idTaken || keyTaken {
+		return resolveInsertConflict(st, order, requestHash)
+	}
+
+	rec := &orderRecord{
+		order:       cloneOrder(order),
+		requestHash: requestHash,
+	}
+	rec.order.Status = domain.OrderStatusPending
+	rec.order.Payload = domain.PayloadOrEmpty(rec.order.Payload)
+	rec.order.Version = 1
+	rec.order.CreatedAt = now
+	rec.order.UpdatedAt = now
+	rec.order.DeletedAt = nil
+
+	for i := range rec.order.Items {
+		rec.order.Items[i].CreatedAt = now
+		rec.order.Items[i].DeletedAt = nil
+	}
+
+	st.orders[order.ID] = rec
+
+	return order.ID, nil
+}
+
+func resolveInsertConflict(st *state, order domain.Order, requestHash string) (uuid.UUID, error) {
+	if order.IdempotencyKey == "" {
+		return uuid.Nil, repository.ErrAlreadyExists
+	}
+
+	original := st.findByIdempotencyKey(order.OwnerID, order.IdempotencyKey)
+	if original == nil {
+		return uuid.Nil, repository.ErrAlreadyExists
+	}
+
+	if original.requestHash != requestHash {
+		return uuid.Nil, repository.ErrIdempotencyConflict
+	}
+
+	return original.order.ID, nil
+}
+
+// InsertOrders inserts the orders in a single write, an order which fails validation gets an Err
+// and is skipped while the rest are still inserted.
+func (r *OrderRepository) InsertOrders(ctx context.Context, orders []domain.Order) ([]domain.InsertOrderResult, error) {
+	results := make([]domain.InsertOrderResult, len(orders))
+
+	seenIDs := make(map[uuid.UUID]struct{}, len(orders))
+
+	valid := make([]int, 0, len(orders))
+	for i := range orders {
+		order := &orders[i]
+
+		if err := domain.ValidateOrderItems(order.Items); err != nil {
+			results[i].Err = fmt.Errorf("domain.ValidateOrderItems: %w", err)
+			continue
+		}
+
+		// the PostgreSQL repository fails the whole import on a database error
+		if err := validatePayloads(order.Payload, order.PayloadB); err != nil {
+			return nil, err
+		}
+
+		id := order.ID
+		if id == uuid.Nil {
+			var err error
+			if id, err = uuid.NewV7(); err != nil {
+				return nil, fmt.Errorf("uuid.NewV7: %w", err)
+			}
+		}
+
+		if _, ok := seenIDs[id]; ok {
+			results[i].Err = fmt.Errorf("order[%s] is listed twice: %w", id, repository.ErrAlreadyExists)
+			continue
+		}
+		seenIDs[id] = struct{}{}
+
+		results[i].ID = id
+		valid = append(valid, i)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	err := r.write(ctx, func(st *state, now time.Time) error {
+		for _, i := range valid {
+			order := orders[i]
+			order.ID = results[i].ID
+
+			id, err := insertOrder(st, now, order)
+			if errors.Is(err, repository.ErrAlreadyExists) || errors.Is(err, repository.ErrIdempotencyConflict) {
+				results[i].ID = uuid.Nil
+				results[i].Err = fmt.Errorf("resolveInsertConflict: %w", err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			results[i].ID = id
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *OrderRepository) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status domain.OrderStatus, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if expectedVersion <= 0 {
+		return fmt.Errorf("expectedVersion must be positive")
+	}
+
+	if status == "" {
+		return fmt.Errorf("status is empty")
+	}
+
+	if _, err := domain.ToOrderStatus(string(status)); err != nil {
+		return fmt.Errorf("domain.ToOrderStatus[%s]: %w", status, err)
+	}
+
+	return r.write(ctx, func(st *state, now time.Time) error {
+		rec := st.liveOrder(orderID)
+		if rec == nil {
+			return repository.ErrNotFound
+		}
+
+		if rec.order.Version != expectedVersion {
+			return repository.ErrConcurrentModification
+		}
+
+		from := rec.order.Status
+		if !domain.CanTransition(from, status) {
+			return &domain.InvalidTransitionError{From: from, To: status}
+		}
+
+		rec.order.Status = status
+		rec.touch(now)
+
+		st.recordChange(now, domain.AuditInfoFromContext(ctx), orderID, nil, domain.OrderChangeStatus, string(from), string(status))
+
+		return nil
+	})
+}
+
+// UpdateOrder replaces the items, tags, URL, payloads and price currency of the order,
+// order.Version is the expected version. Owner and status are left as they are.
+func (r *OrderRepository) UpdateOrder(ctx context.Context, order domain.Order) error {
+	if order.ID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if err := domain.ValidateOrderItems(order.Items); err != nil {
+		return fmt.Errorf("domain.ValidateOrderItems: %w", err)
+	}
+
+	if err := validatePayloads(order.Payload, order.PayloadB); err != nil {
+		return err
+	}
+
+	return r.updateOrder(ctx, order.ID, order.Version, func(st *state, rec *orderRecord, now time.Time) error {
+		rec.order.Url = cloneURL(order.Url)
+		rec.order.Tags = slices.Clone(order.Tags)
+		rec.order.Payload = domain.PayloadOrEmpty(slices.Clone(order.Payload))
+		rec.order.PayloadB = slices.Clone(order.PayloadB)
+		rec.order.Price.Currency = order.Price.Currency
+
+		replaceOrderItems(ctx, st, rec, now, order.Items)
+
+		return nil
+	})
+}
+
+// AddOrderItems adds items to the order, a soft-deleted item is revived,
+// it fails if any of the items is already in the order.
+func (r *OrderRepository) AddOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if err := domain.ValidateOrderItems(items); err != nil {
+		return fmt.Errorf("domain.ValidateOrderItems: %w", err)
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(_ *state, rec *orderRecord, now time.Time) error {
+		for _, item := range items {
+			existing := rec.item(item.ProductID)
+			if existing != nil && existing.DeletedAt == nil {
+				return fmt.Errorf("product[%s] is already in the order", item.ProductID)
+			}
+
+			upsertItem(rec, now, item)
+		}
+
+		return nil
+	})
+}
+
+// ReplaceOrderItems makes items the only items of the order, the items not in the list are soft-deleted.
+func (r *OrderRepository) ReplaceOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if err := domain.ValidateOrderItems(items); err != nil {
+		return fmt.Errorf("domain.ValidateOrderItems: %w", err)
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(st *state, rec *orderRecord, now time.Time) error {
+		replaceOrderItems(ctx, st, rec, now, items)
+		return nil
+	})
+}
+
+func (r *OrderRepository) SetTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(_ *state, rec *orderRecord, _ time.Time) error {
+		rec.order.Tags = slices.Clone(tags)
+		return nil
+	})
+}
+
+// AddTags appends the tags which the order does not have yet, keeping their order.
+func (r *OrderRepository) AddTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if len(tags) == 0 {
+		return fmt.Errorf("tags are empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(_ *state, rec *orderRecord, _ time.Time) error {
+		existing := rec.order.Tags
+		if existing == nil {
+			existing = []string{}
+		}
+
+		for _, tag := range lo.Uniq(tags) {
+			if !slices.Contains(rec.order.Tags, tag) {
+				existing = append(existing, tag)
+			}
+		}
+		rec.order.Tags = existing
+
+		return nil
+	})
+}
+
+func (r *OrderRepository) RemoveTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if len(tags) == 0 {
+		return fmt.Errorf("tags are empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(_ *state, rec *orderRecord, _ time.Time) error {
+		remaining := []string{}
+		for _, tag := range rec.order.Tags {
+			if !slices.Contains(tags, tag) {
+				remaining = append(remaining, tag)
+			}
+		}
+		rec.order.Tags = remaining
+
+		return nil
+	})
+}
+
+// SetURL sets the URL of the order, nil clears it.
+func (r *OrderRepository) SetURL(ctx context.Context, orderID uuid.UUID, u *url.URL, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(_ *state, rec *orderRecord, _ time.Time) error {
+		rec.order.Url = cloneURL(u)
+		return nil
+	})
+}
+
+// SetPayload sets the JSON and JSONB payloads of the order, a nil payload is stored as an empty JSON object.
+func (r *OrderRepository) SetPayload(ctx context.Context, orderID uuid.UUID, payload, payloadB []byte, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if err := validatePayloads(payload, payloadB); err != nil {
+		return err
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(_ *state, rec *orderRecord, _ time.Time) error {
+		rec.order.Payload = domain.PayloadOrEmpty(slices.Clone(payload))
+		rec.order.PayloadB = slices.Clone(payloadB)
+		return nil
+	})
+}
+
+// updateOrder checks the version of the order and runs fn,
+// then recomputes the order price, which also bumps the version and updated_at.
+func (r *OrderRepository) updateOrder(ctx context.Context, orderID uuid.UUID, expectedVersion int64, fn func(st *state, rec *orderRecord, now time.Time) error) error {
+	if expectedVersion <= 0 {
+		return fmt.Errorf("expectedVersion must be positive")
+	}
+
+	return r.write(ctx, func(st *state, now time.Time) error {
+		rec := st.liveOrder(orderID)
+		if rec == nil {
+			return repository.ErrNotFound
+		}
+
+		if rec.order.Version != expectedVersion {
+			return repository.ErrConcurrentModification
+		}
+
+		if err := fn(st, rec, now); err != nil {
+			return err
+		}
+
+		rec.recomputePrice(now)
+
+		return nil
+	})
+}
+
+// upsertItem inserts the item or overwrites it, a revived item gets a new created_at.
+func upsertItem(rec *orderRecord, now time.Time, item domain.OrderItem) {
+	existing := rec.item(item.ProductID)
+	if existing == nil {
+		rec.order.Items = append(rec.order.Items, domain.OrderItem{
+			ProductID: item.ProductID,
+			Price:     item.Price,
+			CreatedAt: now,
+		})
+		return
+	}
+
+	if existing.DeletedAt != nil {
+		existing.CreatedAt = now
+		existing.DeletedAt = nil
+	}
+	existing.Price = item.Price
+}
+
+// replaceOrderItems soft-deletes the items not in items and records them like SoftDeleteOrderItem does.
+func replaceOrderItems(ctx context.Context, st *state, rec *orderRecord, now time.Time, items []domain.OrderItem) {
+	for i := range rec.order.Items {
+		existing := &rec.order.Items[i]
+
+		listed := slices.ContainsFunc(items, func(item domain.OrderItem) bool {
+			return item.ProductID == existing.ProductID
+		})
+		if !listed && existing.DeletedAt == nil {
+			existing.DeletedAt = &now
+
+			productID := existing.ProductID
+			st.recordChange(now, domain.AuditInfoFromContext(ctx), rec.order.ID, &productID, domain.OrderChangeItemDeleted, domain.OrderStateActive, domain.OrderStateDeleted)
+		}
+	}
+
+	for _, item := range items {
+		upsertItem(rec, now, item)
+	}
+}
+
+func (r *OrderRepository) DeleteOrder(ctx context.Context, orderID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	return r.write(ctx, func(st *state, _ time.Time) error {
+		rec, ok := st.orders[orderID]
+		if !ok || len(rec.order.Items) == 0 {
+			return repository.ErrNotFound
+		}
+
+		// the history outlives the order
+		delete(st.orders, orderID)
+
+		return nil
+	})
+}
+
+func (r *OrderRepository) SoftDeleteOrder(ctx context.Context, orderID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	return r.write(ctx, func(st *state, now time.Time) error {
+		rec := st.liveOrder(orderID)
+		if rec == nil {
+			return repository.ErrNotFound
+		}
+
+		rec.order.DeletedAt = &now
+		rec.order.Version++
+
+		st.recordChange(now, domain.AuditInfoFromContext(ctx), orderID, nil, domain.OrderChangeDeleted, domain.OrderStateActive, domain.OrderStateDeleted)
+
+		return nil
+	})
+}
+
+func (r *OrderRepository) SoftDeleteOrderItem(ctx context.Context, orderID, productID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+	if productID == uuid.Nil {
+		return fmt.Errorf("productID is empty")
+	}
+
+	return r.write(ctx, func(st *state, now time.Time) error {
+		rec := st.mutable(orderID)
+		if rec == nil {
+			return repository.ErrNotFound
+		}
+
+		item := rec.item(productID)
+		if item == nil || item.DeletedAt != nil {
+			return repository.ErrNotFound
+		}
+
+		// the price of a deleted order is not recomputed, so nothing changes
+		if rec.order.DeletedAt != nil {
+			return repository.ErrNotFound
+		}
+
+		item.DeletedAt = &now
+		rec.recomputePrice(now)
+
+		st.recordChange(now, domain.AuditInfoFromContext(ctx), orderID, &productID, domain.OrderChangeItemDeleted, domain.OrderStateActive, domain.OrderStateDeleted)
+
+		return nil
+	})
+}
+
+// RestoreOrder undoes SoftDeleteOrder, the price is recomputed from the items which are not deleted.
+func (r *OrderRepository) RestoreOrder(ctx context.Context, orderID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	return r.write(ctx, func(st *state, now time.Time) error {
+		rec := st.mutable(orderID)
+		if rec == nil || rec.order.DeletedAt == nil {
+			return repository.ErrNotFound
+		}
+
+		rec.order.DeletedAt = nil
+		rec.recomputePrice(now)
+
+		st.recordChange(now, domain.AuditInfoFromContext(ctx), orderID, nil, domain.OrderChangeRestored, domain.OrderStateDeleted, domain.OrderStateActive)
+
+		return nil
+	})
+}
+
+// RestoreOrderItem undoes SoftDeleteOrderItem and recomputes the order price, the order itself must not be deleted.
+func (r *OrderRepository) RestoreOrderItem(ctx context.Context, orderID, productID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+	if productID == uuid.Nil {
+		return fmt.Errorf("productID is empty")
+	}
+
+	return r.write(ctx, func(st *state, now time.Time) error {
+		rec := st.mutable(orderID)
+		if rec == nil {
+			return repository.ErrNotFound
+		}
+
+		item := rec.item(productID)
+		if item == nil || item.DeletedAt == nil || rec.order.DeletedAt != nil {
+			return repository.ErrNotFound
+		}
+
+		item.DeletedAt = nil
+		rec.recomputePrice(now)
+
+		st.recordChange(now, domain.AuditInfoFromContext(ctx), orderID, &productID, domain.OrderChangeItemRestored, domain.OrderStateDeleted, domain.OrderStateActive)
+
+		return nil
+	})
+}
+
+// Purge hard-deletes up to batchSize items which were soft-deleted more than olderThan ago, themselves or with their order,
+// then up to batchSize such orders which have no items left, like the PostgreSQL repository.
+func (r *OrderRepository) Purge(ctx context.Context, olderThan time.Duration, batchSize int) (domain.PurgeResult, error) {
+	var result domain.PurgeResult
+
+	if olderThan < 0 {
+		return result, fmt.Errorf("olderThan must not be negative")
+	}
+	if batchSize <= 0 {
+		return result, fmt.Errorf("batchSize must be positive")
+	}
+
+	err := r.write(ctx, func(st *state, now time.Time) error {
+		var res domain.PurgeResult
+		cutoff := now.Add(-olderThan)
+
+		type itemKey struct {
+			orderID   uuid.UUID
+			productID uuid.UUID
+			deletedAt time.Time
+		}
+
+		// items go first, as in the PostgreSQL repository
+		var items []itemKey
+		for _, rec := range st.orders {
+			for _, item := range rec.order.Items {
+				deletedAt := item.DeletedAt
+				if rec.order.DeletedAt != nil && (deletedAt == nil || rec.order.DeletedAt.Before(*deletedAt)) {
+					deletedAt = rec.order.DeletedAt
+				}
+
+				if deletedAt != nil && deletedAt.Before(cutoff) {
+					items = append(items, itemKey{orderID: rec.order.ID, productID: item.ProductID, deletedAt: *deletedAt})
+				}
+			}
+		}
+		slices.SortFunc(items, func(a, b itemKey) int {
+			return a.deletedAt.Compare(b.deletedAt)
+		})
+
+		for _, key := range items[:min(len(items), batchSize)] {
+			rec := st.mutable(key.orderID)
+			rec.order.Items = slices.DeleteFunc(rec.order.Items, func(item domain.OrderItem) bool {
+				return item.ProductID == key.productID
+			})
+			res.Items++
+		}
+
+		var orders []*orderRecord
+		for _, rec := range st.orders {
+			if rec.order.DeletedAt != nil && rec.order.DeletedAt.Before(cutoff) && len(rec.order.Items) == 0 {
+				orders = append(orders, rec)
+			}
+		}
+		slices.SortFunc(orders, func(a, b *orderRecord) int {
+			return a.order.DeletedAt.Compare(*b.order.DeletedAt)
+		})
+
+		for _, rec := range orders[:min(len(orders), batchSize)] {
+			res.Orders++
+			delete(st.orders, rec.order.ID)
+		}
+
+		result = res
+		return nil
+	})
+	if err != nil {
+		return domain.PurgeResult{}, err
+	}
+
+	return result, nil
+}
+
+func (r *OrderRepository) GetOrderHistory(ctx context.Context, orderID uuid.UUID) ([]domain.OrderHistoryEntry, error) {
+	if orderID == uuid.Nil {
+		return nil, fmt.Errorf("orderID is empty")
+	}
+
+	entries := make([]domain.OrderHistoryEntry, 0)
+	for _, entry := range r.read(ctx).history {
+		if entry.OrderID == orderID {
+			entry.ProductID = cloneUUIDPtr(entry.ProductID)
+			entries = append(entries, entry)
+		}
+	}
+
+	slices.SortStableFunc(entries, func(a, b domain.OrderHistoryEntry) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return entries, nil
+}
+
+// orderRequestHash is the part of the order an idempotent replay must repeat, empty for an order without a key.
+func orderRequestHash(order domain.Order) (string, error) {
+	if order.IdempotencyKey == "" {
+		return "", nil
+	}
+
+	items := make([]string, 0, len(order.Items))
+	for _, item := range order.Items {
+		items = append(items, fmt.Sprintf("%s:%s:%s", item.ProductID, item.Price.Amount, item.Price.Currency))
+	}
+
+	b, err := json.Marshal([]any{
+		domain.URLString(order.Url),
+		order.Tags,
+		order.Payload,
+		order.PayloadB,
+		order.Price.Amount.String(),
+		order.Price.Currency.String(),
+		items,
+	})
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	return string(b), nil
+}
+
+// validatePayloads rejects what PostgreSQL rejects for the JSON and JSONB columns.
+func validatePayloads(payload, payloadB []byte) error {
+	if payload != nil && !json.Valid(payload) {
+		return fmt.Errorf("payload is not valid JSON: %w", repository.ErrInvalidInput)
+	}
+
+	if payloadB != nil && !json.Valid(payloadB) {
+		return fmt.Errorf("payloadB is not valid JSON: %w", repository.ErrInvalidInput)
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package inmemory_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/inmemory"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/nikolayk812/sqlcpp/internal/repository/repositorytest"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) port.OrderRepository {
+		return inmemory.NewOrder()
+	})
+}
+
+func TestOrderRepository_SoftDelete(t *testing.T) {
+	ctx := t.Context()
+	repo := inmemory.NewOrder()
+
+	order := newOrder("10.50", "2.25")
+	orderID, err := repo.InsertOrder(ctx, order)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.SoftDeleteOrderItem(ctx, orderID, order.Items[0].ProductID))
+
+	actual, err := repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	require.Len(t, actual.Items, 1)
+	assert.True(t, decimal.RequireFromString("2.25").Equal(actual.Price.Amount))
+	assert.Equal(t, int64(2), actual.Version)
+
+	err = repo.SoftDeleteOrderItem(ctx, orderID, order.Items[0].ProductID)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+
+	require.NoError(t, repo.SoftDeleteOrder(ctx, orderID))
+
+	_, err = repo.GetOrder(ctx, orderID)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+
+	deleted, err := repo.SearchOrders(ctx, domain.OrderFilter{IDs: []uuid.UUID{orderID}, Deleted: domain.OnlyDeleted})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.NotNil(t, deleted[0].DeletedAt)
+
+	// the deletion state alone is a valid filter
+	deleted, err = repo.SearchOrders(ctx, domain.OrderFilter{Deleted: domain.OnlyDeleted})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, orderID, deleted[0].ID)
+
+	require.NoError(t, repo.RestoreOrder(ctx, orderID))
+
+	history, err := repo.GetOrderHistory(ctx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.OrderChangeType{domain.OrderChangeItemDeleted, domain.OrderChangeDeleted, domain.OrderChangeRestored},
+		[]domain.OrderChangeType{history[0].Type, history[1].Type, history[2].Type})
+}
+
+func TestOrderRepository_ReplaceOrderItems(t *testing.T) {
+	ctx := t.Context()
+	repo := inmemory.NewOrder()
+
+	order := newOrder("10.50", "2.25")
+	orderID, err := repo.InsertOrder(ctx, order)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.ReplaceOrderItems(ctx, orderID, order.Items[1:], 1))
+
+	// the replaced item is recorded like a soft-deleted one
+	history, err := repo.GetOrderHistory(ctx, orderID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, domain.OrderChangeItemDeleted, history[0].Type)
+	assert.Equal(t, order.Items[0].ProductID, *history[0].ProductID)
+}
+
+func TestOrderRepository_SearchOrders(t *testing.T) {
+	ctx := t.Context()
+	repo := inmemory.NewOrder()
+
+	shop := newOrder("1")
+	shop.Url = mustParseURL("https://Shop.example.com/cart_1")
+	shop.Tags = []string{"gift", "express"}
+
+	blog := newOrder("2")
+	blog.Url = mustParseURL("https://blog.example.com/cart")
+	blog.Tags = []string{"express"}
+
+	ids := make([]uuid.UUID, 0, 2)
+	for _, order := range []domain.Order{shop, blog} {
+		id, err := repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	tests := []struct {
+		name   string
+		filter domain.OrderFilter
+		want   []uuid.UUID
+	}{
+		{
+			name:   "url pattern is case-insensitive",
+			filter: domain.OrderFilter{UrlPatterns: []string{"SHOP"}},
+			want:   ids[:1],
+		},
+		{
+			name:   "underscore matches any character",
+			filter: domain.OrderFilter{UrlPatterns: []string{"cart_"}},
+			want:   ids[:1],
+		},
+		{
+			name:   "escaped underscore matches itself",
+			filter: domain.OrderFilter{UrlPatterns: []string{`cart\_`}},
+			want:   ids[:1],
+		},
+		{
+			name:   "OR within a field",
+			filter: domain.OrderFilter{UrlPatterns: []string{"shop", "blog"}},
+			want:   ids,
+		},
+		{
+			name:   "AND across fields",
+			filter: domain.OrderFilter{Tags: []string{"express"}, OwnerIDs: []string{blog.OwnerID}},
+			want:   ids[1:],
+		},
+		{
+			name:   "no match",
+			filter: domain.OrderFilter{Tags: []string{"gift"}, Statuses: []domain.OrderStatus{domain.OrderStatusShipped}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := repo.SearchOrders(ctx, tt.filter)
+			require.NoError(t, err)
+
+			actual := make([]uuid.UUID, 0, len(orders))
+			for _, order := range orders {
+				actual = append(actual, order.ID)
+			}
+			assert.ElementsMatch(t, tt.want, actual)
+
+			count, err := repo.CountOrders(ctx, tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tt.want)), count)
+		})
+	}
+}
+
+func TestTxManager(t *testing.T) {
+	ctx := t.Context()
+	repo := inmemory.NewOrder()
+
+	txManager, err := inmemory.NewTxManager(repo)
+	require.NoError(t, err)
+
+	existingID, err := repo.InsertOrder(ctx, newOrder("1"))
+	require.NoError(t, err)
+
+	var insertedID uuid.UUID
+	err = txManager.WithinTx(ctx, func(ctx context.Context) error {
+		insertedID, err = repo.InsertOrder(ctx, newOrder("2"))
+		require.NoError(t, err)
+
+		// a failed nested transaction is rolled back to its savepoint only
+		nestedErr := txManager.WithinTx(ctx, func(ctx context.Context) error {
+			require.NoError(t, repo.UpdateOrderStatus(ctx, existingID, domain.OrderStatusShipped, 1))
+			return errors.New("abort the nested transaction")
+		})
+		require.Error(t, nestedErr)
+
+		_, err := repo.GetOrderForUpdate(ctx, existingID, domain.LockNoWait)
+		require.NoError(t, err)
+
+		// the uncommitted order is not visible outside of the transaction
+		_, err = repo.GetOrder(t.Context(), insertedID)
+		require.ErrorIs(t, err, repository.ErrNotFound)
+
+		return repo.UpdateOrderStatus(ctx, existingID, domain.OrderStatusCancelled, 1)
+	})
+	require.NoError(t, err)
+
+	_, err = repo.GetOrder(ctx, insertedID)
+	require.NoError(t, err)
+
+	existing, err := repo.GetOrder(ctx, existingID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.OrderStatusCancelled, existing.Status)
+
+	err = txManager.WithinTx(ctx, func(ctx context.Context) error {
+		require.NoError(t, repo.SoftDeleteOrder(ctx, existingID))
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+
+	_, err = repo.GetOrder(ctx, existingID)
+	require.NoError(t, err)
+
+	_, err = repo.GetOrderForUpdate(ctx, existingID, domain.LockWait)
+	require.ErrorIs(t, err, repository.ErrTxRequired)
+}
+
+func TestOrderRepository_Purge(t *testing.T) {
+	ctx := t.Context()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := inmemory.NewOrder(inmemory.WithClock(func() time.Time { return now }))
+
+	orderID, err := repo.InsertOrder(ctx, newOrder("1", "2"))
+	require.NoError(t, err)
+	require.NoError(t, repo.SoftDeleteOrder(ctx, orderID))
+
+	result, err := repo.Purge(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	assert.True(t, result.IsZero())
+
+	now = now.Add(2 * time.Hour)
+
+	// the items of the order count against the batch size, the order goes once they are gone
+	result, err = repo.Purge(ctx, time.Hour, 1)
+	require.NoError(t, err)
+	assert.Equal(t, domain.PurgeResult{Items: 1}, result)
+
+	result, err = repo.Purge(ctx, time.Hour, 1)
+	require.NoError(t, err)
+	assert.Equal(t, domain.PurgeResult{Orders: 1, Items: 1}, result)
+
+	result, err = repo.Purge(ctx, time.Hour, 1)
+	require.NoError(t, err)
+	assert.True(t, result.IsZero())
+
+	err = repo.RestoreOrder(ctx, orderID)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestOrderRepository_Concurrent(t *testing.T) {
+	ctx := t.Context()
+	repo := inmemory.NewOrder()
+
+	orderID, err := repo.InsertOrder(ctx, newOrder("1"))
+	require.NoError(t, err)
+
+	const writers = 20
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		updated int
+	)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// every writer expects version 1, only one of them wins
+			err := repo.AddTags(ctx, orderID, []string{"tag"}, 1)
+			if err == nil {
+				mu.Lock()
+				updated++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, repository.ErrConcurrentModification)
+
+			_, err = repo.InsertOrder(ctx, newOrder(decimal.NewFromInt(int64(i)).String()))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, updated)
+
+	count, err := repo.CountOrders(ctx, domain.OrderFilter{Statuses: []domain.OrderStatus{domain.OrderStatusPending}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers), count)
+}
+
+func newOrder(itemPrices ...string) domain.Order {
+	order := domain.Order{
+		OwnerID: uuid.NewString(),
+		Price:   domain.Money{Amount: decimal.Zero, Currency: currency.EUR},
+	}
+
+	for _, price := range itemPrices {
+		amount := decimal.RequireFromString(price)
+
+		order.Items = append(order.Items, domain.OrderItem{
+			ProductID: uuid.New(),
+			Price:     domain.Money{Amount: amount, Currency: currency.EUR},
+		})
+		order.Price.Amount = order.Price.Amount.Add(amount)
+	}
+
+	return order
+}
+
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
@@ -0,0 +1,376 @@
+package inmemory
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
+)
+
+func (r *OrderRepository) SearchOrders(ctx context.Context, filter domain.OrderFilter) ([]domain.Order, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("filter.Validate: %w", err)
+	}
+
+	orders, err := r.search(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(orders, func(a, b domain.Order) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+
+	return orders, nil
+}
+
+func (r *OrderRepository) SearchOrdersPage(ctx context.Context, filter domain.OrderFilter, page domain.PageRequest) (domain.OrderPage, error) {
+	var p domain.OrderPage
+
+	if err := filter.Validate(); err != nil {
+		return p, fmt.Errorf("filter.Validate: %w", err)
+	}
+
+	if err := page.Validate(); err != nil {
+		return p, fmt.Errorf("page.Validate: %w", err)
+	}
+
+	var after *orderCursor
+	if page.Cursor != "" {
+		cursor, err := decodeOrderCursor(page.Cursor)
+		if err != nil {
+			return p, fmt.Errorf("decodeOrderCursor: %w", err)
+		}
+
+		if err := cursor.validate(page.SortKey); err != nil {
+			return p, fmt.Errorf("cursor.validate: %w", err)
+		}
+
+		after = &cursor
+	}
+
+	orders, err := r.search(ctx, filter)
+	if err != nil {
+		return p, err
+	}
+
+	compare := func(a, b orderCursor) int {
+		return a.compare(b, page.SortKey)
+	}
+
+	slices.SortFunc(orders, func(a, b domain.Order) int {
+		return compare(newOrderCursor(a, page.SortKey), newOrderCursor(b, page.SortKey))
+	})
+
+	if after != nil {
+		orders = slices.DeleteFunc(orders, func(order domain.Order) bool {
+			return compare(newOrderCursor(order, page.SortKey), *after) <= 0
+		})
+	}
+
+	if len(orders) > page.Size {
+		orders = orders[:page.Size]
+
+		p.NextCursor, err = newOrderCursor(orders[len(orders)-1], page.SortKey).encode()
+		if err != nil {
+			return p, fmt.Errorf("cursor.encode: %w", err)
+		}
+	}
+
+	p.Orders = orders
+
+	return p, nil
+}
+
+func (r *OrderRepository) CountOrders(ctx context.Context, filter domain.OrderFilter) (int64, error) {
+	if err := filter.Validate(); err != nil {
+		return 0, fmt.Errorf("filter.Validate: %w", err)
+	}
+
+	orders, err := r.search(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(orders)), nil
+}
+
+func (r *OrderRepository) GetOrderFacets(ctx context.Context, filter domain.OrderFilter) (domain.OrderFacets, error) {
+	facets := domain.OrderFacets{
+		Statuses:   make(map[domain.OrderStatus]int64),
+		Currencies: make(map[currency.Unit]int64),
+		Tags:       make(map[string]int64),
+		Owners:     make(map[string]int64),
+		PriceSums:  make(map[currency.Unit]decimal.Decimal),
+	}
+
+	if err := filter.Validate(); err != nil {
+		return domain.OrderFacets{}, fmt.Errorf("filter.Validate: %w", err)
+	}
+
+	orders, err := r.search(ctx, filter)
+	if err != nil {
+		return domain.OrderFacets{}, err
+	}
+
+	for _, order := range orders {
+		facets.Statuses[order.Status]++
+		facets.Currencies[order.Price.Currency]++
+		facets.PriceSums[order.Price.Currency] = facets.PriceSums[order.Price.Currency].Add(order.Price.Amount)
+		facets.Owners[order.OwnerID]++
+
+		// an order may carry the same tag twice, count it once
+		for _, tag := range slices.Compact(slices.Sorted(slices.Values(order.Tags))) {
+			facets.Tags[tag]++
+		}
+	}
+
+	return facets, nil
+}
+
+// search returns the orders matching filter which have live items, like the JOIN of the PostgreSQL repository.
+// The items are sorted by product ID and have no CreatedAt.
+func (r *OrderRepository) search(ctx context.Context, filter domain.OrderFilter) ([]domain.Order, error) {
+	urlPatterns := make([]*regexp.Regexp, 0, len(filter.UrlPatterns))
+	for _, pattern := range filter.UrlPatterns {
+		re, err := ilikeRegexp("%" + pattern + "%")
+		if err != nil {
+			return nil, fmt.Errorf("ilikeRegexp[%s]: %w", pattern, err)
+		}
+		urlPatterns = append(urlPatterns, re)
+	}
+
+	var orders []domain.Order
+
+	for _, rec := range r.read(ctx).orders {
+		if !matchesFilter(rec.order, filter, urlPatterns) {
+			continue
+		}
+
+		order := rec.view()
+		if len(order.Items) == 0 {
+			continue
+		}
+
+		for i := range order.Items {
+			order.Items[i].CreatedAt = time.Time{}
+		}
+		slices.SortFunc(order.Items, func(a, b domain.OrderItem) int {
+			return bytes.Compare(a.ProductID[:], b.ProductID[:])
+		})
+
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// matchesFilter has AND semantics across the filter fields and OR semantics within each of them.
+func matchesFilter(order domain.Order, filter domain.OrderFilter, urlPatterns []*regexp.Regexp) bool {
+	if len(filter.IDs) > 0 && !slices.Contains(filter.IDs, order.ID) {
+		return false
+	}
+
+	if len(filter.OwnerIDs) > 0 && !slices.Contains(filter.OwnerIDs, order.OwnerID) {
+		return false
+	}
+
+	if len(urlPatterns) > 0 && !slices.ContainsFunc(urlPatterns, func(re *regexp.Regexp) bool {
+		return re.MatchString(domain.URLString(order.Url))
+	}) {
+		return false
+	}
+
+	if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, order.Status) {
+		return false
+	}
+
+	if len(filter.Tags) > 0 && !slices.ContainsFunc(filter.Tags, func(tag string) bool {
+		return slices.Contains(order.Tags, tag)
+	}) {
+		return false
+	}
+
+	if !inTimeRange(&order.CreatedAt, filter.CreatedAt) || !inTimeRange(&order.UpdatedAt, filter.UpdatedAt) {
+		return false
+	}
+
+	switch filter.Deleted {
+	case domain.IncludeDeleted:
+	case domain.OnlyDeleted:
+		if order.DeletedAt == nil {
+			return false
+		}
+	default:
+		if order.DeletedAt != nil {
+			return false
+		}
+	}
+
+	return inTimeRange(order.DeletedAt, filter.DeletedAt)
+}
+
+// inTimeRange is true for a nil range, After is inclusive and Before is exclusive,
+// a nil t is out of any range like NULL in SQL.
+func inTimeRange(t *time.Time, r *domain.TimeRange) bool {
+	if r == nil {
+		return true
+	}
+
+	if t == nil {
+		return false
+	}
+
+	if r.After != nil && t.Before(*r.After) {
+		return false
+	}
+
+	if r.Before != nil && !t.Before(*r.Before) {
+		return false
+	}
+
+	return true
+}
+
+// ilikeRegexp translates an ILIKE pattern, % matches any string, _ matches any character
+// and a backslash escapes the next character.
+func ilikeRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString(`(?is)^`)
+
+	escaped := false
+	for _, ch := range pattern {
+		switch {
+		case escaped:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == '%':
+			sb.WriteString(`.*`)
+		case ch == '_':
+			sb.WriteString(`.`)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("LIKE pattern must not end with escape character: %w", repository.ErrInvalidInput)
+	}
+
+	sb.WriteString(`$`)
+
+	return regexp.Compile(sb.String())
+}
+
+// orderCursor is the keyset position of the last order of a page, it is handed out to callers as an opaque string.
+type orderCursor struct {
+	SortKey domain.OrderSortKey `json:"k"`
+	Value   string              `json:"v,omitempty"`
+	ID      uuid.UUID           `json:"id"`
+}
+
+func newOrderCursor(order domain.Order, sortKey domain.OrderSortKey) orderCursor {
+	c := orderCursor{
+		SortKey: sortKey,
+		ID:      order.ID,
+	}
+
+	switch sortKey {
+	case domain.OrderSortByCreatedAt:
+		c.Value = order.CreatedAt.Format(time.RFC3339Nano)
+	case domain.OrderSortByUpdatedAt:
+		c.Value = order.UpdatedAt.Format(time.RFC3339Nano)
+	case domain.OrderSortByPriceAmount:
+		c.Value = order.Price.Amount.String()
+	}
+
+	return c
+}
+
+// validate checks that the cursor was issued for the same sort key and that its value parses.
+func (c orderCursor) validate(sortKey domain.OrderSortKey) error {
+	if c.SortKey != sortKey {
+		return fmt.Errorf("cursor sort key[%s] does not match sort key[%s]", c.SortKey, sortKey)
+	}
+
+	switch sortKey {
+	case domain.OrderSortByCreatedAt, domain.OrderSortByUpdatedAt:
+		if _, err := time.Parse(time.RFC3339Nano, c.Value); err != nil {
+			return fmt.Errorf("time.Parse[%s]: %w", c.Value, err)
+		}
+	case domain.OrderSortByPriceAmount:
+		if _, err := decimal.NewFromString(c.Value); err != nil {
+			return fmt.Errorf("decimal.NewFromString[%s]: %w", c.Value, err)
+		}
+	}
+
+	return nil
+}
+
+// compare orders cursors by the sort key value and then by ID, values which do not parse compare as equal.
+func (c orderCursor) compare(other orderCursor, sortKey domain.OrderSortKey) int {
+	switch sortKey {
+	case domain.OrderSortByCreatedAt, domain.OrderSortByUpdatedAt:
+		t1, err1 := time.Parse(time.RFC3339Nano, c.Value)
+		t2, err2 := time.Parse(time.RFC3339Nano, other.Value)
+		if err1 == nil && err2 == nil {
+			if cmp := t1.Compare(t2); cmp != 0 {
+				return cmp
+			}
+		}
+	case domain.OrderSortByPriceAmount:
+		d1, err1 := decimal.NewFromString(c.Value)
+		d2, err2 := decimal.NewFromString(other.Value)
+		if err1 == nil && err2 == nil {
+			if cmp := d1.Cmp(d2); cmp != 0 {
+				return cmp
+			}
+		}
+	}
+
+	return bytes.Compare(c.ID[:], other.ID[:])
+}
+
+func (c orderCursor) encode() (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeOrderCursor(s string) (orderCursor, error) {
+	var c orderCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("cursor is malformed: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("cursor is malformed: %w", err)
+	}
+
+	if c.ID == uuid.Nil {
+		return c, fmt.Errorf("cursor has no order ID")
+	}
+
+	return c, nil
+}
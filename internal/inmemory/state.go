@@ -0,0 +1,194 @@
+package inmemory
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+// state is a snapshot of the orders, it is never changed once published,
+// a write works on a clone and publishes it if it succeeds.
+type state struct {
+	orders        map[uuid.UUID]*orderRecord
+	history       []domain.OrderHistoryEntry
+	lastHistoryID int64
+}
+
+// orderRecord is an orders row with its order_items rows.
+type orderRecord struct {
+	// order.Items holds the soft-deleted items too, order.IdempotencyKey is kept
+	order       domain.Order
+	requestHash string
+}
+
+func newState() *state {
+	return &state{
+		orders: make(map[uuid.UUID]*orderRecord),
+	}
+}
+
+// clone copies the maps and slices of s, the records are shared until mutable is called for them.
+func (s *state) clone() *state {
+	return &state{
+		orders:        maps.Clone(s.orders),
+		history:       slices.Clone(s.history),
+		lastHistoryID: s.lastHistoryID,
+	}
+}
+
+// mutable returns a copy of the record which replaces the shared one, nil if the order does not exist.
+func (s *state) mutable(orderID uuid.UUID) *orderRecord {
+	rec, ok := s.orders[orderID]
+	if !ok {
+		return nil
+	}
+
+	rec = rec.clone()
+	s.orders[orderID] = rec
+
+	return rec
+}
+
+// liveOrder is like mutable, but returns nil for a soft-deleted order too.
+func (s *state) liveOrder(orderID uuid.UUID) *orderRecord {
+	if rec, ok := s.orders[orderID]; !ok || rec.order.DeletedAt != nil {
+		return nil
+	}
+
+	return s.mutable(orderID)
+}
+
+func (s *state) findByIdempotencyKey(ownerID, key string) *orderRecord {
+	for _, rec := range s.orders {
+		if rec.order.OwnerID == ownerID && rec.order.IdempotencyKey == key {
+			return rec
+		}
+	}
+
+	return nil
+}
+
+func (s *state) recordChange(now time.Time, audit domain.AuditInfo, orderID uuid.UUID, productID *uuid.UUID, changeType domain.OrderChangeType, oldValue, newValue string) {
+	s.lastHistoryID++
+
+	s.history = append(s.history, domain.OrderHistoryEntry{
+		ID:        s.lastHistoryID,
+		OrderID:   orderID,
+		ProductID: cloneUUIDPtr(productID),
+		Type:      changeType,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		Actor:     audit.Actor,
+		Reason:    audit.Reason,
+		CreatedAt: now,
+	})
+}
+
+func (r *orderRecord) clone() *orderRecord {
+	return &orderRecord{
+		order:       cloneOrder(r.order),
+		requestHash: r.requestHash,
+	}
+}
+
+// liveItems returns copies of the items which are not soft-deleted, nil if there are none.
+func (r *orderRecord) liveItems() []domain.OrderItem {
+	var items []domain.OrderItem
+
+	for _, item := range r.order.Items {
+		if item.DeletedAt == nil {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func (r *orderRecord) item(productID uuid.UUID) *domain.OrderItem {
+	for i := range r.order.Items {
+		if r.order.Items[i].ProductID == productID {
+			return &r.order.Items[i]
+		}
+	}
+
+	return nil
+}
+
+// recomputePrice is UpdateOrderPrice: the price is the sum of the live items, the version and updated_at are bumped.
+func (r *orderRecord) recomputePrice(now time.Time) {
+	amount := decimal.Zero
+	for _, item := range r.liveItems() {
+		amount = amount.Add(item.Price.Amount)
+	}
+
+	r.order.Price.Amount = amount
+	r.touch(now)
+}
+
+func (r *orderRecord) touch(now time.Time) {
+	r.order.UpdatedAt = now
+	r.order.Version++
+}
+
+// view returns the order as the repository reads it, with the live items only.
+func (r *orderRecord) view() domain.Order {
+	order := cloneOrder(r.order)
+	order.Items = r.liveItems()
+	order.IdempotencyKey = ""
+
+	return order
+}
+
+func cloneOrder(order domain.Order) domain.Order {
+	order.Items = slices.Clone(order.Items)
+	for i := range order.Items {
+		order.Items[i].DeletedAt = cloneTimePtr(order.Items[i].DeletedAt)
+	}
+
+	order.Url = cloneURL(order.Url)
+	order.Tags = slices.Clone(order.Tags)
+	order.Payload = slices.Clone(order.Payload)
+	order.PayloadB = slices.Clone(order.PayloadB)
+	order.DeletedAt = cloneTimePtr(order.DeletedAt)
+
+	return order
+}
+
+// cloneURL round-trips the URL through its string like the repository does,
+// which stores an empty URL as an empty string and reads it back as nil.
+func cloneURL(u *url.URL) *url.URL {
+	if u == nil || u.String() == "" {
+		return nil
+	}
+
+	parsed, err := url.Parse(u.String())
+	if err != nil {
+		c := *u
+		return &c
+	}
+
+	return parsed
+}
+
+func cloneTimePtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	c := *t
+	return &c
+}
+
+func cloneUUIDPtr(id *uuid.UUID) *uuid.UUID {
+	if id == nil {
+		return nil
+	}
+
+	c := *id
+	return &c
+}
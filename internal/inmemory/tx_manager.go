@@ -0,0 +1,101 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nikolayk812/sqlcpp/internal/port"
+)
+
+type txManager struct {
+	repo *OrderRepository
+}
+
+// NewTxManager creates a TxManager for the transactions of repo, they run one at a time,
+// so the writes made outside of a transaction wait until it ends.
+func NewTxManager(repo *OrderRepository) (port.TxManager, error) {
+	if repo == nil {
+		return nil, fmt.Errorf("repo is nil")
+	}
+
+	return &txManager{repo: repo}, nil
+}
+
+// WithinTx works on a private copy of the orders and publishes it if fn returns nil,
+// a nested WithinTx works on a copy of the outer one like a savepoint.
+func (m *txManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if fn == nil {
+		return fmt.Errorf("fn is nil")
+	}
+
+	r := m.repo
+
+	if parent, ok := r.txFromContext(ctx); ok {
+		child := &tx{
+			repo: r,
+			st:   parent.snapshot(),
+			now:  parent.now,
+		}
+
+		if err := fn(contextWithTx(ctx, child)); err != nil {
+			return err
+		}
+
+		parent.mu.Lock()
+		parent.st = child.snapshot()
+		parent.mu.Unlock()
+
+		return nil
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	t := &tx{
+		repo: r,
+		st:   r.snapshot(),
+		now:  r.now(),
+	}
+
+	if err := fn(contextWithTx(ctx, t)); err != nil {
+		return err
+	}
+
+	r.publish(t.snapshot())
+
+	return nil
+}
+
+type tx struct {
+	repo *OrderRepository
+
+	// mu guards st, the calls made with the context of the transaction may run concurrently
+	mu  sync.Mutex
+	st  *state
+	now time.Time
+}
+
+func (t *tx) snapshot() *state {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.st
+}
+
+type txKey struct{}
+
+func contextWithTx(ctx context.Context, t *tx) context.Context {
+	return context.WithValue(ctx, txKey{}, t)
+}
+
+// txFromContext ignores the transactions of other repositories.
+func (r *OrderRepository) txFromContext(ctx context.Context) (*tx, bool) {
+	t, ok := ctx.Value(txKey{}).(*tx)
+	if !ok || t.repo != r {
+		return nil, false
+	}
+
+	return t, true
+}
@@ -0,0 +1,123 @@
+// Package metrics exposes Prometheus metrics of the order repository and of its connection pools.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "sqlcpp"
+
+// pgCodeQueryCanceled is raised by statement_timeout as well as by a cancel request
+const pgCodeQueryCanceled = "57014"
+
+// Metrics holds the collectors of the repository calls and of the transactions,
+// pass it to repository.WithTxObserver to count the retries and rollbacks.
+type Metrics struct {
+	callDuration *prometheus.HistogramVec
+	callErrors   *prometheus.CounterVec
+	searchRows   prometheus.Histogram
+	txRetries    prometheus.Counter
+	txRollbacks  prometheus.Counter
+}
+
+var _ repository.TxObserver = (*Metrics)(nil)
+
+// NewMetrics creates the collectors and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	if reg == nil {
+		return nil, fmt.Errorf("reg is nil")
+	}
+
+	m := &Metrics{
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "order_repository",
+			Name:      "call_duration_seconds",
+			Help:      "Duration of the order repository calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		callErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "order_repository",
+			Name:      "errors_total",
+			Help:      "Failed order repository calls by error class.",
+		}, []string{"method", "class"}),
+		searchRows: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "order_repository",
+			Name:      "search_rows",
+			Help:      "Orders returned by SearchOrders.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		txRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "order_repository",
+			Name:      "tx_retries_total",
+			Help:      "Transactions re-run after a serialization failure or a deadlock.",
+		}),
+		txRollbacks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "order_repository",
+			Name:      "tx_rollbacks_total",
+			Help:      "Rolled back transactions and savepoints.",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{m.callDuration, m.callErrors, m.searchRows, m.txRetries, m.txRollbacks} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("reg.Register: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Metrics) TxRetried(error) {
+	m.txRetries.Inc()
+}
+
+func (m *Metrics) TxRolledBack() {
+	m.txRollbacks.Inc()
+}
+
+// Handler serves the metrics gathered by g, it is up to the caller whether and where to mount it.
+func Handler(g prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+}
+
+// errorClass is the class label of the errors_total counter, a timeout takes precedence over the other classes.
+func errorClass(err error) string {
+	var pgErr *pgconn.PgError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), pgconn.Timeout(err),
+		errors.As(err, &pgErr) && pgErr.Code == pgCodeQueryCanceled:
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, repository.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, repository.ErrConstraintViolation):
+		return "constraint"
+	case errors.Is(err, repository.ErrConcurrentModification):
+		return "concurrent_modification"
+	case errors.Is(err, repository.ErrIdempotencyConflict):
+		return "idempotency_conflict"
+	case errors.Is(err, repository.ErrSerialization):
+		return "serialization"
+	case errors.Is(err, repository.ErrLocked):
+		return "locked"
+	case errors.Is(err, repository.ErrInvalidInput):
+		return "invalid_input"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+)
+
+type orderRepository struct {
+	next    port.OrderRepository
+	metrics *Metrics
+}
+
+// NewOrderRepository wraps next so that the latency and the errors of its methods are recorded in m.
+func NewOrderRepository(next port.OrderRepository, m *Metrics) (port.OrderRepository, error) {
+	if next == nil {
+		return nil, fmt.Errorf("next is nil")
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("m is nil")
+	}
+
+	return &orderRepository{
+		next:    next,
+		metrics: m,
+	}, nil
+}
+
+func (r *orderRepository) observe(method string, start time.Time, err error) {
+	r.metrics.callDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		r.metrics.callErrors.WithLabelValues(method, errorClass(err)).Inc()
+	}
+}
+
+func (r *orderRepository) GetOrder(ctx context.Context, orderID uuid.UUID) (_ domain.Order, err error) {
+	defer func(start time.Time) { r.observe("GetOrder", start, err) }(time.Now())
+
+	return r.next.GetOrder(ctx, orderID)
+}
+
+func (r *orderRepository) GetOrderSeparateQueries(ctx context.Context, orderID uuid.UUID) (_ domain.Order, err error) {
+	defer func(start time.Time) { r.observe("GetOrderSeparateQueries", start, err) }(time.Now())
+
+	return r.next.GetOrderSeparateQueries(ctx, orderID)
+}
+
+func (r *orderRepository) GetOrderForUpdate(ctx context.Context, orderID uuid.UUID, mode domain.LockMode) (_ domain.Order, err error) {
+	defer func(start time.Time) { r.observe("GetOrderForUpdate", start, err) }(time.Now())
+
+	return r.next.GetOrderForUpdate(ctx, orderID, mode)
+}
+
+func (r *orderRepository) LockOrder(ctx context.Context, orderID uuid.UUID) (err error) {
+	defer func(start time.Time) { r.observe("LockOrder", start, err) }(time.Now())
+
+	return r.next.LockOrder(ctx, orderID)
+}
+
+func (r *orderRepository) SearchOrders(ctx context.Context, filter domain.OrderFilter) (_ []domain.Order, err error) {
+	defer func(start time.Time) { r.observe("SearchOrders", start, err) }(time.Now())
+
+	orders, err := r.next.SearchOrders(ctx, filter)
+	if err == nil {
+		r.metrics.searchRows.Observe(float64(len(orders)))
+	}
+
+	return orders, err
+}
+
+func (r *orderRepository) SearchOrdersPage(ctx context.Context, filter domain.OrderFilter, page domain.PageRequest) (_ domain.OrderPage, err error) {
+	defer func(start time.Time) { r.observe("SearchOrdersPage", start, err) }(time.Now())
+
+	return r.next.SearchOrdersPage(ctx, filter, page)
+}
+
+func (r *orderRepository) CountOrders(ctx context.Context, filter domain.OrderFilter) (_ int64, err error) {
+	defer func(start time.Time) { r.observe("CountOrders", start, err) }(time.Now())
+
+	return r.next.CountOrders(ctx, filter)
+}
+
+func (r *orderRepository) GetOrderFacets(ctx context.Context, filter domain.OrderFilter) (_ domain.OrderFacets, err error) {
+	defer func(start time.Time) { r.observe("GetOrderFacets", start, err) }(time.Now())
+
+	return r.next.GetOrderFacets(ctx, filter)
+}
+
+func (r *orderRepository) InsertOrder(ctx context.Context, order domain.Order) (_ uuid.UUID, err error) {
+	defer func(start time.Time) { r.observe("InsertOrder", start, err) }(time.Now())
+
+	return r.next.InsertOrder(ctx, order)
+}
+
+func (r *orderRepository) InsertOrders(ctx context.Context, orders []domain.Order) (_ []domain.InsertOrderResult, err error) {
+	defer func(start time.Time) { r.observe("InsertOrders", start, err) }(time.Now())
+
+	return r.next.InsertOrders(ctx, orders)
+}
+
+func (r *orderRepository) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status domain.OrderStatus, expectedVersion int64) (err error) {
+	defer func(start time.Time) { r.observe("UpdateOrderStatus", start, err) }(time.Now())
+
+	return r.next.UpdateOrderStatus(ctx, orderID, status, expectedVersion)
+}
+
+func (r *orderRepository) UpdateOrder(ctx context.Context, order domain.Order) (err error) {
+	defer func(start time.Time) { r.observe("UpdateOrder", start, err) }(time.Now())
+
+	return r.next.UpdateOrder(ctx, order)
+}
+
+func (r *orderRepository) AddOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) (err error) {
+	defer func(start time.Time) { r.observe("AddOrderItems", start, err) }(time.Now())
+
+	return r.next.AddOrderItems(ctx, orderID, items, expectedVersion)
+}
+
+func (r *orderRepository) ReplaceOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) (err error) {
+	defer func(start time.Time) { r.observe("ReplaceOrderItems", start, err) }(time.Now())
+
+	return r.next.ReplaceOrderItems(ctx, orderID, items, expectedVersion)
+}
+
+func (r *orderRepository) SetTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) (err error) {
+	defer func(start time.Time) { r.observe("SetTags", start, err) }(time.Now())
+
+	return r.next.SetTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *orderRepository) AddTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) (err error) {
+	defer func(start time.Time) { r.observe("AddTags", start, err) }(time.Now())
+
+	return r.next.AddTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *orderRepository) RemoveTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) (err error) {
+	defer func(start time.Time) { r.observe("RemoveTags", start, err) }(time.Now())
+
+	return r.next.RemoveTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *orderRepository) SetURL(ctx context.Context, orderID uuid.UUID, u *url.URL, expectedVersion int64) (err error) {
+	defer func(start time.Time) { r.observe("SetURL", start, err) }(time.Now())
+
+	return r.next.SetURL(ctx, orderID, u, expectedVersion)
+}
+
+func (r *orderRepository) SetPayload(ctx context.Context, orderID uuid.UUID, payload, payloadB []byte, expectedVersion int64) (err error) {
+	defer func(start time.Time) { r.observe("SetPayload", start, err) }(time.Now())
+
+	return r.next.SetPayload(ctx, orderID, payload, payloadB, expectedVersion)
+}
+
+func (r *orderRepository) SoftDeleteOrder(ctx context.Context, orderID uuid.UUID) (err error) {
+	defer func(start time.Time) { r.observe("SoftDeleteOrder", start, err) }(time.Now())
+
+	return r.next.SoftDeleteOrder(ctx, orderID)
+}
+
+func (r *orderRepository) SoftDeleteOrderItem(ctx context.Context, orderID, productID uuid.UUID) (err error) {
+	defer func(start time.Time) { r.observe("SoftDeleteOrderItem", start, err) }(time.Now())
+
+	return r.next.SoftDeleteOrderItem(ctx, orderID, productID)
+}
+
+func (r *orderRepository) RestoreOrder(ctx context.Context, orderID uuid.UUID) (err error) {
+	defer func(start time.Time) { r.observe("RestoreOrder", start, err) }(time.Now())
+
+	return r.next.RestoreOrder(ctx, orderID)
+}
+
+func (r *orderRepository) RestoreOrderItem(ctx context.Context, orderID, productID uuid.UUID) (err error) {
+	defer func(start time.Time) { r.observe("RestoreOrderItem", start, err) }(time.Now())
+
+	return r.next.RestoreOrderItem(ctx, orderID, productID)
+}
+
+func (r *orderRepository) DeleteOrder(ctx context.Context, orderID uuid.UUID) (err error) {
+	defer func(start time.Time) { r.observe("DeleteOrder", start, err) }(time.Now())
+
+	return r.next.DeleteOrder(ctx, orderID)
+}
+
+func (r *orderRepository) Purge(ctx context.Context, olderThan time.Duration, batchSize int) (_ domain.PurgeResult, err error) {
+	defer func(start time.Time) { r.observe("Purge", start, err) }(time.Now())
+
+	return r.next.Purge(ctx, olderThan, batchSize)
+}
+
+func (r *orderRepository) GetOrderHistory(ctx context.Context, orderID uuid.UUID) (_ []domain.OrderHistoryEntry, err error) {
+	defer func(start time.Time) { r.observe("GetOrderHistory", start, err) }(time.Now())
+
+	return r.next.GetOrderHistory(ctx, orderID)
+}
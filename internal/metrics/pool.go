@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type poolCollector struct {
+	pools map[string]*pgxpool.Pool
+
+	acquiredConns       *prometheus.Desc
+	idleConns           *prometheus.Desc
+	constructingConns   *prometheus.Desc
+	totalConns          *prometheus.Desc
+	maxConns            *prometheus.Desc
+	acquires            *prometheus.Desc
+	acquireDuration     *prometheus.Desc
+	canceledAcquires    *prometheus.Desc
+	emptyAcquires       *prometheus.Desc
+	newConns            *prometheus.Desc
+	maxLifetimeDestroys *prometheus.Desc
+	maxIdleDestroys     *prometheus.Desc
+}
+
+// NewPoolCollector collects pgxpool.Stat of the pools on every scrape, labeled by the keys of pools, i.e. primary and replica.
+func NewPoolCollector(pools map[string]*pgxpool.Pool) (prometheus.Collector, error) {
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("pools is empty")
+	}
+
+	for name, pool := range pools {
+		if pool == nil {
+			return nil, fmt.Errorf("pool[%s] is nil", name)
+		}
+	}
+
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", name), help, []string{"pool"}, nil)
+	}
+
+	return &poolCollector{
+		pools: pools,
+
+		acquiredConns:       desc("acquired_conns", "Connections currently acquired from the pool."),
+		idleConns:           desc("idle_conns", "Idle connections in the pool."),
+		constructingConns:   desc("constructing_conns", "Connections being established."),
+		totalConns:          desc("total_conns", "Connections in the pool, acquired, idle and constructing ones."),
+		maxConns:            desc("max_conns", "Maximum size of the pool."),
+		acquires:            desc("acquires_total", "Successful acquires from the pool."),
+		acquireDuration:     desc("acquire_duration_seconds_total", "Total time spent in successful acquires."),
+		canceledAcquires:    desc("canceled_acquires_total", "Acquires canceled by their context."),
+		emptyAcquires:       desc("empty_acquires_total", "Successful acquires which waited for a connection because the pool was empty."),
+		newConns:            desc("new_conns_total", "Connections established by the pool."),
+		maxLifetimeDestroys: desc("max_lifetime_destroys_total", "Connections closed because of MaxConnLifetime."),
+		maxIdleDestroys:     desc("max_idle_destroys_total", "Connections closed because of MaxConnIdleTime."),
+	}, nil
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.constructingConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquires
+	ch <- c.acquireDuration
+	ch <- c.canceledAcquires
+	ch <- c.emptyAcquires
+	ch <- c.newConns
+	ch <- c.maxLifetimeDestroys
+	ch <- c.maxIdleDestroys
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, pool := range c.pools {
+		stat := pool.Stat()
+
+		gauge := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, name)
+		}
+		counter := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, name)
+		}
+
+		gauge(c.acquiredConns, float64(stat.AcquiredConns()))
+		gauge(c.idleConns, float64(stat.IdleConns()))
+		gauge(c.constructingConns, float64(stat.ConstructingConns()))
+		gauge(c.totalConns, float64(stat.TotalConns()))
+		gauge(c.maxConns, float64(stat.MaxConns()))
+		counter(c.acquires, float64(stat.AcquireCount()))
+		counter(c.acquireDuration, stat.AcquireDuration().Seconds())
+		counter(c.canceledAcquires, float64(stat.CanceledAcquireCount()))
+		counter(c.emptyAcquires, float64(stat.EmptyAcquireCount()))
+		counter(c.newConns, float64(stat.NewConnsCount()))
+		counter(c.maxLifetimeDestroys, float64(stat.MaxLifetimeDestroyCount()))
+		counter(c.maxIdleDestroys, float64(stat.MaxIdleDestroyCount()))
+	}
+}
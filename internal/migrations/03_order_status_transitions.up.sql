@@ -0,0 +1,11 @@
+-- the domain spells the status 'cancelled', the original constraint rejected it
+ALTER TABLE orders
+    DROP CONSTRAINT IF EXISTS order_status_check;
+
+UPDATE orders
+SET status = 'cancelled'
+WHERE status = 'canceled';
+
+ALTER TABLE orders
+    ADD CONSTRAINT order_status_check
+        CHECK (status IN ('pending', 'shipped', 'delivered', 'cancelled'));
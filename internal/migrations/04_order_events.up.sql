@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS order_events
+(
+    id         BIGSERIAL                           NOT NULL,
+    order_id   UUID                                NOT NULL,
+    product_id UUID      DEFAULT NULL,
+    event_type TEXT                                NOT NULL,
+    old_value  TEXT                                NOT NULL,
+    new_value  TEXT                                NOT NULL,
+    actor      TEXT      DEFAULT ''                NOT NULL,
+    reason     TEXT      DEFAULT ''                NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+
+-- no foreign key to orders, the history outlives hard-deleted orders
+CREATE INDEX idx_order_events_order_id
+    ON order_events (order_id, created_at);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS order_outbox
+(
+    id           BIGSERIAL                           NOT NULL,
+    event_type   TEXT                                NOT NULL,
+    order_id     UUID                                NOT NULL,
+    payload      JSONB                               NOT NULL,
+    created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    published_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_order_outbox_published_at_null
+    ON order_outbox (id)
+    WHERE published_at IS NULL;
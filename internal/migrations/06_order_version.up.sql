@@ -0,0 +1,3 @@
+-- bumped on every mutation of an order, used for optimistic concurrency control
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
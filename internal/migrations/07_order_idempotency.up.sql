@@ -0,0 +1,9 @@
+-- a client may retry InsertOrder with the same idempotency key, request_hash tells a replay from a different order
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS idempotency_key TEXT,
+    ADD COLUMN IF NOT EXISTS request_hash    TEXT;
+
+-- NULL keys are distinct, so orders inserted without a key never conflict
+ALTER TABLE orders
+    ADD CONSTRAINT orders_owner_id_idempotency_key_key
+        UNIQUE (owner_id, idempotency_key);
@@ -2,6 +2,8 @@ package port
 
 import (
 	"context"
+	"net/url"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nikolayk812/sqlcpp/internal/domain"
@@ -11,14 +13,42 @@ type OrderRepository interface {
 	GetOrder(ctx context.Context, orderID uuid.UUID) (domain.Order, error)
 	GetOrderSeparateQueries(ctx context.Context, orderID uuid.UUID) (domain.Order, error)
 
+	// GetOrderForUpdate and LockOrder lock until the end of the transaction of the caller, they fail without one
+	GetOrderForUpdate(ctx context.Context, orderID uuid.UUID, mode domain.LockMode) (domain.Order, error)
+	LockOrder(ctx context.Context, orderID uuid.UUID) error
+
 	SearchOrders(ctx context.Context, filter domain.OrderFilter) ([]domain.Order, error)
+	SearchOrdersPage(ctx context.Context, filter domain.OrderFilter, page domain.PageRequest) (domain.OrderPage, error)
+	CountOrders(ctx context.Context, filter domain.OrderFilter) (int64, error)
+	GetOrderFacets(ctx context.Context, filter domain.OrderFilter) (domain.OrderFacets, error)
 
 	InsertOrder(ctx context.Context, order domain.Order) (uuid.UUID, error)
-
-	UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status domain.OrderStatus) error
+	// InsertOrders returns a result per order in the input order, invalid orders do not abort the import
+	InsertOrders(ctx context.Context, orders []domain.Order) ([]domain.InsertOrderResult, error)
+
+	// UpdateOrderStatus fails with ErrConcurrentModification if the order is no longer at expectedVersion
+	UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status domain.OrderStatus, expectedVersion int64) error
+
+	// UpdateOrder and the methods below fail with ErrConcurrentModification if the order is no longer at expectedVersion,
+	// each of them recomputes the order price and bumps the version
+	UpdateOrder(ctx context.Context, order domain.Order) error
+	AddOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) error
+	ReplaceOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) error
+	SetTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error
+	AddTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error
+	RemoveTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error
+	SetURL(ctx context.Context, orderID uuid.UUID, u *url.URL, expectedVersion int64) error
+	SetPayload(ctx context.Context, orderID uuid.UUID, payload, payloadB []byte, expectedVersion int64) error
 
 	SoftDeleteOrder(ctx context.Context, orderID uuid.UUID) error
 	SoftDeleteOrderItem(ctx context.Context, orderID, productID uuid.UUID) error
+	RestoreOrder(ctx context.Context, orderID uuid.UUID) error
+	RestoreOrderItem(ctx context.Context, orderID, productID uuid.UUID) error
 
 	DeleteOrder(ctx context.Context, orderID uuid.UUID) error
+
+	// Purge hard-deletes a batch of orders and items soft-deleted more than olderThan ago
+	Purge(ctx context.Context, olderThan time.Duration, batchSize int) (domain.PurgeResult, error)
+
+	GetOrderHistory(ctx context.Context, orderID uuid.UUID) ([]domain.OrderHistoryEntry, error)
 }
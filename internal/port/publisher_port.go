@@ -0,0 +1,13 @@
+package port
+
+import (
+	"context"
+
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+)
+
+// Publisher delivers order events to downstream services,
+// events of a failed call are delivered again, so consumers have to be idempotent.
+type Publisher interface {
+	Publish(ctx context.Context, events []domain.OrderEvent) error
+}
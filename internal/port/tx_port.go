@@ -0,0 +1,11 @@
+package port
+
+import "context"
+
+type TxManager interface {
+	// WithinTx runs fn in a transaction which the repositories join when they are called with the ctx passed to fn.
+	// The transaction is committed if fn returns nil and rolled back otherwise,
+	// fn may be run again after a serialization failure.
+	// A nested WithinTx runs in a savepoint, so its failure only rolls back what fn did.
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -0,0 +1,60 @@
+package publisher
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+)
+
+// InMemory is a Publisher which keeps the published events in memory, it is meant for tests.
+type InMemory struct {
+	mu     sync.Mutex
+	events []domain.OrderEvent
+	err    error
+}
+
+var _ port.Publisher = (*InMemory)(nil)
+
+func NewInMemory() *InMemory {
+	return &InMemory{}
+}
+
+func (p *InMemory) Publish(_ context.Context, events []domain.OrderEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	p.events = append(p.events, events...)
+
+	return nil
+}
+
+// Events returns a copy of the events published so far, in publishing order.
+func (p *InMemory) Events() []domain.OrderEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.events)
+}
+
+// FailWith makes the following Publish calls fail with err, nil makes them succeed again.
+func (p *InMemory) FailWith(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
+
+// Reset forgets the published events.
+func (p *InMemory) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = nil
+}
@@ -0,0 +1,73 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nikolayk812/sqlcpp/internal/cache"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestCache() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	lru, err := cache.NewLRU(10, time.Minute)
+	require.NoError(t, err)
+
+	repo, err := cache.NewOrderRepository(suite.repo, lru)
+	require.NoError(t, err)
+
+	txManager, err := repository.NewTxManager(suite.pool)
+	require.NoError(t, err)
+
+	orderID, err := repo.InsertOrder(ctx, randomOrder())
+	require.NoError(t, err)
+
+	// the reads of a transaction bypass the cache, its writes invalidate once it has committed
+	err = txManager.WithinTx(ctx, func(txCtx context.Context) error {
+		order, err := repo.GetOrder(txCtx, orderID)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.SetTags(txCtx, orderID, []string{"committed"}, order.Version); err != nil {
+			return err
+		}
+
+		// a read outside of the transaction caches the previous version
+		if _, err := repo.GetOrder(ctx, orderID); err != nil {
+			return err
+		}
+		assert.Equal(t, cache.Stats{Misses: 1}, repo.Stats())
+
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, cache.Stats{Misses: 1, Invalidations: 1}, repo.Stats())
+
+	order, err := repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"committed"}, order.Tags)
+
+	cached, err := repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	assertOrder(t, order, cached)
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 2, Invalidations: 1}, repo.Stats())
+
+	// a rolled back write does not invalidate
+	err = txManager.WithinTx(ctx, func(txCtx context.Context) error {
+		if err := repo.SetTags(txCtx, orderID, []string{"rolled back"}, order.Version); err != nil {
+			return err
+		}
+
+		return errors.New("roll back")
+	})
+	require.Error(t, err)
+	assert.Equal(t, int64(1), repo.Stats().Invalidations)
+}
@@ -0,0 +1,113 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp/internal/dbtxmw"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestDBTXMiddleware() {
+	defer suite.deleteAll()
+
+	suite.Run("wrapped pool: transactions and savepoints go through the chain", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		rec := &dbtxmw.Recorder{}
+		repo, err := repository.NewOrder(dbtxmw.Wrap(suite.pool, dbtxmw.Tag(map[string]string{"app": "orders"}), rec.Middleware()))
+		require.NoError(t, err)
+
+		orderID, err := repo.InsertOrder(ctx, randomOrder())
+		require.NoError(t, err)
+
+		require.NoError(t, repo.UpdateOrderStatus(ctx, orderID, domain.OrderStatusShipped, 1))
+
+		order, err := repo.GetOrder(ctx, orderID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.OrderStatusShipped, order.Status)
+
+		names := make(map[string]bool)
+		for _, s := range rec.Statements() {
+			names[s.Name()] = true
+			assert.True(t, strings.HasSuffix(s.SQL, "/*app='orders'*/"), s.SQL)
+		}
+		assert.True(t, names["InsertOrder"])
+		assert.True(t, names["GetOrderJoinItems"])
+	})
+
+	suite.Run("wrapped transaction: fault is rolled back to the savepoint", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		errInjected := errors.New("injected")
+
+		tx, err := suite.pool.Begin(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback(context.WithoutCancel(ctx))
+
+		repo, err := repository.NewOrder(dbtxmw.Wrap(tx, dbtxmw.Fault(func(_ context.Context, s dbtxmw.Statement) error {
+			if s.Name() == "InsertOrder" {
+				return errInjected
+			}
+			return nil
+		})))
+		require.NoError(t, err)
+
+		_, err = repo.InsertOrder(ctx, randomOrder())
+		require.ErrorIs(t, err, errInjected)
+
+		// the transaction is still usable
+		var one int
+		require.NoError(t, tx.QueryRow(ctx, "SELECT 1").Scan(&one))
+	})
+	suite.Run("tx manager on a wrapped pool: statements within the transaction go through the chain", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		rec := &dbtxmw.Recorder{}
+		txManager, err := repository.NewTxManager(dbtxmw.Wrap(suite.pool, rec.Middleware()).(repository.TxBeginner))
+		require.NoError(t, err)
+
+		err = txManager.WithinTx(ctx, func(ctx context.Context) error {
+			_, err := suite.repo.InsertOrder(ctx, randomOrder())
+			return err
+		})
+		require.NoError(t, err)
+
+		var names []string
+		for _, s := range rec.Statements() {
+			names = append(names, s.Name())
+		}
+		assert.Contains(t, names, "InsertOrder")
+	})
+
+	suite.Run("statement timeout: canceled by the server, the connection is kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		beginner := dbtxmw.Wrap(suite.pool, dbtxmw.StatementTimeout(50*time.Millisecond)).(repository.TxBeginner)
+
+		tx, err := beginner.BeginTx(ctx, pgx.TxOptions{})
+		require.NoError(t, err)
+		defer tx.Rollback(context.WithoutCancel(ctx))
+
+		_, err = tx.Exec(ctx, "SELECT pg_sleep(1)")
+
+		// SQLSTATE 57014 query_canceled
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, "57014", pgErr.Code)
+
+		require.NoError(t, tx.Rollback(ctx))
+		assert.False(t, tx.Conn().IsClosed())
+	})
+}
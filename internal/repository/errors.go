@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrNotFound               = errors.New("order not found")
+	ErrConcurrentModification = errors.New("order was modified concurrently")
+	ErrIdempotencyConflict    = errors.New("idempotency key was used for a different order")
+	ErrAlreadyExists          = errors.New("order already exists")
+
+	// ErrConstraintViolation is matched by every *ConstraintError
+	ErrConstraintViolation = errors.New("constraint violation")
+	// ErrSerialization means the transaction lost a race with another one, it is safe to retry
+	ErrSerialization = errors.New("serialization failure")
+	// ErrInvalidInput means PostgreSQL rejected a value, i.e. malformed JSON or a too long string
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrLocked means another transaction holds the lock, i.e. with NOWAIT, SKIP LOCKED or after lock_timeout
+	ErrLocked = errors.New("order is locked")
+	// ErrTxRequired means the method only makes sense within the transaction of the caller, see TxManager
+	ErrTxRequired = errors.New("transaction required")
+)
+
+// SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgCodeNotNullViolation     = "23502"
+	pgCodeForeignKeyViolation  = "23503"
+	pgCodeUniqueViolation      = "23505"
+	pgCodeCheckViolation       = "23514"
+	pgCodeExclusionViolation   = "23P01"
+	pgCodeSerializationFailure = "40001"
+	pgCodeDeadlockDetected     = "40P01"
+	pgCodeLockNotAvailable     = "55P03"
+	pgClassDataException       = "22"
+)
+
+// ConstraintError is a violated constraint, errors.Is matches it with ErrConstraintViolation,
+// and with ErrAlreadyExists for a unique violation. The *pgconn.PgError stays reachable with errors.As.
+type ConstraintError struct {
+	Code       string
+	Constraint string
+	Table      string
+	Column     string
+
+	err error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("constraint[%s] violated: %s", e.Constraint, e.err)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.err
+}
+
+func (e *ConstraintError) Is(target error) bool {
+	switch target {
+	case ErrConstraintViolation:
+		return true
+	case ErrAlreadyExists:
+		return e.Code == pgCodeUniqueViolation
+	default:
+		return false
+	}
+}
+
+// classifiedError adds one of the sentinel errors to a database error, its message is left as it is.
+type classifiedError struct {
+	sentinel error
+	err      error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.sentinel, e.err}
+}
+
+// mapDBError classifies a *pgconn.PgError in the chain of err by its SQLSTATE code,
+// other errors and the errors it has already classified are returned as they are.
+func mapDBError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	var (
+		constraintErr *ConstraintError
+		classifiedErr *classifiedError
+	)
+	if errors.As(err, &constraintErr) || errors.As(err, &classifiedErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case pgCodeNotNullViolation, pgCodeForeignKeyViolation, pgCodeUniqueViolation, pgCodeCheckViolation, pgCodeExclusionViolation:
+		return &ConstraintError{
+			Code:       pgErr.Code,
+			Constraint: pgErr.ConstraintName,
+			Table:      pgErr.TableName,
+			Column:     pgErr.ColumnName,
+			err:        err,
+		}
+	case pgCodeSerializationFailure, pgCodeDeadlockDetected:
+		return &classifiedError{sentinel: ErrSerialization, err: err}
+	case pgCodeLockNotAvailable:
+		return &classifiedError{sentinel: ErrLocked, err: err}
+	}
+
+	if strings.HasPrefix(pgErr.Code, pgClassDataException) {
+		return &classifiedError{sentinel: ErrInvalidInput, err: err}
+	}
+
+	return err
+}
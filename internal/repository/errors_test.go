@@ -0,0 +1,87 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp/internal/dbtxmw"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestErrorMapping() {
+	defer suite.deleteAll()
+
+	suite.Run("duplicate order item: rejected before the database", func() {
+		t := suite.T()
+
+		order := randomOrder()
+		order.Items = append(order.Items, order.Items[0])
+
+		_, err := suite.repo.InsertOrder(t.Context(), order)
+		require.EqualError(t, err, "domain.ValidateOrderItems: product["+order.Items[0].ProductID.String()+"] is listed twice")
+		assert.NotErrorIs(t, err, repository.ErrConstraintViolation)
+	})
+
+	suite.Run("unique violation: already exists", func() {
+		t := suite.T()
+
+		// a concurrent insert of the same item, validation cannot see it
+		repo, err := repository.NewOrder(dbtxmw.Wrap(suite.pool, dbtxmw.Fault(func(_ context.Context, s dbtxmw.Statement) error {
+			if s.Name() != "InsertOrderItem" {
+				return nil
+			}
+			return &pgconn.PgError{Code: "23505", ConstraintName: "order_items_pkey", TableName: "order_items"}
+		})))
+		require.NoError(t, err)
+
+		_, err = repo.InsertOrder(t.Context(), randomOrder())
+		require.ErrorIs(t, err, repository.ErrAlreadyExists)
+		require.ErrorIs(t, err, repository.ErrConstraintViolation)
+		assert.NotErrorIs(t, err, repository.ErrInvalidInput)
+
+		var constraintErr *repository.ConstraintError
+		require.True(t, errors.As(err, &constraintErr))
+		assert.Equal(t, "23505", constraintErr.Code)
+		assert.Equal(t, "order_items_pkey", constraintErr.Constraint)
+		assert.Equal(t, "order_items", constraintErr.Table)
+
+		var pgErr *pgconn.PgError
+		require.True(t, errors.As(err, &pgErr))
+		assert.Equal(t, "23505", pgErr.Code)
+	})
+
+	suite.Run("malformed JSON payload: invalid input", func() {
+		t := suite.T()
+
+		order := randomOrder()
+		order.Payload = []byte(`{"broken":`)
+
+		_, err := suite.repo.InsertOrder(t.Context(), order)
+		require.ErrorIs(t, err, repository.ErrInvalidInput)
+		assert.NotErrorIs(t, err, repository.ErrConstraintViolation)
+	})
+
+	suite.Run("too long owner ID: invalid input", func() {
+		t := suite.T()
+
+		order := randomOrder()
+		order.OwnerID = strings.Repeat("x", 256)
+
+		_, err := suite.repo.InsertOrder(t.Context(), order)
+		require.ErrorIs(t, err, repository.ErrInvalidInput)
+	})
+
+	suite.Run("not found is not a database error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.GetOrder(t.Context(), uuid.MustParse(gofakeit.UUID()))
+		require.ErrorIs(t, err, repository.ErrNotFound)
+		assert.NotErrorIs(t, err, repository.ErrInvalidInput)
+	})
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/dbtxmw"
+)
+
+const defaultSlowQueryThreshold = 500 * time.Millisecond
+
+const redacted = "[REDACTED]"
+
+// sensitiveColumns are the columns whose arguments are never logged
+var sensitiveColumns = map[string]struct{}{
+	"owner_id": {},
+	"payload":  {},
+	"payloadb": {},
+}
+
+var (
+	// i.e. owner_id = $1, o.owner_id = ANY ($2) or payload = $4
+	comparedPlaceholder = regexp.MustCompile(`(?i)\b(\w+)\s*=\s*(?:ANY\s*\(\s*)?\$(\d+)\b`)
+	// i.e. INSERT INTO orders (id, owner_id) VALUES ($1, $2)
+	insertedValues = regexp.MustCompile(`(?is)INSERT\s+INTO\s+[\w.]+\s*\(([^)]*)\)\s*VALUES\s*\(([^)]*)\)`)
+	placeholder    = regexp.MustCompile(`^\$(\d+)\b`)
+)
+
+// WithLogger logs every SQL statement at debug level with its sqlc query name, duration and row count,
+// the ones slower than the threshold of WithSlowQueryThreshold at warn level with their arguments,
+// the arguments of owner_id, payload and payloadb are redacted.
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *orderRepository) {
+		r.logger = logger
+	}
+}
+
+// WithSlowQueryThreshold sets the duration from which a statement is logged at warn level, the default is 500ms.
+func WithSlowQueryThreshold(d time.Duration) Option {
+	return func(r *orderRepository) {
+		r.slowQueryThreshold = d
+	}
+}
+
+// withStatementLogger logs the statements run in the transactions, nil disables it.
+func withStatementLogger(l *statementLogger) TxOption {
+	return func(c *txConfig) {
+		c.statements = l
+	}
+}
+
+// initLogging must be called once the options are applied.
+func (r *orderRepository) initLogging() {
+	if r.logger == nil {
+		return
+	}
+
+	threshold := r.slowQueryThreshold
+	if threshold <= 0 {
+		threshold = defaultSlowQueryThreshold
+	}
+
+	r.statements = &statementLogger{
+		logger:        r.logger,
+		slowThreshold: threshold,
+	}
+	r.txOpts = append(r.txOpts, withStatementLogger(r.statements))
+}
+
+type statementLogger struct {
+	logger        *slog.Logger
+	slowThreshold time.Duration
+
+	// sensitive caches the placeholder numbers to redact by SQL text
+	sensitive sync.Map
+}
+
+// wrap returns dbtx as it is if l is nil, a pgx.Tx stays a pgx.Tx and its savepoints, batches and copies are logged too.
+func (l *statementLogger) wrap(dbtx db.DBTX) db.DBTX {
+	if l == nil {
+		return dbtx
+	}
+
+	return dbtxmw.Wrap(dbtx, dbtxmw.Hook(l.hook))
+}
+
+func (l *statementLogger) hook(ctx context.Context, s dbtxmw.Statement) (context.Context, dbtxmw.Statement, func(int64, error), error) {
+	start := time.Now()
+
+	return ctx, s, func(rows int64, err error) {
+		l.log(ctx, s, time.Since(start), rows, err)
+	}, nil
+}
+
+func (l *statementLogger) log(ctx context.Context, s dbtxmw.Statement, duration time.Duration, rows int64, err error) {
+	level := slog.LevelDebug
+	if duration >= l.slowThreshold {
+		level = slog.LevelWarn
+	}
+
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("query", s.Name()),
+		slog.Duration("duration", duration),
+		slog.Int64("rows", rows),
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	msg := "sql statement"
+	if level == slog.LevelWarn {
+		msg = "slow sql statement"
+		attrs = append(attrs, slog.Any("args", l.redact(s.SQL, s.Args)))
+	}
+
+	l.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// redact returns a copy of args with the arguments of the sensitive columns replaced.
+func (l *statementLogger) redact(sql string, args []any) []any {
+	sensitive, ok := l.sensitive.Load(sql)
+	if !ok {
+		sensitive, _ = l.sensitive.LoadOrStore(sql, sensitivePlaceholders(sql))
+	}
+
+	result := make([]any, len(args))
+	for i, arg := range args {
+		if _, ok := sensitive.(map[int]struct{})[i+1]; ok {
+			result[i] = redacted
+			continue
+		}
+		result[i] = arg
+	}
+
+	return result
+}
+
+// sensitivePlaceholders finds the placeholders compared with or inserted into the sensitive columns.
+func sensitivePlaceholders(sql string) map[int]struct{} {
+	result := make(map[int]struct{})
+
+	for _, m := range comparedPlaceholder.FindAllStringSubmatch(sql, -1) {
+		if _, ok := sensitiveColumns[strings.ToLower(m[1])]; ok {
+			n, _ := strconv.Atoi(m[2])
+			result[n] = struct{}{}
+		}
+	}
+
+	for _, m := range insertedValues.FindAllStringSubmatch(sql, -1) {
+		columns := strings.Split(m[1], ",")
+		values := strings.Split(m[2], ",")
+
+		for i := 0; i < len(columns) && i < len(values); i++ {
+			if _, ok := sensitiveColumns[strings.ToLower(strings.TrimSpace(columns[i]))]; !ok {
+				continue
+			}
+
+			if p := placeholder.FindStringSubmatch(strings.TrimSpace(values[i])); p != nil {
+				n, _ := strconv.Atoi(p[1])
+				result[n] = struct{}{}
+			}
+		}
+	}
+
+	return result
+}
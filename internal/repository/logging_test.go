@@ -0,0 +1,191 @@
+package repository_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestLogging() {
+	defer suite.deleteAll()
+
+	suite.Run("statements at debug level", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		var buf bytes.Buffer
+		repo, err := repository.NewOrder(suite.pool,
+			repository.WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+			repository.WithSlowQueryThreshold(time.Hour),
+		)
+		require.NoError(t, err)
+
+		order := randomOrder()
+		_, err = repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+
+		_, err = repo.SearchOrders(ctx, domain.OrderFilter{OwnerIDs: []string{order.OwnerID}})
+		require.NoError(t, err)
+
+		records := logRecords(t, &buf)
+
+		var insertedItems int
+		for _, record := range records {
+			assert.Equal(t, "DEBUG", record["level"])
+			assert.NotContains(t, record, "args")
+
+			if record["query"] == "InsertOrderItem" {
+				insertedItems++
+			}
+		}
+		assert.Equal(t, len(order.Items), insertedItems)
+
+		search := findRecord(records, "SearchOrders")
+		require.NotNil(t, search)
+		assert.Equal(t, float64(len(order.Items)), search["rows"])
+		assert.Contains(t, search, "duration")
+	})
+
+	suite.Run("locking statements in the savepoints of a transaction", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		orderID := suite.insertOrders(randomOrder())[0]
+
+		var buf bytes.Buffer
+		txRepo := suite.beginTxRepo(
+			repository.WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+			repository.WithSlowQueryThreshold(time.Hour),
+		)
+		defer txRepo.tx.Rollback(ctx)
+
+		_, err := txRepo.repo.GetOrderForUpdate(ctx, orderID, domain.LockNoWait)
+		require.NoError(t, err)
+
+		require.NoError(t, txRepo.repo.LockOrder(ctx, orderID))
+
+		records := logRecords(t, &buf)
+		assert.NotNil(t, findRecord(records, "GetOrderForUpdateNoWait"))
+		assert.NotNil(t, findRecord(records, "GetOrderItems"))
+		assert.NotNil(t, findRecord(records, "LockOrder"))
+	})
+
+	readTxTests := []struct {
+		name    string
+		newRepo func(opts ...repository.Option) (port.OrderRepository, error)
+	}{
+		{
+			name: "read transaction on the primary: statements logged once",
+			newRepo: func(opts ...repository.Option) (port.OrderRepository, error) {
+				return repository.NewOrder(suite.pool, opts...)
+			},
+		},
+		{
+			name: "read transaction on a replica: statements logged once",
+			newRepo: func(opts ...repository.Option) (port.OrderRepository, error) {
+				replica := suite.newReplica(suite.connStr)
+				return repository.NewOrderWithReplicas(suite.pool, []*pgxpool.Pool{replica.pool}, opts...)
+			},
+		},
+	}
+
+	for _, tt := range readTxTests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			orderID := suite.insertOrders(randomOrder())[0]
+
+			var buf bytes.Buffer
+			repo, err := tt.newRepo(
+				repository.WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+				repository.WithSlowQueryThreshold(time.Hour),
+			)
+			require.NoError(t, err)
+
+			_, err = repo.GetOrderSeparateQueries(ctx, orderID)
+			require.NoError(t, err)
+
+			queries := make(map[string]int)
+			for _, record := range logRecords(t, &buf) {
+				queries[record["query"].(string)]++
+			}
+			assert.Equal(t, map[string]int{"GetOrder": 1, "GetOrderItems": 1}, queries)
+		})
+	}
+
+	suite.Run("slow statements at warn level with redacted args", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		var buf bytes.Buffer
+		repo, err := repository.NewOrder(suite.pool,
+			repository.WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))),
+			repository.WithSlowQueryThreshold(time.Nanosecond),
+		)
+		require.NoError(t, err)
+
+		order := randomOrder()
+		orderID, err := repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+
+		_, err = repo.SearchOrders(ctx, domain.OrderFilter{OwnerIDs: []string{order.OwnerID}, Statuses: []domain.OrderStatus{domain.OrderStatusPending}})
+		require.NoError(t, err)
+
+		assert.NotContains(t, buf.String(), order.OwnerID)
+
+		records := logRecords(t, &buf)
+
+		insert := findRecord(records, "InsertOrder")
+		require.NotNil(t, insert)
+		assert.Equal(t, "WARN", insert["level"])
+		assert.Equal(t, "slow sql statement", insert["msg"])
+
+		args, ok := insert["args"].([]any)
+		require.True(t, ok)
+		require.Len(t, args, 10)
+		assert.Equal(t, orderID.String(), args[0])
+		assert.Equal(t, "[REDACTED]", args[1]) // owner_id
+		assert.Equal(t, "[REDACTED]", args[4]) // payload
+		assert.Equal(t, "[REDACTED]", args[5]) // payloadb
+
+		search := findRecord(records, "SearchOrders")
+		require.NotNil(t, search)
+
+		args, ok = search["args"].([]any)
+		require.True(t, ok)
+		assert.Equal(t, "[REDACTED]", args[1]) // owner_id
+		assert.Equal(t, []any{string(domain.OrderStatusPending)}, args[3])
+	})
+}
+
+func logRecords(t require.TestingT, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		var record map[string]any
+		require.NoError(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func findRecord(records []map[string]any, query string) map[string]any {
+	for _, record := range records {
+		if record["query"] == query {
+			return record
+		}
+	}
+
+	return nil
+}
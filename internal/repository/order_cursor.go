@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+// orderCursor is the keyset position of the last order of a page, it is handed out to callers as an opaque string.
+type orderCursor struct {
+	SortKey domain.OrderSortKey `json:"k"`
+	Value   string              `json:"v,omitempty"`
+	ID      uuid.UUID           `json:"id"`
+}
+
+func newOrderCursor(order domain.Order, sortKey domain.OrderSortKey) orderCursor {
+	c := orderCursor{
+		SortKey: sortKey,
+		ID:      order.ID,
+	}
+
+	switch sortKey {
+	case domain.OrderSortByCreatedAt:
+		c.Value = order.CreatedAt.Format(time.RFC3339Nano)
+	case domain.OrderSortByUpdatedAt:
+		c.Value = order.UpdatedAt.Format(time.RFC3339Nano)
+	case domain.OrderSortByPriceAmount:
+		c.Value = order.Price.Amount.String()
+	}
+
+	return c
+}
+
+func (c orderCursor) encode() (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeOrderCursor(s string) (orderCursor, error) {
+	var c orderCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("cursor is malformed: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("cursor is malformed: %w", err)
+	}
+
+	if c.ID == uuid.Nil {
+		return c, fmt.Errorf("cursor has no order ID")
+	}
+
+	return c, nil
+}
+
+// applyTo sets the keyset parameters of the query, the cursor must be issued for the same sort key.
+func (c orderCursor) applyTo(params *db.SearchOrdersPageParams, sortKey domain.OrderSortKey) error {
+	if c.SortKey != sortKey {
+		return fmt.Errorf("cursor sort key[%s] does not match sort key[%s]", c.SortKey, sortKey)
+	}
+
+	switch sortKey {
+	case domain.OrderSortByCreatedAt, domain.OrderSortByUpdatedAt:
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return fmt.Errorf("time.Parse[%s]: %w", c.Value, err)
+		}
+		params.CursorTime = &t
+	case domain.OrderSortByPriceAmount:
+		amount, err := decimal.NewFromString(c.Value)
+		if err != nil {
+			return fmt.Errorf("decimal.NewFromString[%s]: %w", c.Value, err)
+		}
+		params.CursorPriceAmount = &amount
+	}
+
+	params.CursorID = &c.ID
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/samber/lo"
+)
+
+// orderRequest is what an idempotent replay must repeat exactly, the owner and the key itself are matched by the query.
+type orderRequest struct {
+	Url           string   `json:"url"`
+	Tags          []string `json:"tags"`
+	Payload       []byte   `json:"payload"`
+	PayloadB      []byte   `json:"payloadb"`
+	PriceAmount   string   `json:"price_amount"`
+	PriceCurrency string   `json:"price_currency"`
+	Items         []string `json:"items"`
+}
+
+// orderRequestHash returns nil for an order without an idempotency key.
+func orderRequestHash(order domain.Order) (*string, error) {
+	if order.IdempotencyKey == "" {
+		return nil, nil
+	}
+
+	req := orderRequest{
+		Url:           domain.URLString(order.Url),
+		Tags:          order.Tags,
+		Payload:       order.Payload,
+		PayloadB:      order.PayloadB,
+		PriceAmount:   order.Price.Amount.String(),
+		PriceCurrency: order.Price.Currency.String(),
+	}
+
+	for _, item := range order.Items {
+		req.Items = append(req.Items, fmt.Sprintf("%s:%s:%s", item.ProductID, item.Price.Amount, item.Price.Currency))
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	sum := sha256.Sum256(b)
+
+	return lo.ToPtr(hex.EncodeToString(sum[:])), nil
+}
+
+// resolveInsertConflict is called when the order was not inserted because its ID or its idempotency key is taken,
+// it returns the ID of the original order if the request is a replay.
+func resolveInsertConflict(ctx context.Context, q *db.Queries, order domain.Order, requestHash *string) (uuid.UUID, error) {
+	if order.IdempotencyKey == "" {
+		return uuid.Nil, ErrAlreadyExists
+	}
+
+	row, err := q.GetOrderByIdempotencyKey(ctx, db.GetOrderByIdempotencyKeyParams{
+		OwnerID:        order.OwnerID,
+		IdempotencyKey: lo.ToPtr(order.IdempotencyKey),
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// the key is free, so it is the order ID which is taken
+			return uuid.Nil, ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("q.GetOrderByIdempotencyKey: %w", err)
+	}
+
+	if lo.FromPtr(row.RequestHash) != lo.FromPtr(requestHash) {
+		return uuid.Nil, ErrIdempotencyConflict
+	}
+
+	return row.ID, nil
+}
@@ -0,0 +1,157 @@
+package repository_test
+
+import (
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestInsertOrder_Idempotency() {
+	defer suite.deleteAll()
+
+	suite.Run("replay with the same payload: original ID", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		order.IdempotencyKey = gofakeit.UUID()
+
+		orderID, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+
+		replayID, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+		assert.Equal(t, orderID, replayID)
+
+		assert.Equal(t, 1, suite.countOwnerOrders(order.OwnerID))
+		assert.Equal(t, 1, suite.countRows("SELECT COUNT(*) FROM order_outbox WHERE order_id = $1", orderID))
+
+		actual, err := suite.repo.GetOrder(ctx, orderID)
+		require.NoError(t, err)
+		assertOrder(t, order, actual)
+	})
+
+	suite.Run("replay of a soft-deleted order: original ID", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		order.IdempotencyKey = gofakeit.UUID()
+
+		orderID, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+		require.NoError(t, suite.repo.SoftDeleteOrder(ctx, orderID))
+
+		replayID, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+		assert.Equal(t, orderID, replayID)
+	})
+
+	suite.Run("replay with a different payload: conflict", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		order.IdempotencyKey = gofakeit.UUID()
+
+		_, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+
+		changed := order
+		changed.Price.Amount = order.Price.Amount.Add(decimal.NewFromInt(1))
+
+		_, err = suite.repo.InsertOrder(ctx, changed)
+		require.ErrorIs(t, err, repository.ErrIdempotencyConflict)
+
+		assert.Equal(t, 1, suite.countOwnerOrders(order.OwnerID))
+	})
+
+	suite.Run("same key of another owner: new order", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		order.IdempotencyKey = gofakeit.UUID()
+
+		orderID, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+
+		other := order
+		other.OwnerID = gofakeit.UUID()
+
+		otherID, err := suite.repo.InsertOrder(ctx, other)
+		require.NoError(t, err)
+		assert.NotEqual(t, orderID, otherID)
+	})
+
+	suite.Run("no key: new order each time", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+
+		orderID, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+
+		otherID, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+		assert.NotEqual(t, orderID, otherID)
+
+		assert.Equal(t, 2, suite.countOwnerOrders(order.OwnerID))
+	})
+}
+
+func (suite *orderRepositorySuite) TestInsertOrders_Idempotency() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	existing := randomOrder()
+	existing.IdempotencyKey = gofakeit.UUID()
+
+	existingID, err := suite.repo.InsertOrder(ctx, existing)
+	require.NoError(t, err)
+
+	conflicting := existing
+	conflicting.Tags = append([]string{"changed"}, existing.Tags...)
+
+	fresh := randomOrder()
+	fresh.IdempotencyKey = gofakeit.UUID()
+
+	results, err := suite.repo.InsertOrders(ctx, []domain.Order{existing, conflicting, fresh, fresh})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, existingID, results[0].ID)
+
+	require.ErrorIs(t, results[1].Err, repository.ErrIdempotencyConflict)
+	assert.Equal(t, uuid.Nil, results[1].ID)
+
+	// a key repeated within the import is inserted once
+	require.NoError(t, results[2].Err)
+	require.NoError(t, results[3].Err)
+	assert.Equal(t, results[2].ID, results[3].ID)
+
+	actual, err := suite.repo.GetOrder(ctx, results[2].ID)
+	require.NoError(t, err)
+	assertOrder(t, fresh, actual)
+
+	assert.Equal(t, 1, suite.countOwnerOrders(existing.OwnerID))
+	assert.Equal(t, 1, suite.countOwnerOrders(fresh.OwnerID))
+	assert.Equal(t, 1, suite.countRows("SELECT COUNT(*) FROM order_outbox WHERE order_id = $1", results[2].ID))
+}
+
+func (suite *orderRepositorySuite) countOwnerOrders(ownerID string) int {
+	var count int
+
+	err := suite.pool.QueryRow(suite.T().Context(), "SELECT COUNT(*) FROM orders WHERE owner_id = $1", ownerID).Scan(&count)
+	suite.NoError(err)
+
+	return count
+}
@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+)
+
+// The staging tables live until the end of the transaction, CopyFrom fills them
+// and a single INSERT ... SELECT moves their rows to the real tables.
+const (
+	createImportStagingTables = `
+CREATE TEMP TABLE orders_staging
+(
+    LIKE orders INCLUDING DEFAULTS
+) ON COMMIT DROP;
+CREATE TEMP TABLE order_items_staging
+(
+    LIKE order_items INCLUDING DEFAULTS
+) ON COMMIT DROP`
+
+	// an order with a taken ID or idempotency key is skipped, like in InsertOrder
+	moveStagedOrders = `
+INSERT INTO orders (id, owner_id, url, tags, payload, payloadb, price_amount, price_currency, idempotency_key, request_hash)
+SELECT id, owner_id, url, tags, payload, payloadb, price_amount, price_currency, idempotency_key, request_hash
+FROM orders_staging
+ON CONFLICT DO NOTHING
+RETURNING id`
+
+	moveStagedOrderItems = `
+INSERT INTO order_items (order_id, product_id, price_amount, price_currency)
+SELECT order_id, product_id, price_amount, price_currency
+FROM order_items_staging
+WHERE order_id = ANY ($1::UUID[])`
+
+	dropImportStagingTables = `DROP TABLE orders_staging, order_items_staging`
+)
+
+var (
+	stagedOrderColumns     = []string{"id", "owner_id", "url", "tags", "payload", "payloadb", "price_amount", "price_currency", "idempotency_key", "request_hash"}
+	stagedOrderItemColumns = []string{"order_id", "product_id", "price_amount", "price_currency"}
+	outboxColumns          = []string{"event_type", "order_id", "payload"}
+)
+
+// InsertOrders imports orders in a single transaction with CopyFrom, it is meant for large backfills.
+// The results are in the input order, an order which fails validation gets an Err and is skipped
+// while the rest are still inserted. Any database error fails the whole import.
+// IDs and idempotency keys are handled as in InsertOrder, a replay gets the ID of the original order.
+func (r *orderRepository) InsertOrders(ctx context.Context, orders []domain.Order) ([]domain.InsertOrderResult, error) {
+	results := make([]domain.InsertOrderResult, len(orders))
+
+	// the items of two staged orders with the same ID could not be told apart
+	seenIDs := make(map[uuid.UUID]struct{}, len(orders))
+
+	valid := make([]stagedOrder, 0, len(orders))
+	for i, order := range orders {
+		if err := domain.ValidateOrderItems(order.Items); err != nil {
+			results[i].Err = fmt.Errorf("domain.ValidateOrderItems: %w", err)
+			continue
+		}
+
+		if order.ID == uuid.Nil {
+			id, err := uuid.NewV7()
+			if err != nil {
+				return nil, fmt.Errorf("uuid.NewV7: %w", err)
+			}
+			order.ID = id
+		}
+
+		if _, ok := seenIDs[order.ID]; ok {
+			results[i].Err = fmt.Errorf("order[%s] is listed twice: %w", order.ID, ErrAlreadyExists)
+			continue
+		}
+		seenIDs[order.ID] = struct{}{}
+
+		requestHash, err := orderRequestHash(order)
+		if err != nil {
+			results[i].Err = fmt.Errorf("orderRequestHash: %w", err)
+			continue
+		}
+
+		valid = append(valid, stagedOrder{
+			Order:       order,
+			requestHash: requestHash,
+			resultIndex: i,
+		})
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	// the results of valid are built in fn, as a retry of fn must not see the ones of a failed attempt
+	validResults, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) ([]domain.InsertOrderResult, error) {
+		tx, ok := q.DB().(pgx.Tx)
+		if !ok {
+			return nil, fmt.Errorf("q.DB() is not pgx.Tx")
+		}
+
+		if err := copyOrdersToStaging(ctx, tx, valid); err != nil {
+			return nil, fmt.Errorf("copyOrdersToStaging: %w", err)
+		}
+
+		rows, err := tx.Query(ctx, moveStagedOrders)
+		if err != nil {
+			return nil, fmt.Errorf("moveStagedOrders: %w", err)
+		}
+
+		insertedIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+		if err != nil {
+			return nil, fmt.Errorf("moveStagedOrders: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, moveStagedOrderItems, insertedIDs); err != nil {
+			return nil, fmt.Errorf("moveStagedOrderItems: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, dropImportStagingTables); err != nil {
+			return nil, fmt.Errorf("dropImportStagingTables: %w", err)
+		}
+
+		inserted := lo.Keyify(insertedIDs)
+
+		validResults := make([]domain.InsertOrderResult, len(valid))
+		created := make([]domain.Order, 0, len(insertedIDs))
+		for i, staged := range valid {
+			if _, ok := inserted[staged.ID]; ok {
+				validResults[i].ID = staged.ID
+				created = append(created, staged.Order)
+				continue
+			}
+
+			// a taken ID or a replay of an idempotency key, possibly of an order earlier in this import
+			originalID, err := resolveInsertConflict(ctx, q, staged.Order, staged.requestHash)
+			if errors.Is(err, ErrIdempotencyConflict) || errors.Is(err, ErrAlreadyExists) {
+				validResults[i].Err = fmt.Errorf("resolveInsertConflict: %w", err)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("resolveInsertConflict: %w", err)
+			}
+
+			validResults[i].ID = originalID
+		}
+
+		if err := copyOrderCreatedEvents(ctx, tx, created); err != nil {
+			return nil, fmt.Errorf("copyOrderCreatedEvents: %w", err)
+		}
+
+		return validResults, nil
+	}, r.txOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("withTx: %w", err)
+	}
+
+	for i, staged := range valid {
+		results[staged.resultIndex] = validResults[i]
+	}
+
+	r.markWrite(ctx)
+
+	return results, nil
+}
+
+type stagedOrder struct {
+	domain.Order
+	requestHash *string
+	resultIndex int
+}
+
+func copyOrdersToStaging(ctx context.Context, tx pgx.Tx, orders []stagedOrder) error {
+	if _, err := tx.Exec(ctx, createImportStagingTables); err != nil {
+		return fmt.Errorf("createImportStagingTables: %w", err)
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"orders_staging"}, stagedOrderColumns,
+		pgx.CopyFromSlice(len(orders), func(i int) ([]any, error) {
+			order := orders[i]
+			return []any{
+				order.ID,
+				order.OwnerID,
+				lo.ToPtr(domain.URLString(order.Url)),
+				order.Tags,
+				domain.PayloadOrEmpty(order.Payload),
+				order.PayloadB,
+				toNumeric(order.Price.Amount),
+				order.Price.Currency.String(),
+				lo.EmptyableToPtr(order.IdempotencyKey),
+				order.requestHash,
+			}, nil
+		}),
+	); err != nil {
+		return fmt.Errorf("tx.CopyFrom[orders_staging]: %w", err)
+	}
+
+	var rows [][]any
+	for _, order := range orders {
+		for _, item := range order.Items {
+			rows = append(rows, []any{
+				order.ID,
+				item.ProductID,
+				toNumeric(item.Price.Amount),
+				item.Price.Currency.String(),
+			})
+		}
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"order_items_staging"}, stagedOrderItemColumns, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("tx.CopyFrom[order_items_staging]: %w", err)
+	}
+
+	return nil
+}
+
+func copyOrderCreatedEvents(ctx context.Context, tx pgx.Tx, orders []domain.Order) error {
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"order_outbox"}, outboxColumns,
+		pgx.CopyFromSlice(len(orders), func(i int) ([]any, error) {
+			b, err := json.Marshal(newOrderCreatedPayload(orders[i].ID, orders[i]))
+			if err != nil {
+				return nil, fmt.Errorf("json.Marshal: %w", err)
+			}
+
+			return []any{string(domain.OrderEventCreated), orders[i].ID, b}, nil
+		}),
+	); err != nil {
+		return fmt.Errorf("tx.CopyFrom[order_outbox]: %w", err)
+	}
+
+	return nil
+}
+
+// toNumeric converts d for CopyFrom, which uses the binary format where decimal.Decimal is not supported.
+func toNumeric(d decimal.Decimal) pgtype.Numeric {
+	return pgtype.Numeric{
+		Int:   d.Coefficient(),
+		Exp:   d.Exponent(),
+		Valid: true,
+	}
+}
@@ -0,0 +1,133 @@
+package repository_test
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp/internal/dbtxmw"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestInsertOrders() {
+	defer suite.deleteAll()
+
+	suite.Run("valid and invalid orders: valid ones inserted", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		noItems := randomOrder()
+		noItems.Items = nil
+
+		duplicateItems := randomOrder()
+		duplicateItems.Items = append(duplicateItems.Items, duplicateItems.Items[0])
+
+		orders := []domain.Order{randomOrder(), noItems, randomOrder(), duplicateItems, randomOrder()}
+
+		results, err := suite.repo.InsertOrders(ctx, orders)
+		require.NoError(t, err)
+		require.Len(t, results, len(orders))
+
+		require.EqualError(t, results[1].Err, "domain.ValidateOrderItems: no items in order")
+		assert.Equal(t, uuid.Nil, results[1].ID)
+
+		require.EqualError(t, results[3].Err, "domain.ValidateOrderItems: product["+duplicateItems.Items[0].ProductID.String()+"] is listed twice")
+		assert.Equal(t, uuid.Nil, results[3].ID)
+
+		for _, i := range []int{0, 2, 4} {
+			require.NoError(t, results[i].Err)
+
+			actual, err := suite.repo.GetOrder(ctx, results[i].ID)
+			require.NoError(t, err)
+
+			assertOrder(t, orders[i], actual)
+			assert.Equal(t, results[i].ID, actual.ID)
+			assert.Equal(t, domain.OrderStatusPending, actual.Status)
+			assert.Equal(t, int64(1), actual.Version)
+
+			assert.Equal(t, 1, suite.countRows("SELECT COUNT(*) FROM order_outbox WHERE order_id = $1 AND event_type = 'OrderCreated'", results[i].ID))
+		}
+	})
+
+	suite.Run("called twice in a transaction: ok", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		tx, err := suite.pool.Begin(ctx)
+		require.NoError(t, err)
+		defer tx.Rollback(ctx)
+
+		repo, err := repository.NewOrder(tx)
+		require.NoError(t, err)
+
+		for range 2 {
+			results, err := repo.InsertOrders(ctx, []domain.Order{randomOrder()})
+			require.NoError(t, err)
+			require.NoError(t, results[0].Err)
+		}
+	})
+
+	suite.Run("only invalid orders: nothing inserted", func() {
+		t := suite.T()
+
+		order := randomOrder()
+		order.Items = nil
+
+		results, err := suite.repo.InsertOrders(t.Context(), []domain.Order{order})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Error(t, results[0].Err)
+	})
+
+	suite.Run("empty input: empty results", func() {
+		results, err := suite.repo.InsertOrders(suite.T().Context(), nil)
+		require.NoError(suite.T(), err)
+		assert.Empty(suite.T(), results)
+	})
+
+	suite.Run("conflict resolved by a retry: inserted", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		// retried and conflicting reuse the idempotency keys of taken and other for different requests
+		taken, other := randomOrder(), randomOrder()
+		taken.IdempotencyKey, other.IdempotencyKey = gofakeit.UUID(), gofakeit.UUID()
+		takenID := suite.insertOrders(taken, other)[0]
+
+		retried, conflicting := randomOrder(), randomOrder()
+		retried.OwnerID, retried.IdempotencyKey = taken.OwnerID, taken.IdempotencyKey
+		conflicting.OwnerID, conflicting.IdempotencyKey = other.OwnerID, other.IdempotencyKey
+		retried.ID, conflicting.ID = uuid.New(), uuid.New()
+
+		// the first attempt resolves the conflict of retried, then frees its key and fails with a serialization failure
+		var lookups atomic.Int32
+		repo, err := repository.NewOrder(dbtxmw.Wrap(suite.pool, dbtxmw.Fault(func(ctx context.Context, s dbtxmw.Statement) error {
+			if s.Name() != "GetOrderByIdempotencyKey" || lookups.Add(1) != 2 {
+				return nil
+			}
+
+			if err := suite.repo.DeleteOrder(ctx, takenID); err != nil {
+				return err
+			}
+
+			return &pgconn.PgError{Code: "40001", Message: "injected serialization failure"}
+		})))
+		require.NoError(t, err)
+
+		results, err := repo.InsertOrders(ctx, []domain.Order{retried, conflicting})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+
+		require.NoError(t, results[0].Err)
+		assert.Equal(t, retried.ID, results[0].ID)
+		assert.Equal(t, 1, suite.countRows("SELECT COUNT(*) FROM order_outbox WHERE order_id = $1 AND event_type = 'OrderCreated'", retried.ID))
+
+		require.ErrorIs(t, results[1].Err, repository.ErrIdempotencyConflict)
+		assert.Equal(t, uuid.Nil, results[1].ID)
+	})
+}
@@ -0,0 +1,36 @@
+package repository_test
+
+import (
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestSoftDeleteOrderItem() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	removed, kept := randomOrderItem(), randomOrderItem()
+	kept.Price.Currency = removed.Price.Currency
+
+	order := randomOrder()
+	order.Items = []domain.OrderItem{removed, kept}
+	order.Price = domain.Money{
+		Amount:   removed.Price.Amount.Add(kept.Price.Amount),
+		Currency: removed.Price.Currency,
+	}
+
+	orderID := suite.insertOrders(order)[0]
+
+	// the order price is recomputed from the remaining items
+	err := suite.repo.SoftDeleteOrderItem(ctx, orderID, removed.ProductID)
+	require.NoError(t, err)
+
+	actual, err := suite.repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	require.Len(t, actual.Items, 1)
+	assert.Equal(t, kept.ProductID, actual.Items[0].ProductID)
+	assert.True(t, kept.Price.Amount.Equal(actual.Price.Amount), "price: %s", actual.Price.Amount)
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+)
+
+// GetOrderForUpdate reads the order and locks its row until the end of the transaction of ctx,
+// the items are not locked, but every item change updates the order row too.
+// With LockNoWait and LockSkipLocked a lock held by another transaction fails with ErrLocked right away.
+func (r *orderRepository) GetOrderForUpdate(ctx context.Context, orderID uuid.UUID, mode domain.LockMode) (domain.Order, error) {
+	var o domain.Order
+
+	if orderID == uuid.Nil {
+		return o, fmt.Errorf("orderID is empty")
+	}
+
+	if err := mode.Validate(); err != nil {
+		return o, fmt.Errorf("mode.Validate: %w", err)
+	}
+
+	tx, err := r.callerTx(ctx)
+	if err != nil {
+		return o, fmt.Errorf("r.callerTx: %w", err)
+	}
+
+	// the savepoint keeps the lock after it is released, and keeps tx usable after ErrLocked
+	order, err := withTx(ctx, tx, func(q *db.Queries) (domain.Order, error) {
+		dbOrder, err := getOrderForUpdate(ctx, q, orderID, mode)
+		if err != nil {
+			return o, fmt.Errorf("getOrderForUpdate: %w", err)
+		}
+
+		dbOrderItems, err := q.GetOrderItems(ctx, orderID)
+		if err != nil {
+			return o, fmt.Errorf("q.GetOrderItems: %w", err)
+		}
+
+		domainOrder, err := mapDBOrderToDomain(dbOrder, dbOrderItems)
+		if err != nil {
+			return o, fmt.Errorf("mapDBOrderToDomain: %w", err)
+		}
+
+		return domainOrder, nil
+	}, r.txOpts...)
+	if err != nil {
+		return o, fmt.Errorf("withTx: %w", err)
+	}
+
+	return order, nil
+}
+
+func getOrderForUpdate(ctx context.Context, q *db.Queries, orderID uuid.UUID, mode domain.LockMode) (db.GetOrderRow, error) {
+	var (
+		row db.GetOrderRow
+		err error
+	)
+
+	switch mode {
+	case domain.LockNoWait:
+		var dbRow db.GetOrderForUpdateNoWaitRow
+		dbRow, err = q.GetOrderForUpdateNoWait(ctx, orderID)
+		row = db.GetOrderRow(dbRow)
+	case domain.LockSkipLocked:
+		var dbRow db.GetOrderForUpdateSkipLockedRow
+		dbRow, err = q.GetOrderForUpdateSkipLocked(ctx, orderID)
+		row = db.GetOrderRow(dbRow)
+	default:
+		var dbRow db.GetOrderForUpdateRow
+		dbRow, err = q.GetOrderForUpdate(ctx, orderID)
+		row = db.GetOrderRow(dbRow)
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		if mode != domain.LockSkipLocked {
+			return row, ErrNotFound
+		}
+
+		// a skipped row is indistinguishable from a missing one
+		exists, err := q.OrderExists(ctx, orderID)
+		if err != nil {
+			return row, fmt.Errorf("q.OrderExists: %w", err)
+		}
+		if exists {
+			return row, ErrLocked
+		}
+		return row, ErrNotFound
+	}
+	if err != nil {
+		return row, err
+	}
+
+	return row, nil
+}
+
+// LockOrder takes a transaction-level advisory lock on the order ID, it waits for the lock
+// and fails with ErrLocked if lock_timeout is set and expires. The order does not have to exist,
+// so the lock can guard its creation too.
+func (r *orderRepository) LockOrder(ctx context.Context, orderID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	tx, err := r.callerTx(ctx)
+	if err != nil {
+		return fmt.Errorf("r.callerTx: %w", err)
+	}
+
+	zero := struct{}{}
+	_, err = withTx(ctx, tx, func(q *db.Queries) (struct{}, error) {
+		if err := q.LockOrder(ctx, orderID); err != nil {
+			return zero, fmt.Errorf("q.LockOrder: %w", err)
+		}
+
+		return zero, nil
+	}, r.txOpts...)
+	if err != nil {
+		return fmt.Errorf("withTx: %w", err)
+	}
+
+	return nil
+}
+
+// callerTx returns the transaction of TxManager.WithinTx or the one the repository was created with,
+// locks taken in a transaction started by the repository itself would be released right away.
+func (r *orderRepository) callerTx(ctx context.Context) (pgx.Tx, error) {
+	tx, ok := r.conn(ctx).(pgx.Tx)
+	if !ok {
+		return nil, ErrTxRequired
+	}
+
+	return tx, nil
+}
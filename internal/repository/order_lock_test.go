@@ -0,0 +1,165 @@
+package repository_test
+
+import (
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestGetOrderForUpdate() {
+	defer suite.deleteAll()
+
+	order := suite.insertAndGetOrder(randomOrder())
+
+	tests := []struct {
+		name      string
+		orderID   uuid.UUID
+		mode      domain.LockMode
+		locked    bool
+		wantError error
+	}{
+		{
+			name:    "wait: ok",
+			orderID: order.ID,
+			mode:    domain.LockWait,
+		},
+		{
+			name:    "nowait: ok",
+			orderID: order.ID,
+			mode:    domain.LockNoWait,
+		},
+		{
+			name:    "skip locked: ok",
+			orderID: order.ID,
+			mode:    domain.LockSkipLocked,
+		},
+		{
+			name:      "nowait, locked: error",
+			orderID:   order.ID,
+			mode:      domain.LockNoWait,
+			locked:    true,
+			wantError: repository.ErrLocked,
+		},
+		{
+			name:      "skip locked, locked: error",
+			orderID:   order.ID,
+			mode:      domain.LockSkipLocked,
+			locked:    true,
+			wantError: repository.ErrLocked,
+		},
+		{
+			name:      "wait, not found: error",
+			orderID:   uuid.New(),
+			mode:      domain.LockWait,
+			wantError: repository.ErrNotFound,
+		},
+		{
+			name:      "skip locked, not found: error",
+			orderID:   uuid.New(),
+			mode:      domain.LockSkipLocked,
+			wantError: repository.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			if tt.locked {
+				blocker := suite.beginTxRepo()
+				defer blocker.tx.Rollback(ctx)
+
+				_, err := blocker.repo.GetOrderForUpdate(ctx, tt.orderID, domain.LockWait)
+				require.NoError(t, err)
+			}
+
+			observer := &countingTxObserver{}
+			txRepo := suite.beginTxRepo(repository.WithTxOptions(repository.WithTxObserver(observer)))
+			defer txRepo.tx.Rollback(ctx)
+
+			actual, err := txRepo.repo.GetOrderForUpdate(ctx, tt.orderID, tt.mode)
+			if tt.wantError != nil {
+				require.ErrorIs(t, err, tt.wantError)
+				assert.Equal(t, int64(1), observer.rollbacks.Load())
+
+				// only the savepoint is rolled back, the transaction goes on
+				_, err = txRepo.repo.GetOrder(ctx, order.ID)
+				require.NoError(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assertOrder(t, order, actual)
+		})
+	}
+
+	suite.Run("no transaction: error", func() {
+		_, err := suite.repo.GetOrderForUpdate(suite.T().Context(), order.ID, domain.LockWait)
+		require.ErrorIs(suite.T(), err, repository.ErrTxRequired)
+	})
+
+	suite.Run("invalid mode: error", func() {
+		txRepo := suite.beginTxRepo()
+		defer txRepo.tx.Rollback(suite.T().Context())
+
+		_, err := txRepo.repo.GetOrderForUpdate(suite.T().Context(), order.ID, "bogus")
+		require.Error(suite.T(), err)
+	})
+}
+
+func (suite *orderRepositorySuite) TestLockOrder() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	// the order does not have to exist
+	orderID := uuid.New()
+
+	require.ErrorIs(t, suite.repo.LockOrder(ctx, orderID), repository.ErrTxRequired)
+
+	blocker := suite.beginTxRepo()
+	defer blocker.tx.Rollback(ctx)
+	require.NoError(t, blocker.repo.LockOrder(ctx, orderID))
+
+	observer := &countingTxObserver{}
+	txRepo := suite.beginTxRepo(repository.WithTxOptions(repository.WithTxObserver(observer)))
+	defer txRepo.tx.Rollback(ctx)
+
+	_, err := txRepo.tx.Exec(ctx, "SET LOCAL lock_timeout = '50ms'")
+	require.NoError(t, err)
+
+	// another order is not blocked
+	require.NoError(t, txRepo.repo.LockOrder(ctx, uuid.New()))
+
+	err = txRepo.repo.LockOrder(ctx, orderID)
+	require.ErrorIs(t, err, repository.ErrLocked)
+	assert.Equal(t, int64(1), observer.rollbacks.Load())
+
+	require.NoError(t, blocker.tx.Commit(ctx))
+
+	// the lock was released with the blocker transaction
+	require.NoError(t, txRepo.repo.LockOrder(ctx, orderID))
+	assert.NoError(t, txRepo.tx.Commit(ctx))
+}
+
+type txRepo struct {
+	tx   pgx.Tx
+	repo port.OrderRepository
+}
+
+// beginTxRepo starts a transaction and creates a repository with opts on it, the caller must end the transaction.
+func (suite *orderRepositorySuite) beginTxRepo(opts ...repository.Option) txRepo {
+	tx, err := suite.pool.Begin(suite.T().Context())
+	suite.Require().NoError(err)
+
+	repo, err := repository.NewOrder(tx, opts...)
+	suite.Require().NoError(err)
+
+	return txRepo{tx: tx, repo: repo}
+}
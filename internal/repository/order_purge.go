@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+)
+
+// Purge hard-deletes, in a single transaction, up to batchSize items which were soft-deleted more than olderThan ago,
+// themselves or with their order, then up to batchSize such orders which have no items left,
+// so an order with more than batchSize items takes several calls. Call it until the result IsZero to purge everything,
+// a short transaction per batch keeps the rows locked only briefly. Rows locked by other transactions are skipped
+// until the next call.
+func (r *orderRepository) Purge(ctx context.Context, olderThan time.Duration, batchSize int) (domain.PurgeResult, error) {
+	var result domain.PurgeResult
+
+	if olderThan < 0 {
+		return result, fmt.Errorf("olderThan must not be negative")
+	}
+	if batchSize <= 0 {
+		return result, fmt.Errorf("batchSize must be positive")
+	}
+
+	retention := olderThan.Seconds()
+	limit := int32(batchSize)
+
+	result, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (domain.PurgeResult, error) {
+		var res domain.PurgeResult
+
+		// items go first, as in DeleteOrder, the orders follow once all their items are gone
+		cmdTag, err := q.PurgeOrderItems(ctx, db.PurgeOrderItemsParams{
+			RetentionSeconds: retention,
+			BatchSize:        limit,
+		})
+		if err != nil {
+			return res, fmt.Errorf("q.PurgeOrderItems: %w", err)
+		}
+		res.Items = cmdTag.RowsAffected()
+
+		orderIDs, err := q.GetPurgeableOrderIDs(ctx, db.GetPurgeableOrderIDsParams{
+			RetentionSeconds: retention,
+			BatchSize:        limit,
+		})
+		if err != nil {
+			return res, fmt.Errorf("q.GetPurgeableOrderIDs: %w", err)
+		}
+
+		if len(orderIDs) == 0 {
+			return res, nil
+		}
+
+		cmdTag, err = q.DeleteOrdersByIDs(ctx, orderIDs)
+		if err != nil {
+			return res, fmt.Errorf("q.DeleteOrdersByIDs: %w", err)
+		}
+		res.Orders = cmdTag.RowsAffected()
+
+		for _, orderID := range orderIDs {
+			if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventPurged, domain.OrderPurgedPayload{
+				OrderID: orderID,
+			}); err != nil {
+				return res, fmt.Errorf("emitOrderEvent: %w", err)
+			}
+		}
+
+		return res, nil
+	}, r.txOpts...)
+	if err != nil {
+		return domain.PurgeResult{}, fmt.Errorf("withTx: %w", err)
+	}
+
+	r.markWrite(ctx)
+
+	return result, nil
+}
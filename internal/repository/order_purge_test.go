@@ -0,0 +1,86 @@
+package repository_test
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestPurge() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	active, deleted, withDeletedItem := randomOrder(), randomOrder(), randomOrder()
+	extraItem := randomOrderItem()
+	extraItem.Price.Currency = withDeletedItem.Price.Currency
+	withDeletedItem.Items = append(withDeletedItem.Items, extraItem)
+	withDeletedItem.Price.Amount = withDeletedItem.Price.Amount.Add(extraItem.Price.Amount)
+
+	ids := suite.insertOrders(active, deleted, withDeletedItem)
+	activeID, deletedID, withDeletedItemID := ids[0], ids[1], ids[2]
+
+	require.NoError(t, suite.repo.SoftDeleteOrder(ctx, deletedID))
+	require.NoError(t, suite.repo.SoftDeleteOrderItem(ctx, withDeletedItemID, withDeletedItem.Items[0].ProductID))
+
+	// nothing was deleted an hour ago
+	result, err := suite.repo.Purge(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	assert.True(t, result.IsZero())
+
+	var total domain.PurgeResult
+	for i := 0; ; i++ {
+		require.Less(t, i, 10, "purge does not converge")
+
+		result, err := suite.repo.Purge(ctx, 0, 1)
+		require.NoError(t, err)
+
+		assert.LessOrEqual(t, result.Orders, int64(1))
+		assert.LessOrEqual(t, result.Items, int64(1), "the items of a purged order count against the batch size")
+		total = total.Add(result)
+
+		if result.IsZero() {
+			break
+		}
+	}
+
+	expected := domain.PurgeResult{
+		Orders: 1,
+		Items:  int64(len(deleted.Items)) + 1,
+	}
+	assert.Equal(t, expected, total)
+
+	assert.Equal(t, 0, suite.countRows("SELECT COUNT(*) FROM orders WHERE id = $1", deletedID))
+	assert.Equal(t, 0, suite.countRows("SELECT COUNT(*) FROM order_items WHERE order_id = $1", deletedID))
+	assert.Equal(t, len(withDeletedItem.Items)-1, suite.countRows("SELECT COUNT(*) FROM order_items WHERE order_id = $1", withDeletedItemID))
+
+	// the soft delete emitted OrderDeleted, the purge emits its own event
+	assert.Equal(t, 1, suite.countRows("SELECT COUNT(*) FROM order_outbox WHERE order_id = $1 AND event_type = 'OrderDeleted'", deletedID))
+	assert.Equal(t, 1, suite.countRows("SELECT COUNT(*) FROM order_outbox WHERE order_id = $1 AND event_type = 'OrderPurged'", deletedID))
+
+	_, err = suite.repo.GetOrder(ctx, activeID)
+	require.NoError(t, err)
+
+	suite.Run("negative olderThan: error", func() {
+		_, err := suite.repo.Purge(suite.T().Context(), -time.Second, 1)
+		require.EqualError(suite.T(), err, "olderThan must not be negative")
+	})
+
+	suite.Run("zero batch size: error", func() {
+		_, err := suite.repo.Purge(suite.T().Context(), time.Hour, 0)
+		require.EqualError(suite.T(), err, "batchSize must be positive")
+	})
+}
+
+func (suite *orderRepositorySuite) countRows(query string, id uuid.UUID) int {
+	var count int
+
+	err := suite.pool.QueryRow(suite.T().Context(), query, id).Scan(&count)
+	suite.NoError(err)
+
+	return count
+}
@@ -4,45 +4,85 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/nikolayk812/sqlcpp/internal/db"
 	"github.com/nikolayk812/sqlcpp/internal/domain"
 	"github.com/nikolayk812/sqlcpp/internal/port"
 	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
 	"golang.org/x/text/currency"
 )
 
-var (
-	ErrNotFound = errors.New("order not found")
-)
-
 type orderRepository struct {
-	q    *db.Queries
-	dbtx db.DBTX
+	dbtx   db.DBTX
+	txOpts []TxOption
+
+	// replicas is nil unless created with NewOrderWithReplicas
+	replicas             *replicaSet
+	readYourWritesWindow time.Duration
+
+	logger             *slog.Logger
+	slowQueryThreshold time.Duration
+	// statements is nil unless created with WithLogger
+	statements *statementLogger
 }
 
-// NewOrder creates a new OrderRepository with the given dbtx (pgx.Tx or pgxpool.Pool).
-func NewOrder(dbtx db.DBTX) (port.OrderRepository, error) {
+// Option configures the repository created by NewOrder or NewOrderWithReplicas.
+type Option func(*orderRepository)
+
+// WithTxOptions applies opts to every transaction the repository starts,
+// they have no effect when the repository was created with a pgx.Tx.
+func WithTxOptions(opts ...TxOption) Option {
+	return func(r *orderRepository) {
+		r.txOpts = append(r.txOpts, opts...)
+	}
+}
+
+// NewOrder creates a new OrderRepository with the given dbtx (pgx.Tx, pgxpool.Pool or a TxBeginner wrapping it).
+func NewOrder(dbtx db.DBTX, opts ...Option) (port.OrderRepository, error) {
 	if dbtx == nil {
 		return nil, fmt.Errorf("dbtx is nil")
 	}
 
-	return &orderRepository{
-		q:    db.New(dbtx),
+	r := &orderRepository{
 		dbtx: dbtx,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.initLogging()
+
+	return r, nil
+}
+
+// conn returns the transaction started by TxManager.WithinTx for ctx, or the dbtx of the repository.
+func (r *orderRepository) conn(ctx context.Context) db.DBTX {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+	return r.dbtx
+}
+
+func (r *orderRepository) queries(ctx context.Context) *db.Queries {
+	return db.New(r.statements.wrap(r.conn(ctx)))
 }
 
 func (r *orderRepository) GetOrder(ctx context.Context, orderID uuid.UUID) (domain.Order, error) {
 	var o domain.Order
 
-	dbOrderItemsRows, err := r.q.GetOrderJoinItems(ctx, orderID)
+	dbOrderItemsRows, err := routeRead(ctx, r, func(dbtx db.DBTX) ([]db.GetOrderJoinItemsRow, error) {
+		return db.New(r.statements.wrap(dbtx)).GetOrderJoinItems(ctx, orderID)
+	})
 	if err != nil {
-		return o, fmt.Errorf("q.GetOrderJoinItems: %w", err)
+		return o, fmt.Errorf("q.GetOrderJoinItems: %w", mapDBError(err))
 	}
 
 	if len(dbOrderItemsRows) == 0 {
@@ -70,26 +110,28 @@ func (r *orderRepository) GetOrder(ctx context.Context, orderID uuid.UUID) (doma
 func (r *orderRepository) GetOrderSeparateQueries(ctx context.Context, orderID uuid.UUID) (domain.Order, error) {
 	var o domain.Order
 
-	order, err := withTx(ctx, r.dbtx, func(q *db.Queries) (domain.Order, error) {
-		dbOrder, err := q.GetOrder(ctx, orderID)
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return o, fmt.Errorf("q.GetOrder: %w", ErrNotFound)
+	order, err := routeRead(ctx, r, func(dbtx db.DBTX) (domain.Order, error) {
+		return withTx(ctx, dbtx, func(q *db.Queries) (domain.Order, error) {
+			dbOrder, err := q.GetOrder(ctx, orderID)
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return o, fmt.Errorf("q.GetOrder: %w", ErrNotFound)
+				}
+				return o, fmt.Errorf("q.GetOrder: %w", err)
 			}
-			return o, fmt.Errorf("q.GetOrder: %w", err)
-		}
 
-		dbOrderItems, err := q.GetOrderItems(ctx, orderID)
-		if err != nil {
-			return o, fmt.Errorf("q.GetOrderItems: %w", err)
-		}
+			dbOrderItems, err := q.GetOrderItems(ctx, orderID)
+			if err != nil {
+				return o, fmt.Errorf("q.GetOrderItems: %w", err)
+			}
 
-		domainOrder, err := mapDBOrderToDomain(dbOrder, dbOrderItems)
-		if err != nil {
-			return o, fmt.Errorf("mapDBOrderToDomain: %w", err)
-		}
+			domainOrder, err := mapDBOrderToDomain(dbOrder, dbOrderItems)
+			if err != nil {
+				return o, fmt.Errorf("mapDBOrderToDomain: %w", err)
+			}
 
-		return domainOrder, nil
+			return domainOrder, nil
+		}, r.txOpts...)
 	})
 	if err != nil {
 		return o, fmt.Errorf("withTx: %w", err)
@@ -99,21 +141,42 @@ func (r *orderRepository) GetOrderSeparateQueries(ctx context.Context, orderID u
 }
 
 func (r *orderRepository) InsertOrder(ctx context.Context, order domain.Order) (uuid.UUID, error) {
-	if len(order.Items) == 0 {
-		return uuid.Nil, errors.New("no items in order")
+	if err := domain.ValidateOrderItems(order.Items); err != nil {
+		return uuid.Nil, fmt.Errorf("domain.ValidateOrderItems: %w", err)
+	}
+
+	requestHash, err := orderRequestHash(order)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("orderRequestHash: %w", err)
 	}
 
-	orderID, err := withTx(ctx, r.dbtx, func(q *db.Queries) (uuid.UUID, error) {
-		// Insert the order and get the generated order ID
+	if order.ID == uuid.Nil {
+		if order.ID, err = uuid.NewV7(); err != nil {
+			return uuid.Nil, fmt.Errorf("uuid.NewV7: %w", err)
+		}
+	}
+
+	orderID, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (uuid.UUID, error) {
 		orderID, err := q.InsertOrder(ctx, db.InsertOrderParams{
-			OwnerID:       order.OwnerID,
-			Url:           lo.ToPtr(urlToString(order.Url)),
-			Tags:          order.Tags,
-			Payload:       emptyJSONIfNil(order.Payload),
-			Payloadb:      order.PayloadB,
-			PriceAmount:   order.Price.Amount,
-			PriceCurrency: order.Price.Currency.String(),
+			ID:             order.ID,
+			OwnerID:        order.OwnerID,
+			Url:            lo.ToPtr(domain.URLString(order.Url)),
+			Tags:           order.Tags,
+			Payload:        domain.PayloadOrEmpty(order.Payload),
+			Payloadb:       order.PayloadB,
+			PriceAmount:    order.Price.Amount,
+			PriceCurrency:  order.Price.Currency.String(),
+			IdempotencyKey: lo.EmptyableToPtr(order.IdempotencyKey),
+			RequestHash:    requestHash,
 		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			// a replay gets the original order, which already has its items and events
+			orderID, err := resolveInsertConflict(ctx, q, order, requestHash)
+			if err != nil {
+				return uuid.Nil, fmt.Errorf("resolveInsertConflict: %w", err)
+			}
+			return orderID, nil
+		}
 		if err != nil {
 			return uuid.Nil, fmt.Errorf("q.InsertOrder: %w", err)
 		}
@@ -127,24 +190,56 @@ func (r *orderRepository) InsertOrder(ctx context.Context, order domain.Order) (
 			return uuid.Nil, fmt.Errorf("r.insertOrderItems: %w", err)
 		}
 
+		if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventCreated, newOrderCreatedPayload(orderID, order)); err != nil {
+			return uuid.Nil, fmt.Errorf("emitOrderEvent: %w", err)
+		}
+
 		return orderID, nil
-	})
+	}, r.txOpts...)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("withTx: %w", err)
 	}
 
+	r.markWrite(ctx)
+
 	return orderID, nil
 }
 
-func (r *orderRepository) insertOrderItems(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, items []domain.OrderItem) (txErr error) {
+func newOrderCreatedPayload(orderID uuid.UUID, order domain.Order) domain.OrderCreatedPayload {
+	productIDs := make([]uuid.UUID, 0, len(order.Items))
+	for _, item := range order.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	return domain.OrderCreatedPayload{
+		OrderID:       orderID,
+		OwnerID:       order.OwnerID,
+		Status:        domain.OrderStatusPending,
+		PriceAmount:   order.Price.Amount,
+		PriceCurrency: order.Price.Currency.String(),
+		ProductIDs:    productIDs,
+	}
+}
+
+func (r *orderRepository) insertOrderItems(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, items []domain.OrderItem) error {
+	if _, err := execOrderItemsBatch(ctx, tx, db.InsertOrderItem, orderID, items); err != nil {
+		return fmt.Errorf("execOrderItemsBatch: %w", err)
+	}
+
+	return nil
+}
+
+// execOrderItemsBatch runs query for every item in a single batch,
+// query takes order_id, product_id, price_amount and price_currency parameters like db.InsertOrderItem.
+func execOrderItemsBatch(ctx context.Context, tx pgx.Tx, query string, orderID uuid.UUID, items []domain.OrderItem) (_ []pgconn.CommandTag, txErr error) {
 	if tx == nil {
-		return fmt.Errorf("tx is nil")
+		return nil, fmt.Errorf("tx is nil")
 	}
 
 	batch := &pgx.Batch{}
 
 	for _, item := range items {
-		batch.Queue(db.InsertOrderItem,
+		batch.Queue(query,
 			orderID,
 			item.ProductID,
 			item.Price.Amount,
@@ -159,36 +254,88 @@ func (r *orderRepository) insertOrderItems(ctx context.Context, tx pgx.Tx, order
 		}
 	}()
 
+	cmdTags := make([]pgconn.CommandTag, 0, batch.Len())
 	for i := 0; i < batch.Len(); i++ {
-		if _, err := results.Exec(); err != nil {
-			return fmt.Errorf("batch item[%d]: %w", i, err)
+		cmdTag, err := results.Exec()
+		if err != nil {
+			return nil, fmt.Errorf("batch item[%d]: %w", i, err)
 		}
+		cmdTags = append(cmdTags, cmdTag)
 	}
 
-	return nil
+	return cmdTags, nil
 }
 
-func (r *orderRepository) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status domain.OrderStatus) error {
+func (r *orderRepository) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status domain.OrderStatus, expectedVersion int64) error {
 	if orderID == uuid.Nil {
 		return fmt.Errorf("orderID is empty")
 	}
 
+	if expectedVersion <= 0 {
+		return fmt.Errorf("expectedVersion must be positive")
+	}
+
 	if status == "" {
 		return fmt.Errorf("status is empty")
 	}
 
-	cmdTag, err := r.q.UpdateOrderStatus(ctx, db.UpdateOrderStatusParams{
-		ID:     orderID,
-		Status: string(status),
-	})
-	if err != nil {
-		return fmt.Errorf("q.UpdateOrderStatus: %w", err)
+	if _, err := domain.ToOrderStatus(string(status)); err != nil {
+		return fmt.Errorf("domain.ToOrderStatus[%s]: %w", status, err)
+	}
+
+	var fromStatuses []string
+	for _, from := range domain.TransitionSources(status) {
+		fromStatuses = append(fromStatuses, string(from))
 	}
 
-	if cmdTag.RowsAffected() == 0 {
-		return fmt.Errorf("q.UpdateOrderStatus: %w", ErrNotFound)
+	zero := struct{}{}
+	_, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (struct{}, error) {
+		// the transition is checked and applied by a single conditional UPDATE,
+		// so a concurrent status change cannot slip in between the check and the write
+		row, err := q.UpdateOrderStatus(ctx, db.UpdateOrderStatusParams{
+			ID:              orderID,
+			Status:          string(status),
+			ExpectedVersion: expectedVersion,
+			FromStatuses:    fromStatuses,
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return zero, fmt.Errorf("q.UpdateOrderStatus: %w", ErrNotFound)
+			}
+			return zero, fmt.Errorf("q.UpdateOrderStatus: %w", err)
+		}
+
+		if row.PreviousVersion != expectedVersion {
+			return zero, fmt.Errorf("q.UpdateOrderStatus: %w", ErrConcurrentModification)
+		}
+
+		if !row.Updated {
+			return zero, &domain.InvalidTransitionError{
+				From: domain.OrderStatus(row.PreviousStatus),
+				To:   status,
+			}
+		}
+
+		if err := recordOrderChange(ctx, q, orderID, nil, domain.OrderChangeStatus, row.PreviousStatus, string(status)); err != nil {
+			return zero, fmt.Errorf("recordOrderChange: %w", err)
+		}
+
+		if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventStatusChanged, domain.OrderStatusChangedPayload{
+			OrderID: orderID,
+			From:    domain.OrderStatus(row.PreviousStatus),
+			To:      status,
+		}); err != nil {
+			return zero, fmt.Errorf("emitOrderEvent: %w", err)
+		}
+
+		return zero, nil
+	}, r.txOpts...)
+	if err != nil {
+		return fmt.Errorf("withTx: %w", err)
 	}
 
+	r.markWrite(ctx)
+
 	return nil
 }
 
@@ -199,20 +346,130 @@ func (r *orderRepository) SearchOrders(ctx context.Context, filter domain.OrderF
 
 	dbFilter := mapDomainOrderFilterToSearchOrdersParams(filter)
 
-	dbOrders, err := r.q.SearchOrders(ctx, dbFilter)
+	dbOrders, err := routeRead(ctx, r, func(dbtx db.DBTX) ([]db.SearchOrdersRow, error) {
+		return db.New(r.statements.wrap(dbtx)).SearchOrders(ctx, dbFilter)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("q.SearchOrders: %w", mapDBError(err))
+	}
+
+	orders, err := groupSearchOrdersRows(dbOrders)
+	if err != nil {
+		return nil, fmt.Errorf("groupSearchOrdersRows: %w", err)
+	}
+
+	return orders, nil
+}
+
+func (r *orderRepository) SearchOrdersPage(ctx context.Context, filter domain.OrderFilter, page domain.PageRequest) (domain.OrderPage, error) {
+	var p domain.OrderPage
+
+	if err := filter.Validate(); err != nil {
+		return p, fmt.Errorf("filter.Validate: %w", err)
+	}
+
+	if err := page.Validate(); err != nil {
+		return p, fmt.Errorf("page.Validate: %w", err)
+	}
+
+	dbFilter := mapDomainOrderFilterToSearchOrdersPageParams(filter)
+	dbFilter.SortKey = string(page.SortKey)
+	// fetch one extra order to find out whether there is a next page
+	dbFilter.PageLimit = int32(page.Size + 1)
+
+	if page.Cursor != "" {
+		cursor, err := decodeOrderCursor(page.Cursor)
+		if err != nil {
+			return p, fmt.Errorf("decodeOrderCursor: %w", err)
+		}
+
+		if err := cursor.applyTo(&dbFilter, page.SortKey); err != nil {
+			return p, fmt.Errorf("cursor.applyTo: %w", err)
+		}
+	}
+
+	dbOrders, err := r.queries(ctx).SearchOrdersPage(ctx, dbFilter)
 	if err != nil {
-		return nil, fmt.Errorf("q.SearchOrders: %w", err)
+		return p, fmt.Errorf("q.SearchOrdersPage: %w", mapDBError(err))
 	}
 
-	// Use a map to group orders and their items
-	orderMap := make(map[uuid.UUID]domain.Order)
+	rows := make([]db.SearchOrdersRow, 0, len(dbOrders))
 	for _, row := range dbOrders {
-		if _, exists := orderMap[row.ID]; !exists {
+		rows = append(rows, db.SearchOrdersRow(row))
+	}
+
+	orders, err := groupSearchOrdersRows(rows)
+	if err != nil {
+		return p, fmt.Errorf("groupSearchOrdersRows: %w", err)
+	}
+
+	if len(orders) > page.Size {
+		orders = orders[:page.Size]
+
+		p.NextCursor, err = newOrderCursor(orders[len(orders)-1], page.SortKey).encode()
+		if err != nil {
+			return p, fmt.Errorf("cursor.encode: %w", err)
+		}
+	}
+
+	p.Orders = orders
+
+	return p, nil
+}
+
+func (r *orderRepository) CountOrders(ctx context.Context, filter domain.OrderFilter) (int64, error) {
+	if err := filter.Validate(); err != nil {
+		return 0, fmt.Errorf("filter.Validate: %w", err)
+	}
+
+	dbFilter := mapDomainOrderFilterToSearchOrdersParams(filter)
+
+	count, err := r.queries(ctx).CountOrders(ctx, db.CountOrdersParams(dbFilter))
+	if err != nil {
+		return 0, fmt.Errorf("q.CountOrders: %w", mapDBError(err))
+	}
+
+	return count, nil
+}
+
+func (r *orderRepository) GetOrderFacets(ctx context.Context, filter domain.OrderFilter) (domain.OrderFacets, error) {
+	var f domain.OrderFacets
+
+	if err := filter.Validate(); err != nil {
+		return f, fmt.Errorf("filter.Validate: %w", err)
+	}
+
+	dbFilter := mapDomainOrderFilterToSearchOrdersParams(filter)
+
+	rows, err := r.queries(ctx).GetOrderFacets(ctx, db.GetOrderFacetsParams(dbFilter))
+	if err != nil {
+		return f, fmt.Errorf("q.GetOrderFacets: %w", mapDBError(err))
+	}
+
+	facets, err := mapGetOrderFacetsRowsToDomain(rows)
+	if err != nil {
+		return f, fmt.Errorf("mapGetOrderFacetsRowsToDomain: %w", err)
+	}
+
+	return facets, nil
+}
+
+// groupSearchOrdersRows folds the row-per-item JOIN into orders, keeping the order in which rows were returned.
+func groupSearchOrdersRows(rows []db.SearchOrdersRow) ([]domain.Order, error) {
+	var orders []domain.Order
+	orderIndex := make(map[uuid.UUID]int)
+
+	for _, row := range rows {
+		idx, exists := orderIndex[row.ID]
+		if !exists {
 			order, err := mapSearchOrdersRowToDomainOrder(row)
 			if err != nil {
 				return nil, fmt.Errorf("mapSearchOrdersRowToDomainOrder: %w", err)
 			}
-			orderMap[row.ID] = order
+
+			idx = len(orders)
+			orderIndex[row.ID] = idx
+			orders = append(orders, order)
 		}
 
 		item, err := mapSearchOrdersRowToDomainOrderItem(row)
@@ -220,12 +477,10 @@ func (r *orderRepository) SearchOrders(ctx context.Context, filter domain.OrderF
 			return nil, fmt.Errorf("mapSearchOrdersRowToDomainOrderItem: %w", err)
 		}
 
-		order := orderMap[row.ID]
-		order.Items = append(order.Items, item)
-		orderMap[row.ID] = order
+		orders[idx].Items = append(orders[idx].Items, item)
 	}
 
-	return lo.Values(orderMap), nil
+	return orders, nil
 }
 
 func (r *orderRepository) DeleteOrder(ctx context.Context, orderID uuid.UUID) error {
@@ -234,7 +489,7 @@ func (r *orderRepository) DeleteOrder(ctx context.Context, orderID uuid.UUID) er
 	}
 
 	zero := struct{}{}
-	_, err := withTx(ctx, r.dbtx, func(q *db.Queries) (struct{}, error) {
+	_, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (struct{}, error) {
 		cmdTag, err := q.DeleteOrderItems(ctx, orderID)
 		if err != nil {
 			return zero, fmt.Errorf("q.DeleteOrderItems: %w", err)
@@ -253,12 +508,21 @@ func (r *orderRepository) DeleteOrder(ctx context.Context, orderID uuid.UUID) er
 			return zero, fmt.Errorf("q.DeleteOrder: %w", ErrNotFound)
 		}
 
+		if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventDeleted, domain.OrderDeletedPayload{
+			OrderID: orderID,
+			Hard:    true,
+		}); err != nil {
+			return zero, fmt.Errorf("emitOrderEvent: %w", err)
+		}
+
 		return zero, nil
-	})
+	}, r.txOpts...)
 	if err != nil {
 		return fmt.Errorf("withTx: %w", err)
 	}
 
+	r.markWrite(ctx)
+
 	return nil
 }
 
@@ -267,14 +531,34 @@ func (r *orderRepository) SoftDeleteOrder(ctx context.Context, orderID uuid.UUID
 		return fmt.Errorf("orderID is empty")
 	}
 
-	cmdTag, err := r.q.SoftDeleteOrder(ctx, orderID)
+	zero := struct{}{}
+	_, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (struct{}, error) {
+		cmdTag, err := q.SoftDeleteOrder(ctx, orderID)
+		if err != nil {
+			return zero, fmt.Errorf("q.SoftDeleteOrder: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return zero, fmt.Errorf("q.SoftDeleteOrder: %w", ErrNotFound)
+		}
+
+		if err := recordOrderChange(ctx, q, orderID, nil, domain.OrderChangeDeleted, domain.OrderStateActive, domain.OrderStateDeleted); err != nil {
+			return zero, fmt.Errorf("recordOrderChange: %w", err)
+		}
+
+		if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventDeleted, domain.OrderDeletedPayload{
+			OrderID: orderID,
+		}); err != nil {
+			return zero, fmt.Errorf("emitOrderEvent: %w", err)
+		}
+
+		return zero, nil
+	}, r.txOpts...)
 	if err != nil {
-		return fmt.Errorf("q.SoftDeleteOrder: %w", err)
+		return fmt.Errorf("withTx: %w", err)
 	}
 
-	if cmdTag.RowsAffected() == 0 {
-		return fmt.Errorf("q.SoftDeleteOrder: %w", ErrNotFound)
-	}
+	r.markWrite(ctx)
 
 	return nil
 }
@@ -288,7 +572,7 @@ func (r *orderRepository) SoftDeleteOrderItem(ctx context.Context, orderID, prod
 	}
 
 	zero := struct{}{}
-	_, err := withTx(ctx, r.dbtx, func(q *db.Queries) (struct{}, error) {
+	_, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (struct{}, error) {
 		cmdTag, err := q.SoftDeleteOrderItem(ctx, db.SoftDeleteOrderItemParams{
 			OrderID:   orderID,
 			ProductID: productID,
@@ -310,12 +594,154 @@ func (r *orderRepository) SoftDeleteOrderItem(ctx context.Context, orderID, prod
 			return zero, fmt.Errorf("q.UpdateOrderPrice: %w", ErrNotFound)
 		}
 
+		if err := recordOrderChange(ctx, q, orderID, &productID, domain.OrderChangeItemDeleted, domain.OrderStateActive, domain.OrderStateDeleted); err != nil {
+			return zero, fmt.Errorf("recordOrderChange: %w", err)
+		}
+
+		if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventItemRemoved, domain.OrderItemRemovedPayload{
+			OrderID:   orderID,
+			ProductID: productID,
+		}); err != nil {
+			return zero, fmt.Errorf("emitOrderEvent: %w", err)
+		}
+
 		return zero, nil
-	})
+	}, r.txOpts...)
+	if err != nil {
+		return fmt.Errorf("withTx: %w", err)
+	}
+
+	r.markWrite(ctx)
+
+	return nil
+}
+
+// RestoreOrder undoes SoftDeleteOrder, the price is recomputed from the items which are not deleted.
+func (r *orderRepository) RestoreOrder(ctx context.Context, orderID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	zero := struct{}{}
+	_, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (struct{}, error) {
+		cmdTag, err := q.RestoreOrder(ctx, orderID)
+		if err != nil {
+			return zero, fmt.Errorf("q.RestoreOrder: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return zero, fmt.Errorf("q.RestoreOrder: %w", ErrNotFound)
+		}
+
+		if err := recordOrderChange(ctx, q, orderID, nil, domain.OrderChangeRestored, domain.OrderStateDeleted, domain.OrderStateActive); err != nil {
+			return zero, fmt.Errorf("recordOrderChange: %w", err)
+		}
+
+		if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventRestored, domain.OrderRestoredPayload{
+			OrderID: orderID,
+		}); err != nil {
+			return zero, fmt.Errorf("emitOrderEvent: %w", err)
+		}
+
+		return zero, nil
+	}, r.txOpts...)
+	if err != nil {
+		return fmt.Errorf("withTx: %w", err)
+	}
+
+	r.markWrite(ctx)
+
+	return nil
+}
+
+// RestoreOrderItem undoes SoftDeleteOrderItem and recomputes the order price, the order itself must not be deleted.
+func (r *orderRepository) RestoreOrderItem(ctx context.Context, orderID, productID uuid.UUID) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+	if productID == uuid.Nil {
+		return fmt.Errorf("productID is empty")
+	}
+
+	zero := struct{}{}
+	_, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (struct{}, error) {
+		cmdTag, err := q.RestoreOrderItem(ctx, db.RestoreOrderItemParams{
+			OrderID:   orderID,
+			ProductID: productID,
+		})
+		if err != nil {
+			return zero, fmt.Errorf("q.RestoreOrderItem: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return zero, fmt.Errorf("q.RestoreOrderItem: %w", ErrNotFound)
+		}
+
+		cmdTag, err = q.UpdateOrderPrice(ctx, orderID)
+		if err != nil {
+			return zero, fmt.Errorf("q.UpdateOrderPrice: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return zero, fmt.Errorf("q.UpdateOrderPrice: %w", ErrNotFound)
+		}
+
+		if err := recordOrderChange(ctx, q, orderID, &productID, domain.OrderChangeItemRestored, domain.OrderStateDeleted, domain.OrderStateActive); err != nil {
+			return zero, fmt.Errorf("recordOrderChange: %w", err)
+		}
+
+		if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventItemRestored, domain.OrderItemRestoredPayload{
+			OrderID:   orderID,
+			ProductID: productID,
+		}); err != nil {
+			return zero, fmt.Errorf("emitOrderEvent: %w", err)
+		}
+
+		return zero, nil
+	}, r.txOpts...)
 	if err != nil {
 		return fmt.Errorf("withTx: %w", err)
 	}
 
+	r.markWrite(ctx)
+
+	return nil
+}
+
+func (r *orderRepository) GetOrderHistory(ctx context.Context, orderID uuid.UUID) ([]domain.OrderHistoryEntry, error) {
+	if orderID == uuid.Nil {
+		return nil, fmt.Errorf("orderID is empty")
+	}
+
+	dbEvents, err := r.queries(ctx).GetOrderEvents(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetOrderEvents: %w", mapDBError(err))
+	}
+
+	entries := make([]domain.OrderHistoryEntry, 0, len(dbEvents))
+	for _, dbEvent := range dbEvents {
+		entries = append(entries, mapDBOrderEventToDomain(dbEvent))
+	}
+
+	return entries, nil
+}
+
+// recordOrderChange writes an order history entry, it must run in the transaction of the change itself.
+func recordOrderChange(ctx context.Context, q *db.Queries, orderID uuid.UUID, productID *uuid.UUID, changeType domain.OrderChangeType, oldValue, newValue string) error {
+	audit := domain.AuditInfoFromContext(ctx)
+
+	if err := q.InsertOrderEvent(ctx, db.InsertOrderEventParams{
+		OrderID:   orderID,
+		ProductID: productID,
+		EventType: string(changeType),
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		Actor:     audit.Actor,
+		Reason:    audit.Reason,
+	}); err != nil {
+		return fmt.Errorf("q.InsertOrderEvent: %w", err)
+	}
+
 	return nil
 }
 
@@ -385,6 +811,7 @@ func mapDBOrderToDomain(dbOrder db.GetOrderRow, dbOrderItems []db.GetOrderItemsR
 		Tags:      dbOrder.Tags,
 		Payload:   dbOrder.Payload,
 		PayloadB:  dbOrder.Payloadb,
+		Version:   dbOrder.Version,
 		Price: domain.Money{
 			Amount:   dbOrder.PriceAmount,
 			Currency: parsedCurrency,
@@ -426,6 +853,7 @@ func mapGetOrderJoinItemsRowToDomainOrder(row db.GetOrderJoinItemsRow) (domain.O
 		Tags:      row.Tags,
 		Payload:   row.Payload,
 		PayloadB:  row.Payloadb,
+		Version:   row.Version,
 		Price: domain.Money{
 			Amount:   row.PriceAmount,
 			Currency: parsedCurrency,
@@ -478,8 +906,10 @@ func mapSearchOrdersRowToDomainOrder(row db.SearchOrdersRow) (domain.Order, erro
 		Tags:      row.Tags,
 		Payload:   row.Payload,
 		PayloadB:  row.Payloadb,
+		Version:   row.Version,
 		CreatedAt: row.CreatedAt,
 		UpdatedAt: row.UpdatedAt,
+		DeletedAt: row.DeletedAt,
 		Price: domain.Money{
 			Amount:   row.PriceAmount,
 			Currency: parsedCurrency,
@@ -499,13 +929,63 @@ func mapSearchOrdersRowToDomainOrderItem(row db.SearchOrdersRow) (domain.OrderIt
 	}, nil
 }
 
+func mapGetOrderFacetsRowsToDomain(rows []db.GetOrderFacetsRow) (domain.OrderFacets, error) {
+	facets := domain.OrderFacets{
+		Statuses:   make(map[domain.OrderStatus]int64),
+		Currencies: make(map[currency.Unit]int64),
+		Tags:       make(map[string]int64),
+		Owners:     make(map[string]int64),
+		PriceSums:  make(map[currency.Unit]decimal.Decimal),
+	}
+
+	for _, row := range rows {
+		switch row.Facet {
+		case "status":
+			status, err := domain.ToOrderStatus(row.Value)
+			if err != nil {
+				return facets, fmt.Errorf("domain.ToOrderStatus[%s]: %w", row.Value, err)
+			}
+			facets.Statuses[status] = row.OrderCount
+		case "currency":
+			parsedCurrency, err := currency.ParseISO(row.Value)
+			if err != nil {
+				return facets, fmt.Errorf("currency.ParseISO[%s]: %w", row.Value, err)
+			}
+			facets.Currencies[parsedCurrency] = row.OrderCount
+			facets.PriceSums[parsedCurrency] = row.PriceSum
+		case "tag":
+			facets.Tags[row.Value] = row.OrderCount
+		case "owner":
+			facets.Owners[row.Value] = row.OrderCount
+		default:
+			return facets, fmt.Errorf("unknown facet[%s]", row.Facet)
+		}
+	}
+
+	return facets, nil
+}
+
+func mapDBOrderEventToDomain(dbEvent db.OrderEvent) domain.OrderHistoryEntry {
+	return domain.OrderHistoryEntry{
+		ID:        dbEvent.ID,
+		OrderID:   dbEvent.OrderID,
+		ProductID: dbEvent.ProductID,
+		Type:      domain.OrderChangeType(dbEvent.EventType),
+		OldValue:  dbEvent.OldValue,
+		NewValue:  dbEvent.NewValue,
+		Actor:     dbEvent.Actor,
+		Reason:    dbEvent.Reason,
+		CreatedAt: dbEvent.CreatedAt,
+	}
+}
+
 func mapDomainOrderFilterToSearchOrdersParams(filter domain.OrderFilter) db.SearchOrdersParams {
 	var statuses []string
 	for _, status := range filter.Statuses {
 		statuses = append(statuses, string(status))
 	}
 
-	var createdAfter, createdBefore, updatedAfter, updatedBefore *time.Time
+	var createdAfter, createdBefore, updatedAfter, updatedBefore, deletedAfter, deletedBefore *time.Time
 
 	if filter.CreatedAt != nil {
 		createdAfter = filter.CreatedAt.After
@@ -517,6 +997,11 @@ func mapDomainOrderFilterToSearchOrdersParams(filter domain.OrderFilter) db.Sear
 		updatedBefore = filter.UpdatedAt.Before
 	}
 
+	if filter.DeletedAt != nil {
+		deletedAfter = filter.DeletedAt.After
+		deletedBefore = filter.DeletedAt.Before
+	}
+
 	return db.SearchOrdersParams{
 		Ids:           nilSliceIfEmpty(filter.IDs),
 		OwnerIds:      nilSliceIfEmpty(filter.OwnerIDs),
@@ -527,21 +1012,29 @@ func mapDomainOrderFilterToSearchOrdersParams(filter domain.OrderFilter) db.Sear
 		CreatedBefore: createdBefore,
 		UpdatedAfter:  updatedAfter,
 		UpdatedBefore: updatedBefore,
+		DeletedMode:   string(filter.Deleted),
+		DeletedAfter:  deletedAfter,
+		DeletedBefore: deletedBefore,
 	}
 }
 
-func urlToString(u *url.URL) string {
-	if u == nil {
-		return ""
-	}
-	return u.String()
-}
-
-func emptyJSONIfNil(j []byte) []byte {
-	if j == nil {
-		return []byte(`{}`)
+func mapDomainOrderFilterToSearchOrdersPageParams(filter domain.OrderFilter) db.SearchOrdersPageParams {
+	params := mapDomainOrderFilterToSearchOrdersParams(filter)
+
+	return db.SearchOrdersPageParams{
+		Ids:           params.Ids,
+		OwnerIds:      params.OwnerIds,
+		UrlPatterns:   params.UrlPatterns,
+		Statuses:      params.Statuses,
+		Tags:          params.Tags,
+		CreatedAfter:  params.CreatedAfter,
+		CreatedBefore: params.CreatedBefore,
+		UpdatedAfter:  params.UpdatedAfter,
+		UpdatedBefore: params.UpdatedBefore,
+		DeletedMode:   params.DeletedMode,
+		DeletedAfter:  params.DeletedAfter,
+		DeletedBefore: params.DeletedBefore,
 	}
-	return j
 }
 
 func nilSliceIfEmpty[T any](s []T) []T {
@@ -1,9 +1,9 @@
 package repository_test
 
 import (
-	"encoding/json"
-	"net/url"
-	"sort"
+	"bytes"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -11,10 +11,12 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nikolayk812/sqlcpp/internal/domain"
 	"github.com/nikolayk812/sqlcpp/internal/port"
 	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/nikolayk812/sqlcpp/internal/repository/repositorytest"
 	"github.com/samber/lo"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
@@ -26,8 +28,9 @@ import (
 type orderRepositorySuite struct {
 	suite.Suite
 
-	repo port.OrderRepository
-	pool *pgxpool.Pool
+	repo    port.OrderRepository
+	pool    *pgxpool.Pool
+	connStr string
 }
 
 // entry point to run the tests in the suite
@@ -41,6 +44,7 @@ func (suite *orderRepositorySuite) SetupSuite() {
 
 	_, connStr, err := startPostgres(ctx)
 	suite.NoError(err)
+	suite.connStr = connStr
 
 	suite.pool, err = pgxpool.New(ctx, connStr)
 	suite.NoError(err)
@@ -56,61 +60,73 @@ func (suite *orderRepositorySuite) TearDownSuite() {
 	}
 }
 
-func (suite *orderRepositorySuite) TestInsertOrder() {
+func (suite *orderRepositorySuite) TestConformance() {
+	repositorytest.Run(suite.T(), func(t *testing.T) port.OrderRepository {
+		suite.deleteAll()
+		t.Cleanup(suite.deleteAll)
+
+		return suite.repo
+	})
+}
+
+func (suite *orderRepositorySuite) TestInsertOrder_ID() {
 	defer suite.deleteAll()
 
-	tests := []struct {
-		name       string
-		buildOrder func() domain.Order
-		wantError  string
-	}{
-		{
-			name:       "valid order with all fields: ok",
-			buildOrder: randomOrder,
-		},
-		{
-			name: "invalid order, no items: fail",
-			buildOrder: func() domain.Order {
-				o := randomOrder()
-				o.Items = nil
-				return o
-			},
-			wantError: "no items in order",
-		},
-		{
-			name: "valid order, nil tags, nil url: ok",
-			buildOrder: func() domain.Order {
-				o := randomOrder()
-				o.Tags = nil
-				o.Url = nil
-				return o
-			},
-		},
-	}
+	suite.Run("no ID: UUIDv7 generated", func() {
+		t := suite.T()
 
-	for _, tt := range tests {
-		suite.Run(tt.name, func() {
-			t := suite.T()
-			ctx := t.Context()
+		orderID, err := suite.repo.InsertOrder(t.Context(), randomOrder())
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Version(7), orderID.Version())
+	})
 
-			ttOrder := tt.buildOrder()
+	suite.Run("caller-provided ID: ok", func() {
+		t := suite.T()
+		ctx := t.Context()
 
-			orderID, err := suite.repo.InsertOrder(ctx, ttOrder)
-			if tt.wantError != "" {
-				require.EqualError(t, err, tt.wantError)
-				return
-			}
+		order := randomOrder()
+		order.ID = uuid.MustParse(gofakeit.UUID())
 
-			actualOrder, err := suite.repo.GetOrder(ctx, orderID)
-			require.NoError(t, err)
+		orderID, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+		assert.Equal(t, order.ID, orderID)
 
-			expected := ttOrder
-			expected.ID = orderID
-			expected.Status = domain.OrderStatusPending
+		actual, err := suite.repo.GetOrder(ctx, order.ID)
+		require.NoError(t, err)
+		assertOrder(t, order, actual)
+	})
 
-			assertOrder(t, expected, actualOrder)
-		})
-	}
+	suite.Run("duplicate ID: already exists", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		order.ID = uuid.MustParse(gofakeit.UUID())
+
+		_, err := suite.repo.InsertOrder(ctx, order)
+		require.NoError(t, err)
+
+		_, err = suite.repo.InsertOrder(ctx, randomOrderWithID(order.ID))
+		require.ErrorIs(t, err, repository.ErrAlreadyExists)
+		require.EqualError(t, err, "withTx: resolveInsertConflict: order already exists")
+
+		results, err := suite.repo.InsertOrders(ctx, []domain.Order{randomOrderWithID(order.ID), randomOrder()})
+		require.NoError(t, err)
+		require.ErrorIs(t, results[0].Err, repository.ErrAlreadyExists)
+		require.NoError(t, results[1].Err)
+	})
+
+	suite.Run("duplicate ID within an import: already exists", func() {
+		t := suite.T()
+
+		id := uuid.MustParse(gofakeit.UUID())
+
+		results, err := suite.repo.InsertOrders(t.Context(), []domain.Order{randomOrderWithID(id), randomOrderWithID(id)})
+		require.NoError(t, err)
+		require.NoError(t, results[0].Err)
+		assert.Equal(t, id, results[0].ID)
+		require.ErrorIs(t, results[1].Err, repository.ErrAlreadyExists)
+	})
 }
 
 func (suite *orderRepositorySuite) TestUpdateOrderStatus() {
@@ -122,6 +138,7 @@ func (suite *orderRepositorySuite) TestUpdateOrderStatus() {
 		newStatus    domain.OrderStatus
 		arrangeState func(uuid.UUID) error // arrange state for test case before the operation, i.e. soft-delete the order
 		useOrderID   func() uuid.UUID      // override which order ID to use, if nil use the inserted one
+		useVersion   func(int64) int64     // override which expected version to use, if nil use the current one
 		wantError    string
 	}{
 		{
@@ -136,7 +153,7 @@ func (suite *orderRepositorySuite) TestUpdateOrderStatus() {
 			useOrderID: func() uuid.UUID {
 				return uuid.MustParse(gofakeit.UUID())
 			},
-			wantError: "q.UpdateOrderStatus: order not found",
+			wantError: "withTx: q.UpdateOrderStatus: order not found",
 		},
 		{
 			name:       "update status with empty order ID: error",
@@ -153,6 +170,83 @@ func (suite *orderRepositorySuite) TestUpdateOrderStatus() {
 			newStatus:  "",
 			wantError:  "status is empty",
 		},
+		{
+			name:       "update status with unknown status: error",
+			buildOrder: randomOrder,
+			newStatus:  "lost",
+			wantError:  "domain.ToOrderStatus[lost]: invalid order status",
+		},
+		{
+			name:       "cancel pending order: ok",
+			buildOrder: randomOrder,
+			newStatus:  domain.OrderStatusCancelled,
+		},
+		{
+			name:       "deliver shipped order: ok",
+			buildOrder: randomOrder,
+			newStatus:  domain.OrderStatusDelivered,
+			arrangeState: func(u uuid.UUID) error {
+				return suite.repo.UpdateOrderStatus(suite.T().Context(), u, domain.OrderStatusShipped, 1)
+			},
+		},
+		{
+			name:       "deliver pending order: invalid transition",
+			buildOrder: randomOrder,
+			newStatus:  domain.OrderStatusDelivered,
+			wantError:  "withTx: order status transition from[pending] to[delivered] is not allowed",
+		},
+		{
+			name:       "move delivered order back to pending: invalid transition",
+			buildOrder: randomOrder,
+			newStatus:  domain.OrderStatusPending,
+			arrangeState: func(u uuid.UUID) error {
+				ctx := suite.T().Context()
+				if err := suite.repo.UpdateOrderStatus(ctx, u, domain.OrderStatusShipped, 1); err != nil {
+					return err
+				}
+				return suite.repo.UpdateOrderStatus(ctx, u, domain.OrderStatusDelivered, 2)
+			},
+			wantError: "withTx: order status transition from[delivered] to[pending] is not allowed",
+		},
+		{
+			name:       "ship pending order twice: invalid transition",
+			buildOrder: randomOrder,
+			newStatus:  domain.OrderStatusShipped,
+			arrangeState: func(u uuid.UUID) error {
+				return suite.repo.UpdateOrderStatus(suite.T().Context(), u, domain.OrderStatusShipped, 1)
+			},
+			wantError: "withTx: order status transition from[shipped] to[shipped] is not allowed",
+		},
+		{
+			name:       "update status with stale version: concurrent modification",
+			buildOrder: randomOrder,
+			newStatus:  domain.OrderStatusDelivered,
+			arrangeState: func(u uuid.UUID) error {
+				return suite.repo.UpdateOrderStatus(suite.T().Context(), u, domain.OrderStatusShipped, 1)
+			},
+			useVersion: func(current int64) int64 {
+				return current - 1
+			},
+			wantError: "withTx: q.UpdateOrderStatus: order was modified concurrently",
+		},
+		{
+			name:       "update status with future version: concurrent modification",
+			buildOrder: randomOrder,
+			newStatus:  domain.OrderStatusShipped,
+			useVersion: func(current int64) int64 {
+				return current + 1
+			},
+			wantError: "withTx: q.UpdateOrderStatus: order was modified concurrently",
+		},
+		{
+			name:       "update status with zero version: error",
+			buildOrder: randomOrder,
+			newStatus:  domain.OrderStatusShipped,
+			useVersion: func(int64) int64 {
+				return 0
+			},
+			wantError: "expectedVersion must be positive",
+		},
 		{
 			name:       "update status of soft-deleted order: not found",
 			buildOrder: randomOrder,
@@ -160,7 +254,7 @@ func (suite *orderRepositorySuite) TestUpdateOrderStatus() {
 			arrangeState: func(u uuid.UUID) error {
 				return suite.repo.SoftDeleteOrder(suite.T().Context(), u)
 			},
-			wantError: "q.UpdateOrderStatus: order not found",
+			wantError: "withTx: q.UpdateOrderStatus: order not found",
 		},
 		{
 			name:       "update status of deleted order: not found",
@@ -169,7 +263,7 @@ func (suite *orderRepositorySuite) TestUpdateOrderStatus() {
 			arrangeState: func(u uuid.UUID) error {
 				return suite.repo.DeleteOrder(suite.T().Context(), u)
 			},
-			wantError: "q.UpdateOrderStatus: order not found",
+			wantError: "withTx: q.UpdateOrderStatus: order not found",
 		},
 	}
 
@@ -195,8 +289,13 @@ func (suite *orderRepositorySuite) TestUpdateOrderStatus() {
 				targetOrderID = tt.useOrderID()
 			}
 
+			expectedVersion := suite.orderVersion(orderID)
+			if tt.useVersion != nil {
+				expectedVersion = tt.useVersion(expectedVersion)
+			}
+
 			// Perform the status update
-			err = suite.repo.UpdateOrderStatus(ctx, targetOrderID, tt.newStatus)
+			err = suite.repo.UpdateOrderStatus(ctx, targetOrderID, tt.newStatus, expectedVersion)
 			if tt.wantError != "" {
 				require.EqualError(t, err, tt.wantError)
 				return
@@ -210,10 +309,71 @@ func (suite *orderRepositorySuite) TestUpdateOrderStatus() {
 			expected.Status = tt.newStatus
 
 			assertOrder(t, expected, updatedOrder)
+			assert.Equal(t, tt.newStatus, updatedOrder.Status)
+			assert.Equal(t, expectedVersion+1, updatedOrder.Version)
 		})
 	}
 }
 
+func (suite *orderRepositorySuite) TestUpdateOrderStatus_InvalidTransitionError() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	orderIDs := suite.insertOrders(randomOrder())
+
+	err := suite.repo.UpdateOrderStatus(ctx, orderIDs[0], domain.OrderStatusCancelled, 1)
+	require.NoError(t, err)
+
+	err = suite.repo.UpdateOrderStatus(ctx, orderIDs[0], domain.OrderStatusShipped, 2)
+	require.ErrorIs(t, err, domain.ErrInvalidTransition)
+
+	var transitionErr *domain.InvalidTransitionError
+	require.ErrorAs(t, err, &transitionErr)
+	assert.Equal(t, domain.OrderStatusCancelled, transitionErr.From)
+	assert.Equal(t, domain.OrderStatusShipped, transitionErr.To)
+
+	order, err := suite.repo.GetOrder(ctx, orderIDs[0])
+	require.NoError(t, err)
+	assert.Equal(t, domain.OrderStatusCancelled, order.Status)
+}
+
+func (suite *orderRepositorySuite) TestOrderVersion() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	order := randomOrder()
+	orderID := suite.insertOrders(order)[0]
+
+	require.NoError(t, suite.repo.UpdateOrderStatus(ctx, orderID, domain.OrderStatusShipped, 1))
+	require.NoError(t, suite.repo.SoftDeleteOrderItem(ctx, orderID, order.Items[0].ProductID))
+
+	const wantVersion = 3
+
+	actual, err := suite.repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(wantVersion), actual.Version)
+
+	actual, err = suite.repo.GetOrderSeparateQueries(ctx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(wantVersion), actual.Version)
+
+	orders, err := suite.repo.SearchOrders(ctx, domain.OrderFilter{IDs: []uuid.UUID{orderID}})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, int64(wantVersion), orders[0].Version)
+
+	// the stale version of the first status update is rejected
+	err = suite.repo.UpdateOrderStatus(ctx, orderID, domain.OrderStatusDelivered, 1)
+	require.ErrorIs(t, err, repository.ErrConcurrentModification)
+
+	require.NoError(t, suite.repo.SoftDeleteOrder(ctx, orderID))
+	assert.Equal(t, int64(wantVersion+1), suite.orderVersion(orderID))
+}
+
 func (suite *orderRepositorySuite) TestGetOrderSeparateQueries() {
 	defer suite.deleteAll()
 
@@ -273,153 +433,163 @@ func (suite *orderRepositorySuite) TestGetOrderSeparateQueries() {
 	}
 }
 
-func (suite *orderRepositorySuite) TestSearchOrders() {
+func (suite *orderRepositorySuite) TestSearchOrdersPage() {
 	defer suite.deleteAll()
 
-	order1 := randomOrder()
-	order2 := randomOrder()
-	orderIDs := suite.insertOrders(order1, order2)
+	orders := make([]domain.Order, 0, 5)
+	for i := 0; i < 5; i++ {
+		orders = append(orders, randomOrder())
+	}
+	orderIDs := suite.insertOrders(orders...)
+
+	insertedByID := make(map[uuid.UUID]domain.Order, len(orders))
+	for i, id := range orderIDs {
+		insertedByID[id] = orders[i]
+	}
+
+	filter := domain.OrderFilter{IDs: orderIDs}
+
+	sortKeys := []domain.OrderSortKey{
+		domain.OrderSortByCreatedAt,
+		domain.OrderSortByUpdatedAt,
+		domain.OrderSortByPriceAmount,
+		domain.OrderSortByID,
+	}
+
+	for _, sortKey := range sortKeys {
+		suite.Run(fmt.Sprintf("page through by %s: ok", sortKey), func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			var (
+				actual []domain.Order
+				pages  int
+				cursor string
+			)
+
+			for {
+				page, err := suite.repo.SearchOrdersPage(ctx, filter, domain.PageRequest{
+					Size:    2,
+					SortKey: sortKey,
+					Cursor:  cursor,
+				})
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(page.Orders), 2)
+
+				actual = append(actual, page.Orders...)
+				pages++
+
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+
+			assert.Equal(t, 3, pages)
+			require.Len(t, actual, len(orders))
+
+			for i, order := range actual {
+				expected, ok := insertedByID[order.ID]
+				require.True(t, ok, "unexpected order %s", order.ID)
+				assertOrder(t, expected, order)
+
+				if i > 0 {
+					assert.True(t, sortedBefore(actual[i-1], order, sortKey), "orders %d and %d are out of order", i-1, i)
+				}
+			}
+		})
+	}
 
 	tests := []struct {
-		name       string
-		filter     domain.OrderFilter
-		wantOrders []domain.Order
-		wantError  string
+		name      string
+		filter    domain.OrderFilter
+		page      domain.PageRequest
+		wantError string
 	}{
 		{
 			name:      "empty filter: error",
 			filter:    domain.OrderFilter{},
+			page:      domain.PageRequest{Size: 2, SortKey: domain.OrderSortByID},
 			wantError: "filter.Validate: all fields are empty",
 		},
 		{
-			name: "search by ids: 1 found",
-			filter: domain.OrderFilter{
-				IDs: []uuid.UUID{orderIDs[0]},
-			},
-			wantOrders: []domain.Order{order1},
-		},
-		{
-			name: "search by ids: 2 found",
-			filter: domain.OrderFilter{
-				IDs: []uuid.UUID{orderIDs[0], orderIDs[1]},
-			},
-			wantOrders: []domain.Order{order1, order2},
-		},
-		{
-			name: "search by ids: not found",
-			filter: domain.OrderFilter{
-				IDs: []uuid.UUID{uuid.MustParse(gofakeit.UUID())},
-			},
-		},
-		{
-			name: "search by owner ids: 1 found",
-			filter: domain.OrderFilter{
-				OwnerIDs: []string{order1.OwnerID},
-			},
-			wantOrders: []domain.Order{order1},
-		},
-		{
-			name: "search by owner ids: 2 found",
-			filter: domain.OrderFilter{
-				OwnerIDs: []string{order1.OwnerID, order2.OwnerID},
-			},
-			wantOrders: []domain.Order{order1, order2},
-		},
-		{
-			name: "search by owner ids: not found",
-			filter: domain.OrderFilter{
-				OwnerIDs: []string{"not found"},
-			},
-		},
-		{
-			name: "search by URL patterns: 1 found",
-			filter: domain.OrderFilter{
-				UrlPatterns: []string{order1.Url.String()},
-			},
-			wantOrders: []domain.Order{order1},
-		},
-		{
-			name: "search by URL patterns: not found",
-			filter: domain.OrderFilter{
-				UrlPatterns: []string{"not found"},
-			},
-		},
-		{
-			name: "search by status pending: 2 found",
-			filter: domain.OrderFilter{
-				Statuses: []domain.OrderStatus{domain.OrderStatusPending},
-			},
-			wantOrders: []domain.Order{order1, order2},
+			name:      "zero page size: error",
+			filter:    filter,
+			page:      domain.PageRequest{SortKey: domain.OrderSortByID},
+			wantError: "page.Validate: size must be positive",
 		},
 		{
-			name: "search by status shipped: not found",
-			filter: domain.OrderFilter{
-				Statuses: []domain.OrderStatus{domain.OrderStatusShipped},
-			},
+			name:      "unknown sort key: error",
+			filter:    filter,
+			page:      domain.PageRequest{Size: 2, SortKey: "owner_id"},
+			wantError: "page.Validate: invalid sort key[owner_id]",
 		},
 		{
-			name: "search by tags: 1 found",
-			filter: domain.OrderFilter{
-				Tags: []string{order1.Tags[0]},
-			},
-			wantOrders: []domain.Order{order1},
-		},
-		{
-			name: "search by tags: not found",
-			filter: domain.OrderFilter{
-				Tags: []string{"not found"},
-			},
-		},
-		{
-			name: "search by createdAt after: 2 found",
-			filter: domain.OrderFilter{
-				CreatedAt: lo.ToPtr(domain.TimeRange{
-					After: lo.ToPtr(time.Now().UTC().Add(-1 * time.Minute)),
-				}),
-			},
-			wantOrders: []domain.Order{order1, order2},
-		},
-		{
-			name: "search by createdAt after: not found",
-			filter: domain.OrderFilter{
-				CreatedAt: lo.ToPtr(domain.TimeRange{
-					After: lo.ToPtr(time.Now().UTC().Add(1 * time.Minute)),
-				}),
-			},
+			name:      "malformed cursor: error",
+			filter:    filter,
+			page:      domain.PageRequest{Size: 2, SortKey: domain.OrderSortByID, Cursor: "not a cursor"},
+			wantError: "decodeOrderCursor: cursor is malformed: illegal base64 data at input byte 3",
 		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			_, err := suite.repo.SearchOrdersPage(t.Context(), tt.filter, tt.page)
+			require.EqualError(t, err, tt.wantError)
+		})
+	}
+
+	suite.Run("cursor of another sort key: error", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		page, err := suite.repo.SearchOrdersPage(ctx, filter, domain.PageRequest{Size: 2, SortKey: domain.OrderSortByID})
+		require.NoError(t, err)
+		require.NotEmpty(t, page.NextCursor)
+
+		_, err = suite.repo.SearchOrdersPage(ctx, filter, domain.PageRequest{
+			Size:    2,
+			SortKey: domain.OrderSortByCreatedAt,
+			Cursor:  page.NextCursor,
+		})
+		require.EqualError(t, err, "cursor.applyTo: cursor sort key[id] does not match sort key[created_at]")
+	})
+}
+
+func (suite *orderRepositorySuite) TestCountOrders() {
+	defer suite.deleteAll()
+
+	order1 := randomOrder()
+	order2 := randomOrder()
+	orderIDs := suite.insertOrders(order1, order2)
+
+	tests := []struct {
+		name      string
+		filter    domain.OrderFilter
+		wantCount int64
+		wantError string
+	}{
 		{
-			name: "search by createdAt before: not found",
-			filter: domain.OrderFilter{
-				CreatedAt: lo.ToPtr(domain.TimeRange{
-					Before: lo.ToPtr(time.Now().UTC().Add(-1 * time.Minute)),
-				}),
-			},
+			name:      "empty filter: error",
+			filter:    domain.OrderFilter{},
+			wantError: "filter.Validate: all fields are empty",
 		},
 		{
-			name: "search by createdAt before: 2 found",
-			filter: domain.OrderFilter{
-				CreatedAt: lo.ToPtr(domain.TimeRange{
-					Before: lo.ToPtr(time.Now().UTC().Add(1 * time.Minute)),
-				}),
-			},
-			wantOrders: []domain.Order{order1, order2},
+			name:      "count by ids: 2 found",
+			filter:    domain.OrderFilter{IDs: orderIDs},
+			wantCount: 2,
 		},
 		{
-			name: "search by createdAt empty: error",
-			filter: domain.OrderFilter{
-				CreatedAt: lo.ToPtr(domain.TimeRange{}),
-			},
-			wantError: "filter.Validate: createdAt: both Before and After are nil",
+			name:      "count by owner ids: 1 found",
+			filter:    domain.OrderFilter{OwnerIDs: []string{order1.OwnerID}},
+			wantCount: 1,
 		},
 		{
-			name: "search by createdAt before and after: 2 found",
-			filter: domain.OrderFilter{
-				CreatedAt: lo.ToPtr(domain.TimeRange{
-					Before: lo.ToPtr(time.Now().UTC().Add(1 * time.Minute)),
-					After:  lo.ToPtr(time.Now().UTC().Add(-1 * time.Minute)),
-				}),
-			},
-			wantOrders: []domain.Order{order1, order2},
+			name:      "count by status shipped: not found",
+			filter:    domain.OrderFilter{Statuses: []domain.OrderStatus{domain.OrderStatusShipped}},
+			wantCount: 0,
 		},
 	}
 
@@ -427,55 +597,98 @@ func (suite *orderRepositorySuite) TestSearchOrders() {
 		suite.Run(tt.name, func() {
 			t := suite.T()
 
-			orders, err := suite.repo.SearchOrders(t.Context(), tt.filter)
+			count, err := suite.repo.CountOrders(t.Context(), tt.filter)
 			if tt.wantError != "" {
 				require.EqualError(t, err, tt.wantError)
 				return
 			}
 			require.NoError(t, err)
 
-			assertOrders(t, tt.wantOrders, orders)
+			assert.Equal(t, tt.wantCount, count)
 		})
 	}
 }
 
-func (suite *orderRepositorySuite) TestDeleteOrder() {
+func (suite *orderRepositorySuite) TestGetOrderFacets() {
+	defer suite.deleteAll()
+
+	order1 := randomOrder()
+	order2 := randomOrder()
+	orderIDs := suite.insertOrders(order1, order2)
+
+	suite.Run("facets by ids: ok", func() {
+		t := suite.T()
+
+		facets, err := suite.repo.GetOrderFacets(t.Context(), domain.OrderFilter{IDs: orderIDs})
+		require.NoError(t, err)
+
+		expected := expectedFacets(order1, order2)
+
+		assert.Equal(t, expected.Statuses, facets.Statuses)
+		assert.Equal(t, expected.Tags, facets.Tags)
+		assert.Equal(t, expected.Owners, facets.Owners)
+		assert.Equal(t, expected.Currencies, facets.Currencies)
+
+		require.Len(t, facets.PriceSums, len(expected.PriceSums))
+		for unit, sum := range expected.PriceSums {
+			assert.True(t, sum.Equal(facets.PriceSums[unit]), "price sum of %s: want %s, got %s", unit, sum, facets.PriceSums[unit])
+		}
+	})
+
+	suite.Run("facets by owner ids: not found", func() {
+		t := suite.T()
+
+		facets, err := suite.repo.GetOrderFacets(t.Context(), domain.OrderFilter{OwnerIDs: []string{"not found"}})
+		require.NoError(t, err)
+
+		assert.Empty(t, facets.Statuses)
+		assert.Empty(t, facets.Currencies)
+		assert.Empty(t, facets.Tags)
+		assert.Empty(t, facets.Owners)
+		assert.Empty(t, facets.PriceSums)
+	})
+
+	suite.Run("empty filter: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.GetOrderFacets(t.Context(), domain.OrderFilter{})
+		require.EqualError(t, err, "filter.Validate: all fields are empty")
+	})
+}
+
+func (suite *orderRepositorySuite) TestRestoreOrder() {
 	defer suite.deleteAll()
 
 	tests := []struct {
 		name         string
-		buildOrder   func() domain.Order
-		arrangeState func(uuid.UUID) error // arrange state for test case before the operation, i.e. soft-delete the order
 		useOrderID   func() uuid.UUID      // override which order ID to use, if nil use the inserted one
+		arrangeState func(uuid.UUID) error // arrange state for test case before the operation, i.e. soft-delete the order
 		wantError    string
 	}{
 		{
-			name:       "delete existing order: ok",
-			buildOrder: randomOrder,
+			name: "restore soft-deleted order: ok",
+			arrangeState: func(orderID uuid.UUID) error {
+				return suite.repo.SoftDeleteOrder(suite.T().Context(), orderID)
+			},
 		},
 		{
-			name:       "delete non-existing order: not found",
-			buildOrder: randomOrder,
-			useOrderID: func() uuid.UUID {
-				return uuid.MustParse(gofakeit.UUID())
+			name:      "restore order which is not deleted: not found",
+			wantError: "withTx: q.RestoreOrder: order not found",
+		},
+		{
+			name: "restore hard-deleted order: not found",
+			arrangeState: func(orderID uuid.UUID) error {
+				return suite.repo.DeleteOrder(suite.T().Context(), orderID)
 			},
-			wantError: "withTx: q.DeleteOrderItems: order not found",
+			wantError: "withTx: q.RestoreOrder: order not found",
 		},
 		{
-			name:       "delete with empty order ID: error",
-			buildOrder: randomOrder,
+			name: "restore with empty order ID: error",
 			useOrderID: func() uuid.UUID {
 				return uuid.Nil
 			},
 			wantError: "orderID is empty",
 		},
-		{
-			name:       "delete soft-deleted order: ok",
-			buildOrder: randomOrder,
-			arrangeState: func(orderID uuid.UUID) error {
-				return suite.repo.SoftDeleteOrder(suite.T().Context(), orderID)
-			},
-		},
 	}
 
 	for _, tt := range tests {
@@ -483,281 +696,363 @@ func (suite *orderRepositorySuite) TestDeleteOrder() {
 			t := suite.T()
 			ctx := t.Context()
 
-			ttOrder := tt.buildOrder()
-			orderID, err := suite.repo.InsertOrder(ctx, ttOrder)
+			order := randomOrder()
+			orderID, err := suite.repo.InsertOrder(ctx, order)
 			require.NoError(t, err)
 
+			toRestoreOrderID := orderID
+			if tt.useOrderID != nil {
+				toRestoreOrderID = tt.useOrderID()
+			}
+
 			if tt.arrangeState != nil {
 				err := tt.arrangeState(orderID)
 				require.NoError(t, err)
 			}
 
-			toDeleteOrderID := orderID
-			if tt.useOrderID != nil {
-				toDeleteOrderID = tt.useOrderID()
-			}
+			versionBefore := suite.orderVersion(orderID)
 
-			err = suite.repo.DeleteOrder(ctx, toDeleteOrderID)
+			err = suite.repo.RestoreOrder(ctx, toRestoreOrderID)
 			if tt.wantError != "" {
 				require.EqualError(t, err, tt.wantError)
 				return
 			}
 			require.NoError(t, err)
 
-			// Verify the order is deleted
-			_, err = suite.repo.GetOrder(ctx, orderID)
-			require.EqualError(t, err, "order not found")
+			actual, err := suite.repo.GetOrder(ctx, orderID)
+			require.NoError(t, err)
+
+			assertOrder(t, order, actual)
+			assert.Equal(t, versionBefore+1, actual.Version)
 		})
 	}
 }
 
-func (suite *orderRepositorySuite) TestSoftDeleteOrder() {
+func (suite *orderRepositorySuite) TestRestoreOrderItem() {
 	defer suite.deleteAll()
 
+	suite.Run("restore soft-deleted item: ok", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		orderID := suite.insertOrders(order)[0]
+		productID := order.Items[0].ProductID
+
+		require.NoError(t, suite.repo.SoftDeleteOrderItem(ctx, orderID, productID))
+
+		err := suite.repo.RestoreOrderItem(ctx, orderID, productID)
+		require.NoError(t, err)
+
+		actual, err := suite.repo.GetOrder(ctx, orderID)
+		require.NoError(t, err)
+
+		// the price is back to the sum of all items
+		assertOrder(t, order, actual)
+		assert.Equal(t, int64(3), actual.Version)
+
+		history, err := suite.repo.GetOrderHistory(ctx, orderID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, domain.OrderChangeItemRestored, history[1].Type)
+		assert.Equal(t, &productID, history[1].ProductID)
+	})
+
+	suite.Run("restore item which is not deleted: not found", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		orderID := suite.insertOrders(order)[0]
+
+		err := suite.repo.RestoreOrderItem(ctx, orderID, order.Items[0].ProductID)
+		require.EqualError(t, err, "withTx: q.RestoreOrderItem: order not found")
+	})
+
+	suite.Run("restore item of soft-deleted order: not found", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		orderID := suite.insertOrders(order)[0]
+		productID := order.Items[0].ProductID
+
+		require.NoError(t, suite.repo.SoftDeleteOrderItem(ctx, orderID, productID))
+		require.NoError(t, suite.repo.SoftDeleteOrder(ctx, orderID))
+
+		err := suite.repo.RestoreOrderItem(ctx, orderID, productID)
+		require.EqualError(t, err, "withTx: q.UpdateOrderPrice: order not found")
+
+		// the item stays deleted when the order is restored
+		require.NoError(t, suite.repo.RestoreOrder(ctx, orderID))
+
+		actual, err := suite.repo.GetOrder(ctx, orderID)
+		require.NoError(t, err)
+		assert.NotContains(t, lo.Map(actual.Items, func(item domain.OrderItem, _ int) uuid.UUID {
+			return item.ProductID
+		}), productID)
+	})
+
+	suite.Run("restore with empty product ID: error", func() {
+		err := suite.repo.RestoreOrderItem(suite.T().Context(), uuid.MustParse(gofakeit.UUID()), uuid.Nil)
+		require.EqualError(suite.T(), err, "productID is empty")
+	})
+}
+
+func (suite *orderRepositorySuite) TestSearchOrders_Deleted() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	owner := gofakeit.UUID()
+
+	active, deleted := randomOrder(), randomOrder()
+	active.OwnerID, deleted.OwnerID = owner, owner
+
+	ids := suite.insertOrders(active, deleted)
+	activeID, deletedID := ids[0], ids[1]
+
+	require.NoError(t, suite.repo.SoftDeleteOrder(ctx, deletedID))
+
+	hourAgo := time.Now().Add(-time.Hour)
+
 	tests := []struct {
-		name         string
-		buildOrder   func() domain.Order
-		useOrderID   func() uuid.UUID      // override which order ID to use, if nil use the inserted one
-		arrangeState func(uuid.UUID) error // arrange state for test case before the operation, i.e. delete the order
-		wantError    string
+		name      string
+		filter    domain.OrderFilter
+		wantIDs   []uuid.UUID
+		wantError string
 	}{
 		{
-			name:       "soft-delete existing order: ok",
-			buildOrder: randomOrder,
+			name:    "exclude deleted by default",
+			filter:  domain.OrderFilter{OwnerIDs: []string{owner}},
+			wantIDs: []uuid.UUID{activeID},
 		},
 		{
-			name:       "soft-delete non-existing order: not found",
-			buildOrder: randomOrder,
-			useOrderID: func() uuid.UUID {
-				return uuid.MustParse(gofakeit.UUID())
-			},
-			wantError: "q.SoftDeleteOrder: order not found",
+			name:    "include deleted",
+			filter:  domain.OrderFilter{OwnerIDs: []string{owner}, Deleted: domain.IncludeDeleted},
+			wantIDs: []uuid.UUID{activeID, deletedID},
 		},
 		{
-			name:       "soft-delete with empty order ID: error",
-			buildOrder: randomOrder,
-			useOrderID: func() uuid.UUID {
-				return uuid.Nil
+			name:    "only deleted",
+			filter:  domain.OrderFilter{OwnerIDs: []string{owner}, Deleted: domain.OnlyDeleted},
+			wantIDs: []uuid.UUID{deletedID},
+		},
+		{
+			name:    "only deleted without other fields",
+			filter:  domain.OrderFilter{Deleted: domain.OnlyDeleted},
+			wantIDs: []uuid.UUID{deletedID},
+		},
+		{
+			name: "deleted at range",
+			filter: domain.OrderFilter{
+				DeletedAt: &domain.TimeRange{After: &hourAgo},
+				Deleted:   domain.IncludeDeleted,
 			},
-			wantError: "orderID is empty",
+			wantIDs: []uuid.UUID{deletedID},
 		},
 		{
-			name:       "soft-delete deleted order: not found",
-			buildOrder: randomOrder,
-			arrangeState: func(orderID uuid.UUID) error {
-				return suite.repo.DeleteOrder(suite.T().Context(), orderID)
+			name: "deleted at range which matches nothing",
+			filter: domain.OrderFilter{
+				DeletedAt: &domain.TimeRange{Before: &hourAgo},
+				Deleted:   domain.OnlyDeleted,
 			},
-			wantError: "q.SoftDeleteOrder: order not found",
+		},
+		{
+			name:      "deleted at without deleted mode: error",
+			filter:    domain.OrderFilter{DeletedAt: &domain.TimeRange{After: &hourAgo}},
+			wantError: "filter.Validate: deletedAt requires IncludeDeleted or OnlyDeleted",
+		},
+		{
+			name:      "invalid deleted mode: error",
+			filter:    domain.OrderFilter{OwnerIDs: []string{owner}, Deleted: "all"},
+			wantError: "filter.Validate: invalid deleted mode[all]",
 		},
 	}
 
 	for _, tt := range tests {
 		suite.Run(tt.name, func() {
 			t := suite.T()
-			ctx := t.Context()
-
-			// Insert the order if needed
-			ttOrder := tt.buildOrder()
-			orderID, err := suite.repo.InsertOrder(ctx, ttOrder)
-			require.NoError(t, err)
 
-			toDeleteOrderID := orderID
-			if tt.useOrderID != nil {
-				toDeleteOrderID = tt.useOrderID()
-			}
-
-			if tt.arrangeState != nil {
-				err := tt.arrangeState(orderID)
-				require.NoError(t, err)
-			}
-
-			err = suite.repo.SoftDeleteOrder(ctx, toDeleteOrderID)
+			orders, err := suite.repo.SearchOrders(ctx, tt.filter)
 			if tt.wantError != "" {
 				require.EqualError(t, err, tt.wantError)
 				return
 			}
 			require.NoError(t, err)
 
-			// Verify the order is soft-deleted
-			_, err = suite.repo.GetOrder(ctx, orderID)
-			require.EqualError(t, err, "order not found")
-		})
-	}
-}
+			actualIDs := lo.Map(orders, func(o domain.Order, _ int) uuid.UUID {
+				return o.ID
+			})
+			assert.ElementsMatch(t, tt.wantIDs, actualIDs)
 
-func (suite *orderRepositorySuite) insertOrders(orders ...domain.Order) []uuid.UUID {
-	ids := make([]uuid.UUID, 0, len(orders))
+			for _, o := range orders {
+				assert.Equal(t, o.ID == deletedID, o.DeletedAt != nil)
+			}
 
-	for _, order := range orders {
-		id, err := suite.repo.InsertOrder(suite.T().Context(), order)
-		suite.NoError(err)
-		ids = append(ids, id)
+			count, err := suite.repo.CountOrders(ctx, tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tt.wantIDs)), count)
+		})
 	}
-
-	return ids
 }
 
-func (suite *orderRepositorySuite) deleteAll() {
-	_, err := suite.pool.Exec(suite.T().Context(), "TRUNCATE TABLE orders, order_items CASCADE")
-	suite.NoError(err)
-}
+func (suite *orderRepositorySuite) TestGetOrderHistory() {
+	defer suite.deleteAll()
 
-func randomOrder() domain.Order {
-	currencyUnit := randomCurrency() // it has to be the same for all items
-	orderAmount := decimal.Zero
+	t := suite.T()
 
-	var items []domain.OrderItem
-	for i := 0; i < gofakeit.Number(1, 5); i++ {
-		orderItem := randomOrderItem()
-		orderItem.Price.Currency = currencyUnit
-		orderAmount = orderAmount.Add(orderItem.Price.Amount)
-		items = append(items, orderItem)
-	}
+	order := randomOrder()
+	orderID := suite.insertOrders(order)[0]
+	productID := order.Items[0].ProductID
 
-	var tags []string
-	for i := 0; i < gofakeit.Number(1, 3); i++ {
-		tags = append(tags, gofakeit.BeerName())
-	}
+	ctx := domain.WithAuditInfo(t.Context(), domain.AuditInfo{Actor: "warehouse", Reason: "picked up by courier"})
 
-	return domain.Order{
-		ID:       uuid.Nil,
-		OwnerID:  gofakeit.UUID(),
-		Items:    items,
-		Url:      randomURL(),
-		Tags:     tags,
-		Payload:  randomJson(),
-		PayloadB: randomJson(),
-		Price: domain.Money{
-			Amount:   orderAmount,
-			Currency: currencyUnit,
-		},
-	}
-}
+	err := suite.repo.UpdateOrderStatus(ctx, orderID, domain.OrderStatusShipped, 1)
+	require.NoError(t, err)
+
+	// a failed change is not recorded
+	err = suite.repo.UpdateOrderStatus(ctx, orderID, domain.OrderStatusPending, 2)
+	require.ErrorIs(t, err, domain.ErrInvalidTransition)
 
-func randomOrderItem() domain.OrderItem {
-	productID := uuid.MustParse(gofakeit.UUID())
+	err = suite.repo.SoftDeleteOrderItem(t.Context(), orderID, productID)
+	require.NoError(t, err)
 
-	price := gofakeit.Price(1, 100)
+	err = suite.repo.SoftDeleteOrder(t.Context(), orderID)
+	require.NoError(t, err)
 
-	currencyUnit := randomCurrency()
+	history, err := suite.repo.GetOrderHistory(t.Context(), orderID)
+	require.NoError(t, err)
 
-	return domain.OrderItem{
-		ProductID: productID,
-		Price: domain.Money{
-			Amount:   decimal.NewFromFloat(price),
-			Currency: currencyUnit,
+	expected := []domain.OrderHistoryEntry{
+		{
+			OrderID:  orderID,
+			Type:     domain.OrderChangeStatus,
+			OldValue: string(domain.OrderStatusPending),
+			NewValue: string(domain.OrderStatusShipped),
+			Actor:    "warehouse",
+			Reason:   "picked up by courier",
+		},
+		{
+			OrderID:   orderID,
+			ProductID: &productID,
+			Type:      domain.OrderChangeItemDeleted,
+			OldValue:  domain.OrderStateActive,
+			NewValue:  domain.OrderStateDeleted,
+		},
+		{
+			OrderID:  orderID,
+			Type:     domain.OrderChangeDeleted,
+			OldValue: domain.OrderStateActive,
+			NewValue: domain.OrderStateDeleted,
 		},
 	}
-}
 
-func randomURL() *url.URL {
-	var (
-		result *url.URL
-		err    error
-	)
+	diff := cmp.Diff(expected, history, cmpopts.IgnoreFields(domain.OrderHistoryEntry{}, "ID", "CreatedAt"))
+	assert.Empty(t, diff)
 
-	for {
-		result, err = url.Parse(gofakeit.URL())
-		if err == nil {
-			break
-		}
+	for _, entry := range history {
+		assert.NotZero(t, entry.ID)
+		assert.False(t, entry.CreatedAt.IsZero())
 	}
 
-	return result
+	suite.Run("order without changes: empty", func() {
+		history, err := suite.repo.GetOrderHistory(suite.T().Context(), uuid.MustParse(gofakeit.UUID()))
+		require.NoError(suite.T(), err)
+		assert.Empty(suite.T(), history)
+	})
+
+	suite.Run("empty order ID: error", func() {
+		_, err := suite.repo.GetOrderHistory(suite.T().Context(), uuid.Nil)
+		require.EqualError(suite.T(), err, "orderID is empty")
+	})
 }
 
-func randomCurrency() currency.Unit {
-	var (
-		result currency.Unit
-		err    error
-	)
-
-	for {
-		// tag is not a recognized currency
-		result, err = currency.ParseISO(gofakeit.CurrencyShort())
-		if err == nil {
-			break
-		}
+func (suite *orderRepositorySuite) insertOrders(orders ...domain.Order) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(orders))
+
+	for _, order := range orders {
+		id, err := suite.repo.InsertOrder(suite.T().Context(), order)
+		suite.NoError(err)
+		ids = append(ids, id)
 	}
 
-	return result
+	return ids
 }
 
-func randomJson() []byte {
-	var (
-		result []byte
-		err    error
-	)
+// orderVersion reads the version of an order directly, soft-deleted orders included, it returns 1 for a missing order.
+func (suite *orderRepositorySuite) orderVersion(orderID uuid.UUID) int64 {
+	var version int64
 
-	for {
-		result, err = gofakeit.JSON(nil)
-		if err == nil {
-			break
-		}
+	err := suite.pool.QueryRow(suite.T().Context(), "SELECT version FROM orders WHERE id = $1", orderID).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 1
 	}
+	suite.NoError(err)
 
-	return result
+	return version
 }
 
-func assertOrder(t *testing.T, expected, actual domain.Order) {
-	t.Helper()
-
-	currencyComparer := cmp.Comparer(func(x, y currency.Unit) bool {
-		return x.String() == y.String()
-	})
+func (suite *orderRepositorySuite) deleteAll() {
+	_, err := suite.pool.Exec(suite.T().Context(), "TRUNCATE TABLE orders, order_items, order_events, order_outbox CASCADE")
+	suite.NoError(err)
+}
 
-	jsonComparer := cmp.Comparer(func(x, y []byte) bool {
-		if x == nil && y == nil {
-			return true
-		}
+// the random data and the assertions are shared with the conformance suite
+var (
+	randomOrder     = repositorytest.RandomOrder
+	randomOrderItem = repositorytest.RandomOrderItem
+	randomJson      = repositorytest.RandomJSON
+	assertOrder     = repositorytest.AssertOrder
+	assertOrders    = repositorytest.AssertOrders
+)
 
-		var normalizedX, normalizedY interface{}
+func randomOrderWithID(id uuid.UUID) domain.Order {
+	order := randomOrder()
+	order.ID = id
+	return order
+}
 
-		if err := json.Unmarshal(x, &normalizedX); err != nil {
-			return false
-		}
-		if err := json.Unmarshal(y, &normalizedY); err != nil {
-			return false
-		}
+// expectedFacets builds the facets of freshly inserted, thus pending, orders.
+func expectedFacets(orders ...domain.Order) domain.OrderFacets {
+	facets := domain.OrderFacets{
+		Statuses:   make(map[domain.OrderStatus]int64),
+		Currencies: make(map[currency.Unit]int64),
+		Tags:       make(map[string]int64),
+		Owners:     make(map[string]int64),
+		PriceSums:  make(map[currency.Unit]decimal.Decimal),
+	}
 
-		return cmp.Equal(normalizedX, normalizedY)
-	})
+	for _, order := range orders {
+		facets.Statuses[domain.OrderStatusPending]++
+		facets.Currencies[order.Price.Currency]++
+		facets.Owners[order.OwnerID]++
+		facets.PriceSums[order.Price.Currency] = facets.PriceSums[order.Price.Currency].Add(order.Price.Amount)
 
-	// Ignore the CreatedAt field in OrderItem and
-	// Treat empty slices as equal to nil
-	opts := cmp.Options{
-		cmpopts.IgnoreFields(domain.OrderItem{}, "CreatedAt"),
-		cmpopts.IgnoreFields(domain.Order{}, "CreatedAt", "UpdatedAt", "ID", "Status"),
-		currencyComparer,
-		cmp.FilterPath(func(p cmp.Path) bool {
-			return p.Last().String() == ".Payload" || p.Last().String() == ".PayloadB"
-		}, jsonComparer),
+		for _, tag := range lo.Uniq(order.Tags) {
+			facets.Tags[tag]++
+		}
 	}
 
-	diff := cmp.Diff(expected, actual, opts)
-	assert.Empty(t, diff)
-
-	assert.False(t, actual.CreatedAt.IsZero())
-	assert.False(t, actual.UpdatedAt.IsZero())
-	assert.Nil(t, actual.DeletedAt)
-	assert.NotEqual(t, uuid.Nil, actual.ID)
+	return facets
 }
 
-func assertOrders(t *testing.T, expected, actual []domain.Order) {
-	t.Helper()
-
-	sortOrders := func(orders []domain.Order) {
-		sort.Slice(orders, func(i, j int) bool {
-			return orders[i].OwnerID < orders[j].OwnerID
-		})
+// sortedBefore reports whether a comes before b in the keyset order of SearchOrdersPage.
+func sortedBefore(a, b domain.Order, sortKey domain.OrderSortKey) bool {
+	var cmpResult int
+
+	switch sortKey {
+	case domain.OrderSortByCreatedAt:
+		cmpResult = a.CreatedAt.Compare(b.CreatedAt)
+	case domain.OrderSortByUpdatedAt:
+		cmpResult = a.UpdatedAt.Compare(b.UpdatedAt)
+	case domain.OrderSortByPriceAmount:
+		cmpResult = a.Price.Amount.Cmp(b.Price.Amount)
 	}
 
-	sortOrders(expected)
-	sortOrders(actual)
-
-	require.Equal(t, len(expected), len(actual))
-
-	for i := range expected {
-		assertOrder(t, expected[i], actual[i])
+	if cmpResult != 0 {
+		return cmpResult < 0
 	}
+
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
 }
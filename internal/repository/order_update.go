@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/samber/lo"
+)
+
+// UpdateOrder replaces the items, tags, URL, payloads and price currency of the order,
+// order.Version is the expected version. Owner and status are left as they are.
+func (r *orderRepository) UpdateOrder(ctx context.Context, order domain.Order) error {
+	if order.ID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if err := domain.ValidateOrderItems(order.Items); err != nil {
+		return fmt.Errorf("domain.ValidateOrderItems: %w", err)
+	}
+
+	return r.updateOrder(ctx, order.ID, order.Version, func(q *db.Queries, tx pgx.Tx) error {
+		cmdTag, err := q.UpdateOrderDetails(ctx, db.UpdateOrderDetailsParams{
+			ID:            order.ID,
+			Url:           lo.ToPtr(domain.URLString(order.Url)),
+			Tags:          order.Tags,
+			Payload:       domain.PayloadOrEmpty(order.Payload),
+			Payloadb:      order.PayloadB,
+			PriceCurrency: order.Price.Currency.String(),
+		})
+		if err != nil {
+			return fmt.Errorf("q.UpdateOrderDetails: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return fmt.Errorf("q.UpdateOrderDetails: %w", ErrNotFound)
+		}
+
+		if err := replaceOrderItems(ctx, q, tx, order.ID, order.Items); err != nil {
+			return fmt.Errorf("replaceOrderItems: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// AddOrderItems adds items to the order, a soft-deleted item is revived,
+// it fails if any of the items is already in the order.
+func (r *orderRepository) AddOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if err := domain.ValidateOrderItems(items); err != nil {
+		return fmt.Errorf("domain.ValidateOrderItems: %w", err)
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(_ *db.Queries, tx pgx.Tx) error {
+		cmdTags, err := execOrderItemsBatch(ctx, tx, db.AddOrderItem, orderID, items)
+		if err != nil {
+			return fmt.Errorf("execOrderItemsBatch: %w", err)
+		}
+
+		for i, cmdTag := range cmdTags {
+			if cmdTag.RowsAffected() == 0 {
+				return fmt.Errorf("product[%s] is already in the order", items[i].ProductID)
+			}
+		}
+
+		return nil
+	})
+}
+
+// ReplaceOrderItems makes items the only items of the order, the items not in the list are soft-deleted.
+func (r *orderRepository) ReplaceOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if err := domain.ValidateOrderItems(items); err != nil {
+		return fmt.Errorf("domain.ValidateOrderItems: %w", err)
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(q *db.Queries, tx pgx.Tx) error {
+		return replaceOrderItems(ctx, q, tx, orderID, items)
+	})
+}
+
+func (r *orderRepository) SetTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(q *db.Queries, _ pgx.Tx) error {
+		cmdTag, err := q.SetOrderTags(ctx, db.SetOrderTagsParams{
+			ID:   orderID,
+			Tags: tags,
+		})
+		if err != nil {
+			return fmt.Errorf("q.SetOrderTags: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return fmt.Errorf("q.SetOrderTags: %w", ErrNotFound)
+		}
+
+		return nil
+	})
+}
+
+// AddTags appends the tags which the order does not have yet, keeping their order.
+func (r *orderRepository) AddTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if len(tags) == 0 {
+		return fmt.Errorf("tags are empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(q *db.Queries, _ pgx.Tx) error {
+		cmdTag, err := q.AddOrderTags(ctx, db.AddOrderTagsParams{
+			ID:   orderID,
+			Tags: lo.Uniq(tags),
+		})
+		if err != nil {
+			return fmt.Errorf("q.AddOrderTags: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return fmt.Errorf("q.AddOrderTags: %w", ErrNotFound)
+		}
+
+		return nil
+	})
+}
+
+func (r *orderRepository) RemoveTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	if len(tags) == 0 {
+		return fmt.Errorf("tags are empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(q *db.Queries, _ pgx.Tx) error {
+		cmdTag, err := q.RemoveOrderTags(ctx, db.RemoveOrderTagsParams{
+			ID:   orderID,
+			Tags: tags,
+		})
+		if err != nil {
+			return fmt.Errorf("q.RemoveOrderTags: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return fmt.Errorf("q.RemoveOrderTags: %w", ErrNotFound)
+		}
+
+		return nil
+	})
+}
+
+// SetURL sets the URL of the order, nil clears it.
+func (r *orderRepository) SetURL(ctx context.Context, orderID uuid.UUID, u *url.URL, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(q *db.Queries, _ pgx.Tx) error {
+		cmdTag, err := q.SetOrderURL(ctx, db.SetOrderURLParams{
+			ID:  orderID,
+			Url: lo.ToPtr(domain.URLString(u)),
+		})
+		if err != nil {
+			return fmt.Errorf("q.SetOrderURL: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return fmt.Errorf("q.SetOrderURL: %w", ErrNotFound)
+		}
+
+		return nil
+	})
+}
+
+// SetPayload sets the JSON and JSONB payloads of the order, a nil payload is stored as an empty JSON object.
+func (r *orderRepository) SetPayload(ctx context.Context, orderID uuid.UUID, payload, payloadB []byte, expectedVersion int64) error {
+	if orderID == uuid.Nil {
+		return fmt.Errorf("orderID is empty")
+	}
+
+	return r.updateOrder(ctx, orderID, expectedVersion, func(q *db.Queries, _ pgx.Tx) error {
+		cmdTag, err := q.SetOrderPayload(ctx, db.SetOrderPayloadParams{
+			ID:       orderID,
+			Payload:  domain.PayloadOrEmpty(payload),
+			Payloadb: payloadB,
+		})
+		if err != nil {
+			return fmt.Errorf("q.SetOrderPayload: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return fmt.Errorf("q.SetOrderPayload: %w", ErrNotFound)
+		}
+
+		return nil
+	})
+}
+
+// updateOrder locks the order, checks its version and runs fn in a transaction,
+// then recomputes the order price, which also bumps the version and updated_at.
+func (r *orderRepository) updateOrder(ctx context.Context, orderID uuid.UUID, expectedVersion int64, fn func(q *db.Queries, tx pgx.Tx) error) error {
+	if expectedVersion <= 0 {
+		return fmt.Errorf("expectedVersion must be positive")
+	}
+
+	zero := struct{}{}
+	_, err := withTx(ctx, r.conn(ctx), func(q *db.Queries) (struct{}, error) {
+		version, err := q.GetOrderVersionForUpdate(ctx, orderID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return zero, fmt.Errorf("q.GetOrderVersionForUpdate: %w", ErrNotFound)
+			}
+			return zero, fmt.Errorf("q.GetOrderVersionForUpdate: %w", err)
+		}
+
+		if version != expectedVersion {
+			return zero, fmt.Errorf("q.GetOrderVersionForUpdate: %w", ErrConcurrentModification)
+		}
+
+		tx, ok := q.DB().(pgx.Tx)
+		if !ok {
+			return zero, fmt.Errorf("q.DB() is not pgx.Tx")
+		}
+
+		if err := fn(q, tx); err != nil {
+			return zero, err
+		}
+
+		cmdTag, err := q.UpdateOrderPrice(ctx, orderID)
+		if err != nil {
+			return zero, fmt.Errorf("q.UpdateOrderPrice: %w", err)
+		}
+
+		if cmdTag.RowsAffected() == 0 {
+			return zero, fmt.Errorf("q.UpdateOrderPrice: %w", ErrNotFound)
+		}
+
+		return zero, nil
+	}, r.txOpts...)
+	if err != nil {
+		return fmt.Errorf("withTx: %w", err)
+	}
+
+	r.markWrite(ctx)
+
+	return nil
+}
+
+func replaceOrderItems(ctx context.Context, q *db.Queries, tx pgx.Tx, orderID uuid.UUID, items []domain.OrderItem) error {
+	productIDs := make([]uuid.UUID, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	removed, err := q.SoftDeleteOrderItemsExcept(ctx, db.SoftDeleteOrderItemsExceptParams{
+		OrderID:    orderID,
+		ProductIds: productIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("q.SoftDeleteOrderItemsExcept: %w", err)
+	}
+
+	// the removed items are recorded and emitted like the ones of SoftDeleteOrderItem
+	for _, productID := range removed {
+		if err := recordOrderChange(ctx, q, orderID, &productID, domain.OrderChangeItemDeleted, domain.OrderStateActive, domain.OrderStateDeleted); err != nil {
+			return fmt.Errorf("recordOrderChange: %w", err)
+		}
+
+		if err := emitOrderEvent(ctx, q, orderID, domain.OrderEventItemRemoved, domain.OrderItemRemovedPayload{
+			OrderID:   orderID,
+			ProductID: productID,
+		}); err != nil {
+			return fmt.Errorf("emitOrderEvent: %w", err)
+		}
+	}
+
+	if _, err := execOrderItemsBatch(ctx, tx, db.ReplaceOrderItem, orderID, items); err != nil {
+		return fmt.Errorf("execOrderItemsBatch: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,383 @@
+package repository_test
+
+import (
+	"net/url"
+	"slices"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestUpdateOrder() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name        string
+		buildUpdate func(inserted domain.Order) domain.Order
+		wantError   string
+	}{
+		{
+			name: "replace all fields: ok",
+			buildUpdate: func(inserted domain.Order) domain.Order {
+				updated := randomOrder()
+				updated.ID = inserted.ID
+				updated.OwnerID = inserted.OwnerID
+				updated.Version = inserted.Version
+				// keep one of the items, its price changes
+				updated.Items[0].ProductID = inserted.Items[0].ProductID
+				updated.Price.Amount = sumItems(updated.Items)
+				return updated
+			},
+		},
+		{
+			name: "clear url and tags: ok",
+			buildUpdate: func(inserted domain.Order) domain.Order {
+				updated := inserted
+				updated.Url = nil
+				updated.Tags = nil
+				return updated
+			},
+		},
+		{
+			name: "stale version: concurrent modification",
+			buildUpdate: func(inserted domain.Order) domain.Order {
+				updated := inserted
+				updated.Version = inserted.Version + 1
+				return updated
+			},
+			wantError: "withTx: q.GetOrderVersionForUpdate: order was modified concurrently",
+		},
+		{
+			name: "non-existing order: not found",
+			buildUpdate: func(inserted domain.Order) domain.Order {
+				updated := inserted
+				updated.ID = uuid.MustParse(gofakeit.UUID())
+				return updated
+			},
+			wantError: "withTx: q.GetOrderVersionForUpdate: order not found",
+		},
+		{
+			name: "no items: error",
+			buildUpdate: func(inserted domain.Order) domain.Order {
+				updated := inserted
+				updated.Items = nil
+				return updated
+			},
+			wantError: "domain.ValidateOrderItems: no items in order",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			inserted := suite.insertAndGetOrder(randomOrder())
+			updated := tt.buildUpdate(inserted)
+
+			err := suite.repo.UpdateOrder(ctx, updated)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			actual, err := suite.repo.GetOrder(ctx, inserted.ID)
+			require.NoError(t, err)
+
+			assertOrder(t, updated, actual)
+			assert.Equal(t, inserted.Version+1, actual.Version)
+			assert.False(t, actual.UpdatedAt.Before(inserted.UpdatedAt))
+		})
+	}
+}
+
+func (suite *orderRepositorySuite) TestAddOrderItems() {
+	defer suite.deleteAll()
+
+	suite.Run("add new item: ok", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		inserted := suite.insertAndGetOrder(randomOrder())
+
+		item := randomOrderItem()
+		item.Price.Currency = inserted.Price.Currency
+
+		err := suite.repo.AddOrderItems(ctx, inserted.ID, []domain.OrderItem{item}, inserted.Version)
+		require.NoError(t, err)
+
+		actual, err := suite.repo.GetOrder(ctx, inserted.ID)
+		require.NoError(t, err)
+
+		expected := inserted
+		expected.Items = append(slices.Clone(inserted.Items), item)
+		expected.Price.Amount = sumItems(expected.Items)
+
+		assertOrder(t, expected, actual)
+		assert.Equal(t, inserted.Version+1, actual.Version)
+	})
+
+	suite.Run("add soft-deleted item again: ok", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		order := randomOrder()
+		order.Items = append(order.Items, randomOrderItem())
+		order.Items[len(order.Items)-1].Price.Currency = order.Price.Currency
+		order.Price.Amount = sumItems(order.Items)
+
+		inserted := suite.insertAndGetOrder(order)
+		removed := inserted.Items[0]
+
+		err := suite.repo.SoftDeleteOrderItem(ctx, inserted.ID, removed.ProductID)
+		require.NoError(t, err)
+
+		err = suite.repo.AddOrderItems(ctx, inserted.ID, []domain.OrderItem{removed}, inserted.Version+1)
+		require.NoError(t, err)
+
+		actual, err := suite.repo.GetOrder(ctx, inserted.ID)
+		require.NoError(t, err)
+
+		assertOrder(t, inserted, actual)
+		assert.Equal(t, inserted.Version+2, actual.Version)
+	})
+
+	suite.Run("add item already in order: error", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		inserted := suite.insertAndGetOrder(randomOrder())
+
+		err := suite.repo.AddOrderItems(ctx, inserted.ID, inserted.Items[:1], inserted.Version)
+		require.EqualError(t, err, "withTx: product["+inserted.Items[0].ProductID.String()+"] is already in the order")
+
+		// nothing changed
+		actual, err := suite.repo.GetOrder(ctx, inserted.ID)
+		require.NoError(t, err)
+		assert.Equal(t, inserted.Version, actual.Version)
+	})
+
+	suite.Run("add to soft-deleted order: not found", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		inserted := suite.insertAndGetOrder(randomOrder())
+		require.NoError(t, suite.repo.SoftDeleteOrder(ctx, inserted.ID))
+
+		err := suite.repo.AddOrderItems(ctx, inserted.ID, []domain.OrderItem{randomOrderItem()}, inserted.Version+1)
+		require.ErrorIs(t, err, repository.ErrNotFound)
+	})
+}
+
+func (suite *orderRepositorySuite) TestReplaceOrderItems() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	order := randomOrder()
+	order.Items = append(order.Items, randomOrderItem())
+	order.Items[len(order.Items)-1].Price.Currency = order.Price.Currency
+	order.Price.Amount = sumItems(order.Items)
+
+	inserted := suite.insertAndGetOrder(order)
+
+	kept := inserted.Items[0]
+	kept.Price.Amount = kept.Price.Amount.Add(decimal.NewFromInt(1))
+
+	added := randomOrderItem()
+	added.Price.Currency = inserted.Price.Currency
+
+	items := []domain.OrderItem{kept, added}
+
+	err := suite.repo.ReplaceOrderItems(ctx, inserted.ID, items, inserted.Version)
+	require.NoError(t, err)
+
+	actual, err := suite.repo.GetOrder(ctx, inserted.ID)
+	require.NoError(t, err)
+
+	expected := inserted
+	expected.Items = items
+	expected.Price.Amount = sumItems(items)
+
+	assertOrder(t, expected, actual)
+	assert.Equal(t, inserted.Version+1, actual.Version)
+
+	// every removed item is recorded in the history and emitted to the outbox
+	var removedIDs []uuid.UUID
+	for _, item := range inserted.Items[1:] {
+		removedIDs = append(removedIDs, item.ProductID)
+	}
+
+	history, err := suite.repo.GetOrderHistory(ctx, inserted.ID)
+	require.NoError(t, err)
+
+	var historyIDs []uuid.UUID
+	for _, entry := range history {
+		if entry.Type == domain.OrderChangeItemDeleted {
+			historyIDs = append(historyIDs, *entry.ProductID)
+		}
+	}
+	assert.ElementsMatch(t, removedIDs, historyIDs)
+
+	var outboxIDs []uuid.UUID
+	rows, err := suite.pool.Query(ctx, "SELECT payload->>'product_id' FROM order_outbox WHERE order_id = $1 AND event_type = $2",
+		inserted.ID, string(domain.OrderEventItemRemoved))
+	require.NoError(t, err)
+	for rows.Next() {
+		var productID uuid.UUID
+		require.NoError(t, rows.Scan(&productID))
+		outboxIDs = append(outboxIDs, productID)
+	}
+	require.NoError(t, rows.Err())
+	assert.ElementsMatch(t, removedIDs, outboxIDs)
+
+	err = suite.repo.ReplaceOrderItems(ctx, inserted.ID, items, inserted.Version)
+	require.ErrorIs(t, err, repository.ErrConcurrentModification)
+
+	err = suite.repo.ReplaceOrderItems(ctx, inserted.ID, nil, actual.Version)
+	require.EqualError(t, err, "domain.ValidateOrderItems: no items in order")
+
+	err = suite.repo.ReplaceOrderItems(ctx, inserted.ID, []domain.OrderItem{added, added}, actual.Version)
+	require.EqualError(t, err, "domain.ValidateOrderItems: product["+added.ProductID.String()+"] is listed twice")
+}
+
+func (suite *orderRepositorySuite) TestTags() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	order := randomOrder()
+	order.Tags = []string{"a", "b"}
+	inserted := suite.insertAndGetOrder(order)
+
+	version := inserted.Version
+
+	assertTags := func(want []string) {
+		t.Helper()
+
+		actual, err := suite.repo.GetOrder(ctx, inserted.ID)
+		require.NoError(t, err)
+
+		if len(want) == 0 {
+			assert.Empty(t, actual.Tags)
+		} else {
+			assert.Equal(t, want, actual.Tags)
+		}
+		assert.Equal(t, version, actual.Version)
+		assert.True(t, inserted.Price.Amount.Equal(actual.Price.Amount))
+	}
+
+	require.NoError(t, suite.repo.AddTags(ctx, inserted.ID, []string{"b", "c", "d", "c"}, version))
+	version++
+	assertTags([]string{"a", "b", "c", "d"})
+
+	require.NoError(t, suite.repo.RemoveTags(ctx, inserted.ID, []string{"a", "c", "x"}, version))
+	version++
+	assertTags([]string{"b", "d"})
+
+	require.NoError(t, suite.repo.SetTags(ctx, inserted.ID, []string{"z"}, version))
+	version++
+	assertTags([]string{"z"})
+
+	require.NoError(t, suite.repo.SetTags(ctx, inserted.ID, nil, version))
+	version++
+	assertTags(nil)
+
+	require.NoError(t, suite.repo.AddTags(ctx, inserted.ID, []string{"y"}, version))
+	version++
+	assertTags([]string{"y"})
+
+	err := suite.repo.AddTags(ctx, inserted.ID, nil, version)
+	require.EqualError(t, err, "tags are empty")
+
+	err = suite.repo.RemoveTags(ctx, inserted.ID, []string{"y"}, version-1)
+	require.ErrorIs(t, err, repository.ErrConcurrentModification)
+
+	err = suite.repo.SetTags(ctx, uuid.MustParse(gofakeit.UUID()), []string{"y"}, 1)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func (suite *orderRepositorySuite) TestSetURL() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	inserted := suite.insertAndGetOrder(randomOrder())
+
+	newURL, err := url.Parse("https://example.com/orders/1")
+	require.NoError(t, err)
+
+	require.NoError(t, suite.repo.SetURL(ctx, inserted.ID, newURL, inserted.Version))
+
+	actual, err := suite.repo.GetOrder(ctx, inserted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, newURL.String(), actual.Url.String())
+	assert.Equal(t, inserted.Version+1, actual.Version)
+
+	require.NoError(t, suite.repo.SetURL(ctx, inserted.ID, nil, actual.Version))
+
+	actual, err = suite.repo.GetOrder(ctx, inserted.ID)
+	require.NoError(t, err)
+	assert.Nil(t, actual.Url)
+
+	err = suite.repo.SetURL(ctx, inserted.ID, newURL, 0)
+	require.EqualError(t, err, "expectedVersion must be positive")
+}
+
+func (suite *orderRepositorySuite) TestSetPayload() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	inserted := suite.insertAndGetOrder(randomOrder())
+
+	expected := inserted
+	expected.Payload = randomJson()
+	expected.PayloadB = randomJson()
+
+	require.NoError(t, suite.repo.SetPayload(ctx, inserted.ID, expected.Payload, expected.PayloadB, inserted.Version))
+
+	actual, err := suite.repo.GetOrder(ctx, inserted.ID)
+	require.NoError(t, err)
+	assertOrder(t, expected, actual)
+	assert.Equal(t, inserted.Version+1, actual.Version)
+
+	require.NoError(t, suite.repo.SetPayload(ctx, inserted.ID, nil, nil, actual.Version))
+
+	expected.Payload = []byte(`{}`)
+	expected.PayloadB = nil
+
+	actual, err = suite.repo.GetOrder(ctx, inserted.ID)
+	require.NoError(t, err)
+	assertOrder(t, expected, actual)
+}
+
+func (suite *orderRepositorySuite) insertAndGetOrder(order domain.Order) domain.Order {
+	ctx := suite.T().Context()
+
+	orderID, err := suite.repo.InsertOrder(ctx, order)
+	suite.Require().NoError(err)
+
+	inserted, err := suite.repo.GetOrder(ctx, orderID)
+	suite.Require().NoError(err)
+
+	return inserted
+}
+
+func sumItems(items []domain.OrderItem) decimal.Decimal {
+	sum := decimal.Zero
+	for _, item := range items {
+		sum = sum.Add(item.Price.Amount)
+	}
+	return sum
+}
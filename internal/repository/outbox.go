@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+)
+
+// OutboxRelay moves order events from the outbox table to a publisher.
+// Several relays may run against the same database, each event batch is claimed by one of them only.
+type OutboxRelay struct {
+	dbtx      db.DBTX
+	publisher port.Publisher
+	batchSize int
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// maxRelayBackoff caps the wait of Run after consecutive failed batches, unless the interval is longer.
+const maxRelayBackoff = time.Minute
+
+// OutboxRelayOption configures the relay created by NewOutboxRelay.
+type OutboxRelayOption func(*OutboxRelay)
+
+// WithRelayLogger logs the failed batches of Run with logger instead of slog.Default().
+func WithRelayLogger(logger *slog.Logger) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		r.logger = logger
+	}
+}
+
+// NewOutboxRelay creates a relay which polls the outbox with the given dbtx (pgxpool.Pool) every interval,
+// and publishes up to batchSize events per transaction.
+func NewOutboxRelay(dbtx db.DBTX, publisher port.Publisher, batchSize int, interval time.Duration, opts ...OutboxRelayOption) (*OutboxRelay, error) {
+	if dbtx == nil {
+		return nil, fmt.Errorf("dbtx is nil")
+	}
+	if publisher == nil {
+		return nil, fmt.Errorf("publisher is nil")
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batchSize must be positive")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+
+	r := &OutboxRelay{
+		dbtx:      dbtx,
+		publisher: publisher,
+		batchSize: batchSize,
+		interval:  interval,
+		logger:    slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.logger == nil {
+		return nil, fmt.Errorf("logger is nil")
+	}
+
+	return r, nil
+}
+
+// Run relays events until ctx is done, it always returns nil.
+// It polls again right away while batches come back full and waits for the interval otherwise.
+// A failed batch is logged and retried after a wait which doubles with every consecutive failure,
+// from the interval up to a minute.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	backoff := r.interval
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		n, err := r.RelayBatch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			r.logger.ErrorContext(ctx, "outbox relay batch failed", slog.String("error", err.Error()), slog.Duration("retry_in", backoff))
+
+			timer.Reset(backoff)
+			backoff = min(2*backoff, max(maxRelayBackoff, r.interval))
+
+			continue
+		}
+
+		backoff = r.interval
+
+		if n == r.batchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(r.interval)
+		}
+	}
+}
+
+// RelayBatch publishes the oldest unpublished events and marks them as published, it returns how many were relayed.
+// The events stay locked with FOR UPDATE SKIP LOCKED until the publisher returns,
+// if it fails the transaction is rolled back and the events are relayed again later.
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	n, err := withTx(ctx, r.dbtx, func(q *db.Queries) (int, error) {
+		rows, err := q.ClaimOutboxEvents(ctx, int32(r.batchSize))
+		if err != nil {
+			return 0, fmt.Errorf("q.ClaimOutboxEvents: %w", err)
+		}
+
+		if len(rows) == 0 {
+			return 0, nil
+		}
+
+		events := make([]domain.OrderEvent, 0, len(rows))
+		ids := make([]int64, 0, len(rows))
+		for _, row := range rows {
+			events = append(events, mapClaimOutboxEventsRowToDomain(row))
+			ids = append(ids, row.ID)
+		}
+
+		if err := r.publisher.Publish(ctx, events); err != nil {
+			return 0, fmt.Errorf("publisher.Publish: %w", err)
+		}
+
+		cmdTag, err := q.MarkOutboxEventsPublished(ctx, ids)
+		if err != nil {
+			return 0, fmt.Errorf("q.MarkOutboxEventsPublished: %w", err)
+		}
+
+		if cmdTag.RowsAffected() != int64(len(ids)) {
+			return 0, errors.New("q.MarkOutboxEventsPublished: not all events were marked")
+		}
+
+		return len(events), nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("withTx: %w", err)
+	}
+
+	return n, nil
+}
+
+// emitOrderEvent writes an event to the outbox, it must run in the transaction of the change itself.
+func emitOrderEvent(ctx context.Context, q *db.Queries, orderID uuid.UUID, eventType domain.OrderEventType, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	if err := q.InsertOutboxEvent(ctx, db.InsertOutboxEventParams{
+		EventType: string(eventType),
+		OrderID:   orderID,
+		Payload:   b,
+	}); err != nil {
+		return fmt.Errorf("q.InsertOutboxEvent: %w", err)
+	}
+
+	return nil
+}
+
+func mapClaimOutboxEventsRowToDomain(row db.ClaimOutboxEventsRow) domain.OrderEvent {
+	return domain.OrderEvent{
+		ID:        row.ID,
+		Type:      domain.OrderEventType(row.EventType),
+		OrderID:   row.OrderID,
+		Payload:   row.Payload,
+		CreatedAt: row.CreatedAt,
+	}
+}
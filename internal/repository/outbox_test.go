@@ -0,0 +1,165 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/publisher"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestOutboxRelay() {
+	suite.deleteAll()
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	pub := publisher.NewInMemory()
+
+	relay, err := repository.NewOutboxRelay(suite.pool, pub, 2, time.Second)
+	require.NoError(t, err)
+
+	order := randomOrder()
+	orderID := suite.insertOrders(order)[0]
+	productID := order.Items[0].ProductID
+
+	require.NoError(t, suite.repo.UpdateOrderStatus(ctx, orderID, domain.OrderStatusShipped, 1))
+	require.NoError(t, suite.repo.SoftDeleteOrderItem(ctx, orderID, productID))
+	require.NoError(t, suite.repo.SoftDeleteOrder(ctx, orderID))
+	require.NoError(t, suite.repo.DeleteOrder(ctx, orderID))
+
+	// a failed change emits nothing
+	require.Error(t, suite.repo.UpdateOrderStatus(ctx, orderID, domain.OrderStatusDelivered, 2))
+
+	// a failed publish leaves the events in the outbox
+	pub.FailWith(errors.New("broker is down"))
+	_, err = relay.RelayBatch(ctx)
+	require.EqualError(t, err, "withTx: publisher.Publish: broker is down")
+	pub.FailWith(nil)
+
+	var relayed []int
+	for {
+		n, err := relay.RelayBatch(ctx)
+		require.NoError(t, err)
+		if n == 0 {
+			break
+		}
+		relayed = append(relayed, n)
+	}
+	assert.Equal(t, []int{2, 2, 1}, relayed)
+
+	events := pub.Events()
+
+	wantTypes := []domain.OrderEventType{
+		domain.OrderEventCreated,
+		domain.OrderEventStatusChanged,
+		domain.OrderEventItemRemoved,
+		domain.OrderEventDeleted,
+		domain.OrderEventDeleted,
+	}
+	assert.Equal(t, wantTypes, lo.Map(events, func(e domain.OrderEvent, _ int) domain.OrderEventType {
+		return e.Type
+	}))
+
+	for _, event := range events {
+		assert.Equal(t, orderID, event.OrderID)
+		assert.NotZero(t, event.ID)
+		assert.False(t, event.CreatedAt.IsZero())
+	}
+
+	var created domain.OrderCreatedPayload
+	require.NoError(t, json.Unmarshal(events[0].Payload, &created))
+	assert.Equal(t, orderID, created.OrderID)
+	assert.Equal(t, order.OwnerID, created.OwnerID)
+	assert.Equal(t, domain.OrderStatusPending, created.Status)
+	assert.True(t, order.Price.Amount.Equal(created.PriceAmount))
+	assert.Len(t, created.ProductIDs, len(order.Items))
+
+	var statusChanged domain.OrderStatusChangedPayload
+	require.NoError(t, json.Unmarshal(events[1].Payload, &statusChanged))
+	assert.Equal(t, domain.OrderStatusChangedPayload{
+		OrderID: orderID,
+		From:    domain.OrderStatusPending,
+		To:      domain.OrderStatusShipped,
+	}, statusChanged)
+
+	var itemRemoved domain.OrderItemRemovedPayload
+	require.NoError(t, json.Unmarshal(events[2].Payload, &itemRemoved))
+	assert.Equal(t, productID, itemRemoved.ProductID)
+
+	var softDeleted, hardDeleted domain.OrderDeletedPayload
+	require.NoError(t, json.Unmarshal(events[3].Payload, &softDeleted))
+	require.NoError(t, json.Unmarshal(events[4].Payload, &hardDeleted))
+	assert.False(t, softDeleted.Hard)
+	assert.True(t, hardDeleted.Hard)
+}
+
+func (suite *orderRepositorySuite) TestOutboxRelay_Run() {
+	suite.deleteAll()
+	defer suite.deleteAll()
+
+	t := suite.T()
+
+	pub := publisher.NewInMemory()
+	// the relay keeps running through the failed batches
+	pub.FailWith(errors.New("broker is down"))
+
+	logs := &syncBuffer{}
+	logger := slog.New(slog.NewTextHandler(logs, nil))
+
+	relay, err := repository.NewOutboxRelay(suite.pool, pub, 10, 10*time.Millisecond, repository.WithRelayLogger(logger))
+	require.NoError(t, err)
+
+	runCtx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run(runCtx)
+	}()
+
+	orderIDs := suite.insertOrders(randomOrder(), randomOrder())
+
+	require.Eventually(t, func() bool {
+		return strings.Count(logs.String(), "outbox relay batch failed") >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, pub.Events())
+
+	pub.FailWith(nil)
+
+	require.Eventually(t, func() bool {
+		return len(pub.Events()) == len(orderIDs)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// syncBuffer serializes the writes of the relay goroutine with the reads of the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+)
+
+const defaultReplicaCooldown = 5 * time.Second
+
+type replica struct {
+	pool *pgxpool.Pool
+	// downUntil is the unix nano time until which the replica is skipped
+	downUntil atomic.Int64
+}
+
+// replicaSet picks replicas round-robin, skipping the ones which could not be reached recently.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+	cooldown time.Duration
+}
+
+// pick returns the next healthy replica or nil if all of them are down.
+func (s *replicaSet) pick() *replica {
+	n := uint64(len(s.replicas))
+	start := s.next.Add(1) - 1
+	now := time.Now().UnixNano()
+
+	for i := range n {
+		rep := s.replicas[(start+i)%n]
+		if rep.downUntil.Load() <= now {
+			return rep
+		}
+	}
+
+	return nil
+}
+
+func (s *replicaSet) markDown(rep *replica) {
+	rep.downUntil.Store(time.Now().Add(s.cooldown).UnixNano())
+}
+
+// NewOrderWithReplicas creates a new OrderRepository which writes to primary and runs
+// GetOrder, GetOrderSeparateQueries and SearchOrders on replicas, the other reads go to primary.
+// A replica which cannot be reached is skipped for a while, see WithReplicaCooldown,
+// the read falls back to primary when no replica is healthy.
+func NewOrderWithReplicas(primary *pgxpool.Pool, replicas []*pgxpool.Pool, opts ...Option) (port.OrderRepository, error) {
+	if primary == nil {
+		return nil, fmt.Errorf("primary is nil")
+	}
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("replicas are empty")
+	}
+
+	set := &replicaSet{
+		replicas: make([]*replica, 0, len(replicas)),
+		cooldown: defaultReplicaCooldown,
+	}
+	for i, pool := range replicas {
+		if pool == nil {
+			return nil, fmt.Errorf("replica[%d] is nil", i)
+		}
+		set.replicas = append(set.replicas, &replica{pool: pool})
+	}
+
+	r := &orderRepository{
+		dbtx:     primary,
+		replicas: set,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.initLogging()
+
+	return r, nil
+}
+
+// WithReplicaCooldown sets for how long a replica which could not be reached is skipped, the default is 5s.
+func WithReplicaCooldown(d time.Duration) Option {
+	return func(r *orderRepository) {
+		if r.replicas != nil && d > 0 {
+			r.replicas.cooldown = d
+		}
+	}
+}
+
+// WithReadYourWrites sends the reads to primary for window after a write made with the same context,
+// the context has to be prepared with ContextWithReadYourWrites.
+func WithReadYourWrites(window time.Duration) Option {
+	return func(r *orderRepository) {
+		r.readYourWritesWindow = window
+	}
+}
+
+type writeTrackerKey struct{}
+
+type writeTracker struct {
+	// lastWrite is the unix nano time of the last write
+	lastWrite atomic.Int64
+}
+
+// ContextWithReadYourWrites returns a context which remembers the writes made with it or with contexts derived from it,
+// so the reads made with it see them despite the replication lag, i.e. one per request.
+func ContextWithReadYourWrites(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(writeTrackerKey{}).(*writeTracker); ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, writeTrackerKey{}, &writeTracker{})
+}
+
+// markWrite must be called after every successful write.
+func (r *orderRepository) markWrite(ctx context.Context) {
+	if r.readYourWritesWindow <= 0 {
+		return
+	}
+
+	if tracker, ok := ctx.Value(writeTrackerKey{}).(*writeTracker); ok {
+		tracker.lastWrite.Store(time.Now().UnixNano())
+	}
+}
+
+func (r *orderRepository) pinnedToPrimary(ctx context.Context) bool {
+	if r.readYourWritesWindow <= 0 {
+		return false
+	}
+
+	tracker, ok := ctx.Value(writeTrackerKey{}).(*writeTracker)
+	if !ok {
+		return false
+	}
+
+	lastWrite := tracker.lastWrite.Load()
+	return lastWrite > 0 && time.Since(time.Unix(0, lastWrite)) < r.readYourWritesWindow
+}
+
+// pickReplica returns nil if the read has to go to conn(ctx).
+func (r *orderRepository) pickReplica(ctx context.Context) *replica {
+	if r.replicas == nil {
+		return nil
+	}
+
+	if _, ok := txFromContext(ctx); ok {
+		return nil
+	}
+
+	if r.pinnedToPrimary(ctx) {
+		return nil
+	}
+
+	return r.replicas.pick()
+}
+
+// routeRead runs fn on a replica if there is a healthy one, a replica which cannot be reached is marked down
+// and fn is run again on the primary.
+// fn gets dbtx unwrapped, so withTx can begin on it and log the statements of the transaction once,
+// fn runs its other statements on r.statements.wrap(dbtx).
+func routeRead[T any](ctx context.Context, r *orderRepository, fn func(dbtx db.DBTX) (T, error)) (T, error) {
+	rep := r.pickReplica(ctx)
+	if rep == nil {
+		return fn(r.conn(ctx))
+	}
+
+	result, err := fn(rep.pool)
+	if err == nil || ctx.Err() != nil || !isConnectionError(err) {
+		return result, err
+	}
+
+	r.replicas.markDown(rep)
+
+	return fn(r.dbtx)
+}
+
+// isConnectionError tells whether err means the server could not be reached, rather than the query failed.
+func isConnectionError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return false
+	}
+
+	var (
+		connectErr *pgconn.ConnectError
+		netErr     net.Error
+	)
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -12,7 +12,12 @@ func startPostgres(ctx context.Context) (*postgres.PostgresContainer, string, er
 		postgres.BasicWaitStrategies(),
 		postgres.WithInitScripts(
 			"../migrations/01_orders.up.sql",
-			"../migrations/02_orders_complex.up.sql"),
+			"../migrations/02_orders_complex.up.sql",
+			"../migrations/03_order_status_transitions.up.sql",
+			"../migrations/04_order_events.up.sql",
+			"../migrations/05_order_outbox.up.sql",
+			"../migrations/06_order_version.up.sql",
+			"../migrations/07_order_idempotency.up.sql"),
 	)
 	if err != nil {
 		return nil, "", fmt.Errorf("postgres.Run: %w", err)
@@ -0,0 +1,109 @@
+package repositorytest
+
+import (
+	"net/url"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
+)
+
+// RandomOrder builds an order which is valid for InsertOrder, its ID is empty.
+func RandomOrder() domain.Order {
+	currencyUnit := randomCurrency() // it has to be the same for all items
+	orderAmount := decimal.Zero
+
+	var items []domain.OrderItem
+	for i := 0; i < gofakeit.Number(1, 5); i++ {
+		orderItem := RandomOrderItem()
+		orderItem.Price.Currency = currencyUnit
+		orderAmount = orderAmount.Add(orderItem.Price.Amount)
+		items = append(items, orderItem)
+	}
+
+	var tags []string
+	for i := 0; i < gofakeit.Number(1, 3); i++ {
+		tags = append(tags, gofakeit.BeerName())
+	}
+
+	return domain.Order{
+		ID:       uuid.Nil,
+		OwnerID:  gofakeit.UUID(),
+		Items:    items,
+		Url:      randomURL(),
+		Tags:     tags,
+		Payload:  RandomJSON(),
+		PayloadB: RandomJSON(),
+		Price: domain.Money{
+			Amount:   orderAmount,
+			Currency: currencyUnit,
+		},
+	}
+}
+
+func RandomOrderItem() domain.OrderItem {
+	productID := uuid.MustParse(gofakeit.UUID())
+
+	price := gofakeit.Price(1, 100)
+
+	currencyUnit := randomCurrency()
+
+	return domain.OrderItem{
+		ProductID: productID,
+		Price: domain.Money{
+			Amount:   decimal.NewFromFloat(price),
+			Currency: currencyUnit,
+		},
+	}
+}
+
+func RandomJSON() []byte {
+	var (
+		result []byte
+		err    error
+	)
+
+	for {
+		result, err = gofakeit.JSON(nil)
+		if err == nil {
+			break
+		}
+	}
+
+	return result
+}
+
+func randomURL() *url.URL {
+	var (
+		result *url.URL
+		err    error
+	)
+
+	for {
+		result, err = url.Parse(gofakeit.URL())
+		if err == nil {
+			break
+		}
+	}
+
+	return result
+}
+
+func randomCurrency() currency.Unit {
+	var (
+		result currency.Unit
+		err    error
+	)
+
+	for {
+		// tag is not a recognized currency
+		result, err = currency.ParseISO(gofakeit.CurrencyShort())
+		if err == nil {
+			break
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,498 @@
+// Package repositorytest is the contract every port.OrderRepository implementation has to pass.
+package repositorytest
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+// Factory returns an empty repository, it is called once per test of the suite.
+type Factory func(t *testing.T) port.OrderRepository
+
+// Run runs the contract tests against the repositories returned by newRepo.
+// Errors are matched with errors.Is against the repository sentinels, so implementations may word them differently.
+func Run(t *testing.T, newRepo Factory) {
+	t.Run("InsertOrder", func(t *testing.T) {
+		testInsertOrder(t, newRepo(t))
+	})
+	t.Run("SearchOrders", func(t *testing.T) {
+		testSearchOrders(t, newRepo(t))
+	})
+	t.Run("DeleteOrder", func(t *testing.T) {
+		testDeleteOrder(t, newRepo(t))
+	})
+	t.Run("SoftDeleteOrder", func(t *testing.T) {
+		testSoftDeleteOrder(t, newRepo(t))
+	})
+}
+
+func testInsertOrder(t *testing.T, repo port.OrderRepository) {
+	tests := []struct {
+		name       string
+		buildOrder func() domain.Order
+		wantError  require.ErrorAssertionFunc
+	}{
+		{
+			name:       "valid order with all fields: ok",
+			buildOrder: RandomOrder,
+		},
+		{
+			name: "invalid order, no items: fail",
+			buildOrder: func() domain.Order {
+				o := RandomOrder()
+				o.Items = nil
+				return o
+			},
+			wantError: require.Error,
+		},
+		{
+			name: "invalid order, empty product ID: validation error",
+			buildOrder: func() domain.Order {
+				o := RandomOrder()
+				o.Items[0].ProductID = uuid.Nil
+				return o
+			},
+			wantError: func(t require.TestingT, err error, _ ...any) {
+				require.EqualError(t, err, "domain.ValidateOrderItems: item productID is empty")
+			},
+		},
+		{
+			name: "invalid order, duplicate product: validation error",
+			buildOrder: func() domain.Order {
+				o := RandomOrder()
+				o.Items = append(o.Items, o.Items[0])
+				return o
+			},
+			wantError: func(t require.TestingT, err error, _ ...any) {
+				require.ErrorContains(t, err, "domain.ValidateOrderItems: product[")
+				require.NotErrorIs(t, err, repository.ErrConstraintViolation)
+			},
+		},
+		{
+			name: "valid order, nil tags, nil url: ok",
+			buildOrder: func() domain.Order {
+				o := RandomOrder()
+				o.Tags = nil
+				o.Url = nil
+				return o
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+
+			ttOrder := tt.buildOrder()
+
+			orderID, err := repo.InsertOrder(ctx, ttOrder)
+			if tt.wantError != nil {
+				tt.wantError(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			actualOrder, err := repo.GetOrder(ctx, orderID)
+			require.NoError(t, err)
+
+			expected := ttOrder
+			expected.ID = orderID
+			expected.Status = domain.OrderStatusPending
+
+			AssertOrder(t, expected, actualOrder)
+			assert.Equal(t, int64(1), actualOrder.Version)
+		})
+	}
+}
+
+func testSearchOrders(t *testing.T, repo port.OrderRepository) {
+	order1 := RandomOrder()
+	order2 := RandomOrder()
+	// random tags may repeat across the orders
+	order1.Tags[0] = "tag-" + gofakeit.UUID()
+	orderIDs := insertOrders(t, repo, order1, order2)
+
+	tests := []struct {
+		name       string
+		filter     domain.OrderFilter
+		wantOrders []domain.Order
+		wantError  require.ErrorAssertionFunc
+	}{
+		{
+			name:      "empty filter: error",
+			filter:    domain.OrderFilter{},
+			wantError: require.Error,
+		},
+		{
+			name: "search by ids: 1 found",
+			filter: domain.OrderFilter{
+				IDs: []uuid.UUID{orderIDs[0]},
+			},
+			wantOrders: []domain.Order{order1},
+		},
+		{
+			name: "search by ids: 2 found",
+			filter: domain.OrderFilter{
+				IDs: []uuid.UUID{orderIDs[0], orderIDs[1]},
+			},
+			wantOrders: []domain.Order{order1, order2},
+		},
+		{
+			name: "search by ids: not found",
+			filter: domain.OrderFilter{
+				IDs: []uuid.UUID{uuid.MustParse(gofakeit.UUID())},
+			},
+		},
+		{
+			name: "search by owner ids: 1 found",
+			filter: domain.OrderFilter{
+				OwnerIDs: []string{order1.OwnerID},
+			},
+			wantOrders: []domain.Order{order1},
+		},
+		{
+			name: "search by owner ids: 2 found",
+			filter: domain.OrderFilter{
+				OwnerIDs: []string{order1.OwnerID, order2.OwnerID},
+			},
+			wantOrders: []domain.Order{order1, order2},
+		},
+		{
+			name: "search by owner ids: not found",
+			filter: domain.OrderFilter{
+				OwnerIDs: []string{"not found"},
+			},
+		},
+		{
+			name: "search by URL patterns: 1 found",
+			filter: domain.OrderFilter{
+				UrlPatterns: []string{order1.Url.String()},
+			},
+			wantOrders: []domain.Order{order1},
+		},
+		{
+			name: "search by URL patterns: not found",
+			filter: domain.OrderFilter{
+				UrlPatterns: []string{"not found"},
+			},
+		},
+		{
+			name: "search by status pending: 2 found",
+			filter: domain.OrderFilter{
+				Statuses: []domain.OrderStatus{domain.OrderStatusPending},
+			},
+			wantOrders: []domain.Order{order1, order2},
+		},
+		{
+			name: "search by status shipped: not found",
+			filter: domain.OrderFilter{
+				Statuses: []domain.OrderStatus{domain.OrderStatusShipped},
+			},
+		},
+		{
+			name: "search by tags: 1 found",
+			filter: domain.OrderFilter{
+				Tags: []string{order1.Tags[0]},
+			},
+			wantOrders: []domain.Order{order1},
+		},
+		{
+			name: "search by tags: not found",
+			filter: domain.OrderFilter{
+				Tags: []string{"not found"},
+			},
+		},
+		{
+			name: "search by createdAt after: 2 found",
+			filter: domain.OrderFilter{
+				CreatedAt: lo.ToPtr(domain.TimeRange{
+					After: lo.ToPtr(time.Now().UTC().Add(-1 * time.Minute)),
+				}),
+			},
+			wantOrders: []domain.Order{order1, order2},
+		},
+		{
+			name: "search by createdAt after: not found",
+			filter: domain.OrderFilter{
+				CreatedAt: lo.ToPtr(domain.TimeRange{
+					After: lo.ToPtr(time.Now().UTC().Add(1 * time.Minute)),
+				}),
+			},
+		},
+		{
+			name: "search by createdAt before: not found",
+			filter: domain.OrderFilter{
+				CreatedAt: lo.ToPtr(domain.TimeRange{
+					Before: lo.ToPtr(time.Now().UTC().Add(-1 * time.Minute)),
+				}),
+			},
+		},
+		{
+			name: "search by createdAt before: 2 found",
+			filter: domain.OrderFilter{
+				CreatedAt: lo.ToPtr(domain.TimeRange{
+					Before: lo.ToPtr(time.Now().UTC().Add(1 * time.Minute)),
+				}),
+			},
+			wantOrders: []domain.Order{order1, order2},
+		},
+		{
+			name: "search by createdAt empty: error",
+			filter: domain.OrderFilter{
+				CreatedAt: lo.ToPtr(domain.TimeRange{}),
+			},
+			wantError: require.Error,
+		},
+		{
+			name: "search by createdAt before and after: 2 found",
+			filter: domain.OrderFilter{
+				CreatedAt: lo.ToPtr(domain.TimeRange{
+					Before: lo.ToPtr(time.Now().UTC().Add(1 * time.Minute)),
+					After:  lo.ToPtr(time.Now().UTC().Add(-1 * time.Minute)),
+				}),
+			},
+			wantOrders: []domain.Order{order1, order2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := repo.SearchOrders(t.Context(), tt.filter)
+			if tt.wantError != nil {
+				tt.wantError(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			AssertOrders(t, tt.wantOrders, orders)
+		})
+	}
+}
+
+func testDeleteOrder(t *testing.T, repo port.OrderRepository) {
+	tests := []struct {
+		name         string
+		arrangeState func(context.Context, uuid.UUID) error // arrange state for test case before the operation, i.e. soft-delete the order
+		useOrderID   func() uuid.UUID                       // override which order ID to use, if nil use the inserted one
+		wantError    require.ErrorAssertionFunc
+	}{
+		{
+			name: "delete existing order: ok",
+		},
+		{
+			name: "delete non-existing order: not found",
+			useOrderID: func() uuid.UUID {
+				return uuid.MustParse(gofakeit.UUID())
+			},
+			wantError: errorIs(repository.ErrNotFound),
+		},
+		{
+			name: "delete with empty order ID: error",
+			useOrderID: func() uuid.UUID {
+				return uuid.Nil
+			},
+			wantError: require.Error,
+		},
+		{
+			name:         "delete soft-deleted order: ok",
+			arrangeState: repo.SoftDeleteOrder,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+
+			orderID, err := repo.InsertOrder(ctx, RandomOrder())
+			require.NoError(t, err)
+
+			if tt.arrangeState != nil {
+				err := tt.arrangeState(ctx, orderID)
+				require.NoError(t, err)
+			}
+
+			toDeleteOrderID := orderID
+			if tt.useOrderID != nil {
+				toDeleteOrderID = tt.useOrderID()
+			}
+
+			err = repo.DeleteOrder(ctx, toDeleteOrderID)
+			if tt.wantError != nil {
+				tt.wantError(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			// Verify the order is deleted
+			_, err = repo.GetOrder(ctx, orderID)
+			require.ErrorIs(t, err, repository.ErrNotFound)
+		})
+	}
+}
+
+func testSoftDeleteOrder(t *testing.T, repo port.OrderRepository) {
+	tests := []struct {
+		name         string
+		useOrderID   func() uuid.UUID                       // override which order ID to use, if nil use the inserted one
+		arrangeState func(context.Context, uuid.UUID) error // arrange state for test case before the operation, i.e. delete the order
+		wantError    require.ErrorAssertionFunc
+	}{
+		{
+			name: "soft-delete existing order: ok",
+		},
+		{
+			name: "soft-delete non-existing order: not found",
+			useOrderID: func() uuid.UUID {
+				return uuid.MustParse(gofakeit.UUID())
+			},
+			wantError: errorIs(repository.ErrNotFound),
+		},
+		{
+			name: "soft-delete with empty order ID: error",
+			useOrderID: func() uuid.UUID {
+				return uuid.Nil
+			},
+			wantError: require.Error,
+		},
+		{
+			name:         "soft-delete deleted order: not found",
+			arrangeState: repo.DeleteOrder,
+			wantError:    errorIs(repository.ErrNotFound),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+
+			orderID, err := repo.InsertOrder(ctx, RandomOrder())
+			require.NoError(t, err)
+
+			toDeleteOrderID := orderID
+			if tt.useOrderID != nil {
+				toDeleteOrderID = tt.useOrderID()
+			}
+
+			if tt.arrangeState != nil {
+				err := tt.arrangeState(ctx, orderID)
+				require.NoError(t, err)
+			}
+
+			err = repo.SoftDeleteOrder(ctx, toDeleteOrderID)
+			if tt.wantError != nil {
+				tt.wantError(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			// Verify the order is soft-deleted
+			_, err = repo.GetOrder(ctx, orderID)
+			require.ErrorIs(t, err, repository.ErrNotFound)
+		})
+	}
+}
+
+func insertOrders(t *testing.T, repo port.OrderRepository, orders ...domain.Order) []uuid.UUID {
+	t.Helper()
+
+	ids := make([]uuid.UUID, 0, len(orders))
+
+	for _, order := range orders {
+		id, err := repo.InsertOrder(t.Context(), order)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func errorIs(target error) require.ErrorAssertionFunc {
+	return func(t require.TestingT, err error, msgAndArgs ...interface{}) {
+		require.ErrorIs(t, err, target, msgAndArgs...)
+	}
+}
+
+// AssertOrder compares the fields a caller sets on insert, the generated ones are only checked to be set.
+func AssertOrder(t *testing.T, expected, actual domain.Order) {
+	t.Helper()
+
+	currencyComparer := cmp.Comparer(func(x, y currency.Unit) bool {
+		return x.String() == y.String()
+	})
+
+	jsonComparer := cmp.Comparer(func(x, y []byte) bool {
+		if x == nil && y == nil {
+			return true
+		}
+
+		var normalizedX, normalizedY interface{}
+
+		if err := json.Unmarshal(x, &normalizedX); err != nil {
+			return false
+		}
+		if err := json.Unmarshal(y, &normalizedY); err != nil {
+			return false
+		}
+
+		return cmp.Equal(normalizedX, normalizedY)
+	})
+
+	// Ignore the CreatedAt field in OrderItem and
+	// Treat empty slices as equal to nil
+	// Items are compared regardless of their order
+	opts := cmp.Options{
+		cmpopts.IgnoreFields(domain.OrderItem{}, "CreatedAt"),
+		cmpopts.SortSlices(func(a, b domain.OrderItem) bool {
+			return a.ProductID.String() < b.ProductID.String()
+		}),
+		cmpopts.IgnoreFields(domain.Order{}, "CreatedAt", "UpdatedAt", "ID", "Status", "Version", "IdempotencyKey"),
+		currencyComparer,
+		cmp.FilterPath(func(p cmp.Path) bool {
+			return p.Last().String() == ".Payload" || p.Last().String() == ".PayloadB"
+		}, jsonComparer),
+	}
+
+	diff := cmp.Diff(expected, actual, opts)
+	assert.Empty(t, diff)
+
+	assert.False(t, actual.CreatedAt.IsZero())
+	assert.False(t, actual.UpdatedAt.IsZero())
+	assert.Nil(t, actual.DeletedAt)
+	assert.NotEqual(t, uuid.Nil, actual.ID)
+	assert.Positive(t, actual.Version)
+}
+
+// AssertOrders is AssertOrder for orders in any order.
+func AssertOrders(t *testing.T, expected, actual []domain.Order) {
+	t.Helper()
+
+	sortOrders := func(orders []domain.Order) {
+		sort.Slice(orders, func(i, j int) bool {
+			return orders[i].OwnerID < orders[j].OwnerID
+		})
+	}
+
+	sortOrders(expected)
+	sortOrders(actual)
+
+	require.Equal(t, len(expected), len(actual))
+
+	for i := range expected {
+		AssertOrder(t, expected[i], actual[i])
+	}
+}
@@ -0,0 +1,101 @@
+package repository_test
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/nikolayk812/sqlcpp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func (suite *orderRepositorySuite) TestTracing() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+
+	cfg, err := pgxpool.ParseConfig(suite.connStr)
+	require.NoError(t, err)
+	cfg.ConnConfig.Tracer = tracing.NewQueryTracer(tracing.WithTracerProvider(tp))
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	inner, err := repository.NewOrder(pool)
+	require.NoError(t, err)
+
+	repo, err := tracing.NewOrderRepository(inner, tracing.WithTracerProvider(tp))
+	require.NoError(t, err)
+
+	suite.Run("insert commits", func() {
+		t := suite.T()
+		recorder.Reset()
+
+		order := randomOrder()
+		_, err := repo.InsertOrder(t.Context(), order)
+		require.NoError(t, err)
+
+		spans := childSpans(recorder, "OrderRepository.InsertOrder")
+		require.NotEmpty(t, spans["BEGIN"])
+		require.NotEmpty(t, spans["COMMIT"])
+		assert.Empty(t, spans["ROLLBACK"])
+		require.Len(t, spans["BATCH"], 1)
+
+		batch := spans["BATCH"][0]
+		items := 0
+		for _, span := range recorder.Ended() {
+			if span.Name() == "InsertOrderItem" && span.Parent().SpanID() == batch.SpanContext().SpanID() {
+				items++
+			}
+		}
+		assert.Equal(t, len(order.Items), items)
+	})
+
+	suite.Run("failed insert rolls back", func() {
+		t := suite.T()
+		recorder.Reset()
+
+		order := randomOrder()
+		order.Payload = []byte("{broken")
+		_, err := repo.InsertOrder(t.Context(), order)
+		require.Error(t, err)
+
+		spans := childSpans(recorder, "OrderRepository.InsertOrder")
+		require.NotEmpty(t, spans["BEGIN"])
+		require.NotEmpty(t, spans["ROLLBACK"])
+		assert.Empty(t, spans["COMMIT"])
+	})
+}
+
+// childSpans groups the direct children of the single ended span with the given name by their names.
+func childSpans(recorder *tracetest.SpanRecorder, parentName string) map[string][]sdktrace.ReadOnlySpan {
+	var parent sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == parentName {
+			parent = span
+		}
+	}
+	if parent == nil {
+		return nil
+	}
+
+	children := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() == parent.SpanContext().SpanID() {
+			children[span.Name()] = append(children[span.Name()], span)
+		}
+	}
+
+	return children
+}
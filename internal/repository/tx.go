@@ -4,33 +4,182 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand/v2"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nikolayk812/sqlcpp/internal/db"
 )
 
+const (
+	defaultTxMaxRetries    = 3
+	defaultTxRetryBaseWait = 10 * time.Millisecond
+	defaultTxRetryMaxWait  = 500 * time.Millisecond
+)
+
+type txConfig struct {
+	pgx.TxOptions
+	maxRetries    int
+	retryBaseWait time.Duration
+	retryMaxWait  time.Duration
+	observer      TxObserver
+	statements    *statementLogger
+}
+
+// TxObserver is told about the retries and rollbacks of the transactions started by the repository, i.e. to count them.
+type TxObserver interface {
+	// TxRetried is called before a transaction is re-run after err
+	TxRetried(err error)
+	// TxRolledBack is called when a transaction or a savepoint is rolled back
+	TxRolledBack()
+}
+
+// TxOption configures the transactions started by the repository.
+type TxOption func(*txConfig)
+
+// WithIsolationLevel sets the isolation level, i.e. pgx.Serializable or pgx.RepeatableRead,
+// the default is the one of the database, normally READ COMMITTED.
+func WithIsolationLevel(level pgx.TxIsoLevel) TxOption {
+	return func(c *txConfig) {
+		c.IsoLevel = level
+	}
+}
+
+// WithReadOnly starts read-only transactions.
+func WithReadOnly() TxOption {
+	return func(c *txConfig) {
+		c.AccessMode = pgx.ReadOnly
+	}
+}
+
+// WithDeferrable starts deferrable transactions, it only has an effect on serializable read-only ones.
+func WithDeferrable() TxOption {
+	return func(c *txConfig) {
+		c.DeferrableMode = pgx.Deferrable
+	}
+}
+
+// WithMaxRetries sets how many times a transaction is re-run after a serialization failure or a deadlock,
+// 0 disables the retries. The default is 3.
+func WithMaxRetries(n int) TxOption {
+	return func(c *txConfig) {
+		c.maxRetries = max(n, 0)
+	}
+}
+
+// WithRetryBackoff sets the wait before the first retry, it doubles with every retry up to maxWait.
+// The actual wait is a random duration up to that value.
+func WithRetryBackoff(baseWait, maxWait time.Duration) TxOption {
+	return func(c *txConfig) {
+		c.retryBaseWait = baseWait
+		c.retryMaxWait = max(maxWait, baseWait)
+	}
+}
+
+// WithTxObserver reports the retries and rollbacks to o, including the rollbacks of savepoints.
+func WithTxObserver(o TxObserver) TxOption {
+	return func(c *txConfig) {
+		c.observer = o
+	}
+}
+
+func newTxConfig(opts []TxOption) txConfig {
+	c := txConfig{
+		maxRetries:    defaultTxMaxRetries,
+		retryBaseWait: defaultTxRetryBaseWait,
+		retryMaxWait:  defaultTxRetryMaxWait,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// retryWait returns a jittered wait before the retry number attempt, which starts at 1.
+func (c txConfig) retryWait(attempt int) time.Duration {
+	wait := c.retryMaxWait
+	if shift := attempt - 1; shift < 31 && c.retryBaseWait<<shift < c.retryMaxWait {
+		wait = c.retryBaseWait << shift
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+
+	return rand.N(wait + 1)
+}
+
+// TxBeginner starts transactions, i.e. *pgxpool.Pool or a db.DBTX wrapping it, see the dbtxmw package.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+var _ TxBeginner = (*pgxpool.Pool)(nil)
+
 // withTx executes fn within a transaction if the repository was created with a pool,
-// or uses the existing transaction if the repository was created with a transaction
-func withTx[T any](ctx context.Context, dbtx db.DBTX, fn func(q *db.Queries) (T, error)) (_ T, txErr error) {
+// or within a savepoint of the existing transaction if the repository was created with a transaction.
+// A failed nested call is rolled back to its savepoint, so the caller may go on with the outer transaction.
+// Database errors are classified with mapDBError.
+// A transaction started by withTx is re-run on ErrSerialization, so fn must not have side effects outside of it.
+func withTx[T any](ctx context.Context, dbtx db.DBTX, fn func(q *db.Queries) (T, error), opts ...TxOption) (T, error) {
 	var zero T
 
+	cfg := newTxConfig(opts)
+
 	// Check if we're already in a transaction by trying to cast to pgx.Tx
 	if tx, ok := dbtx.(pgx.Tx); ok {
-		// Already in a transaction, just use it
-		q := db.New(tx)
-		return fn(q)
+		// Already in a transaction, tx.Begin creates a savepoint, only the owner of tx can retry it
+		return runTx(ctx, tx.Begin, fn, cfg)
 	}
 
-	// Must be a pool, create a new transaction
-	pool, ok := dbtx.(*pgxpool.Pool)
+	// Must be a pool or a wrapper of it, create a new transaction
+	beginner, ok := dbtx.(TxBeginner)
 	if !ok {
-		return zero, fmt.Errorf("dbtx is neither pgx.Tx nor *pgxpool.Pool: %T", dbtx)
+		return zero, fmt.Errorf("dbtx is neither pgx.Tx nor TxBeginner: %T", dbtx)
 	}
 
-	tx, err := pool.Begin(ctx)
+	return retryTx(ctx, beginner, fn, cfg)
+}
+
+// retryTx executes fn within a new transaction of beginner, re-running it on ErrSerialization.
+func retryTx[T any](ctx context.Context, beginner TxBeginner, fn func(q *db.Queries) (T, error), cfg txConfig) (T, error) {
+	var zero T
+
+	begin := func(ctx context.Context) (pgx.Tx, error) {
+		return beginner.BeginTx(ctx, cfg.TxOptions)
+	}
+
+	for attempt := 0; ; attempt++ {
+		result, err := runTx(ctx, begin, fn, cfg)
+		if err == nil || !errors.Is(err, ErrSerialization) || attempt >= cfg.maxRetries {
+			return result, err
+		}
+
+		if cfg.observer != nil {
+			cfg.observer.TxRetried(err)
+		}
+
+		timer := time.NewTimer(cfg.retryWait(attempt + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return zero, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// runTx runs fn in the transaction or savepoint started by begin,
+// committing releases a savepoint and rolling back returns to it.
+func runTx[T any](ctx context.Context, begin func(ctx context.Context) (pgx.Tx, error), fn func(q *db.Queries) (T, error), cfg txConfig) (_ T, txErr error) {
+	var zero T
+
+	tx, err := begin(ctx)
 	if err != nil {
-		return zero, err
+		return zero, mapDBError(err)
 	}
 
 	// Ensure proper rollback handling
@@ -40,21 +189,25 @@ func withTx[T any](ctx context.Context, dbtx db.DBTX, fn func(q *db.Queries) (T,
 			if rollbackErr != nil && !errors.Is(rollbackErr, pgx.ErrTxClosed) {
 				txErr = errors.Join(txErr, fmt.Errorf("tx.Rollback: %w", rollbackErr))
 			}
+
+			if cfg.observer != nil {
+				cfg.observer.TxRolledBack()
+			}
 		}
 	}()
 
 	// Create queries with transaction
-	qtx := db.New(tx)
+	qtx := db.New(cfg.statements.wrap(tx))
 
 	// Execute the function with transaction queries
 	result, err := fn(qtx)
 	if err != nil {
-		return zero, err
+		return zero, mapDBError(err)
 	}
 
 	// Commit the transaction
 	if err := tx.Commit(ctx); err != nil {
-		return zero, err
+		return zero, mapDBError(err)
 	}
 
 	return result, nil
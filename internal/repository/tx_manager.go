@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+)
+
+type txManager struct {
+	beginner TxBeginner
+	txOpts   []TxOption
+}
+
+// NewTxManager creates a TxManager which starts transactions on beginner with opts, i.e. a pool or a pool
+// wrapped with dbtxmw.Wrap, whose middlewares then see the statements within the transactions.
+// Repositories join the transactions through the context whatever dbtx they were created with.
+func NewTxManager(beginner TxBeginner, opts ...TxOption) (port.TxManager, error) {
+	if beginner == nil {
+		return nil, fmt.Errorf("beginner is nil")
+	}
+
+	return &txManager{
+		beginner: beginner,
+		txOpts:   opts,
+	}, nil
+}
+
+// WithinTx starts a savepoint in the transaction of ctx if there is one, so nested calls form a single unit of work.
+// The functions given to AfterCommit within fn run once the outermost transaction has committed.
+func (m *txManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if fn == nil {
+		return fmt.Errorf("fn is nil")
+	}
+
+	// a retried transaction registers its functions anew
+	body := func(q *db.Queries) (*afterCommitFuncs, error) {
+		tx, ok := q.DB().(pgx.Tx)
+		if !ok {
+			return nil, fmt.Errorf("q.DB() is not pgx.Tx")
+		}
+
+		funcs := &afterCommitFuncs{}
+
+		return funcs, fn(context.WithValue(contextWithTx(ctx, tx), afterCommitKey{}, funcs))
+	}
+
+	if tx, ok := txFromContext(ctx); ok {
+		funcs, err := withTx(ctx, tx, body, m.txOpts...)
+		if err != nil {
+			return fmt.Errorf("withTx: %w", err)
+		}
+
+		// the savepoint is released, its functions wait for the outer transaction
+		if outer, ok := ctx.Value(afterCommitKey{}).(*afterCommitFuncs); ok {
+			outer.add(funcs.take()...)
+		}
+
+		return nil
+	}
+
+	funcs, err := retryTx(ctx, m.beginner, body, newTxConfig(m.txOpts))
+	if err != nil {
+		return fmt.Errorf("retryTx: %w", err)
+	}
+
+	for _, f := range funcs.take() {
+		f()
+	}
+
+	return nil
+}
+
+type afterCommitKey struct{}
+
+// afterCommitFuncs are the functions registered within a transaction or a savepoint of WithinTx,
+// fn may register them from several goroutines.
+type afterCommitFuncs struct {
+	mu    sync.Mutex
+	funcs []func()
+}
+
+func (a *afterCommitFuncs) add(funcs ...func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.funcs = append(a.funcs, funcs...)
+}
+
+func (a *afterCommitFuncs) take() []func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	funcs := a.funcs
+	a.funcs = nil
+
+	return funcs
+}
+
+// AfterCommit runs f once the transaction of TxManager.WithinTx in ctx has committed,
+// i.e. to invalidate a cache, f is dropped if the transaction or the savepoint of ctx is rolled back.
+// Without a transaction in ctx f runs right away.
+func AfterCommit(ctx context.Context, f func()) {
+	funcs, ok := ctx.Value(afterCommitKey{}).(*afterCommitFuncs)
+	if !ok {
+		f()
+		return
+	}
+
+	funcs.add(f)
+}
+
+type txKey struct{}
+
+func contextWithTx(ctx context.Context, tx pgx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// InTx reports whether ctx carries a transaction started by TxManager.WithinTx,
+// i.e. so a cache does not serve or store what the transaction has not committed.
+func InTx(ctx context.Context) bool {
+	_, ok := txFromContext(ctx)
+	return ok
+}
+
+func txFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
@@ -0,0 +1,188 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestTxManager() {
+	defer suite.deleteAll()
+
+	txManager, err := repository.NewTxManager(suite.pool)
+	suite.Require().NoError(err)
+
+	suite.Run("insert and update status in one unit of work: committed", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		existingID := suite.insertOrders(randomOrder())[0]
+
+		var insertedID uuid.UUID
+		err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			insertedID, err = suite.repo.InsertOrder(ctx, randomOrder())
+			if err != nil {
+				return err
+			}
+
+			// the uncommitted order is visible inside the transaction only
+			if _, err := suite.repo.GetOrder(ctx, insertedID); err != nil {
+				return err
+			}
+			if _, err := suite.repo.GetOrder(t.Context(), insertedID); !errors.Is(err, repository.ErrNotFound) {
+				return errors.New("uncommitted order is visible outside of the transaction")
+			}
+
+			return suite.repo.UpdateOrderStatus(ctx, existingID, domain.OrderStatusShipped, 1)
+		})
+		require.NoError(t, err)
+
+		_, err = suite.repo.GetOrder(ctx, insertedID)
+		require.NoError(t, err)
+
+		existing, err := suite.repo.GetOrder(ctx, existingID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.OrderStatusShipped, existing.Status)
+	})
+
+	suite.Run("repository error: rolled back", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		existingID := suite.insertOrders(randomOrder())[0]
+
+		var insertedID uuid.UUID
+		err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			insertedID, err = suite.repo.InsertOrder(ctx, randomOrder())
+			if err != nil {
+				return err
+			}
+
+			// stale version
+			return suite.repo.UpdateOrderStatus(ctx, existingID, domain.OrderStatusShipped, 2)
+		})
+		require.ErrorIs(t, err, repository.ErrConcurrentModification)
+
+		_, err = suite.repo.GetOrder(ctx, insertedID)
+		require.ErrorIs(t, err, repository.ErrNotFound)
+		assert.Equal(t, 0, suite.countRows("SELECT COUNT(*) FROM order_outbox WHERE order_id = $1", insertedID))
+	})
+
+	suite.Run("fn error: rolled back and returned", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		fnErr := errors.New("payment declined")
+
+		var insertedID uuid.UUID
+		err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			insertedID, err = suite.repo.InsertOrder(ctx, randomOrder())
+			if err != nil {
+				return err
+			}
+			return fnErr
+		})
+		require.ErrorIs(t, err, fnErr)
+
+		_, err = suite.repo.GetOrder(ctx, insertedID)
+		require.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	suite.Run("nested WithinTx: joins the outer transaction", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		var insertedID uuid.UUID
+		err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+			if err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+				var err error
+				insertedID, err = suite.repo.InsertOrder(ctx, randomOrder())
+				return err
+			}); err != nil {
+				return err
+			}
+
+			return errors.New("abort the outer transaction")
+		})
+		require.Error(t, err)
+
+		_, err = suite.repo.GetOrder(ctx, insertedID)
+		require.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	suite.Run("nested WithinTx fails: rolled back to its savepoint", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		var outerID, innerID uuid.UUID
+		err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			outerID, err = suite.repo.InsertOrder(ctx, randomOrder())
+			if err != nil {
+				return err
+			}
+
+			innerErr := txManager.WithinTx(ctx, func(ctx context.Context) error {
+				var err error
+				innerID, err = suite.repo.InsertOrder(ctx, randomOrder())
+				if err != nil {
+					return err
+				}
+				return errors.New("abort the nested transaction")
+			})
+			if innerErr == nil {
+				return errors.New("nested WithinTx did not fail")
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		_, err = suite.repo.GetOrder(ctx, outerID)
+		require.NoError(t, err)
+
+		_, err = suite.repo.GetOrder(ctx, innerID)
+		require.ErrorIs(t, err, repository.ErrNotFound)
+	})
+	suite.Run("AfterCommit: runs after the outermost commit, dropped with a rolled back savepoint", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		var ran []string
+		err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+			repository.AfterCommit(ctx, func() { ran = append(ran, "outer") })
+
+			if err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+				repository.AfterCommit(ctx, func() { ran = append(ran, "released") })
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			_ = txManager.WithinTx(ctx, func(ctx context.Context) error {
+				repository.AfterCommit(ctx, func() { ran = append(ran, "rolled back") })
+				return errors.New("abort the nested transaction")
+			})
+
+			if len(ran) != 0 {
+				return errors.New("AfterCommit ran before the commit")
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"outer", "released"}, ran)
+
+		// without a transaction it runs right away
+		repository.AfterCommit(ctx, func() { ran = append(ran, "no tx") })
+		assert.Equal(t, []string{"outer", "released", "no tx"}, ran)
+	})
+}
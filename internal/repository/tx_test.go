@@ -0,0 +1,172 @@
+package repository_test
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *orderRepositorySuite) TestWithTx_RetrySerializationFailure() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name       string
+		maxRetries int
+		wantError  error
+		wantRetry  bool
+	}{
+		{
+			name:       "retries enabled: ok",
+			maxRetries: 3,
+			wantRetry:  true,
+		},
+		{
+			name:       "retries disabled: serialization failure",
+			maxRetries: 0,
+			wantError:  repository.ErrSerialization,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			observer := &countingTxObserver{}
+
+			repo, err := repository.NewOrder(suite.pool, repository.WithTxOptions(
+				repository.WithIsolationLevel(pgx.RepeatableRead),
+				repository.WithMaxRetries(tt.maxRetries),
+				repository.WithRetryBackoff(time.Millisecond, 10*time.Millisecond),
+				repository.WithTxObserver(observer),
+			))
+			require.NoError(t, err)
+
+			orderID := suite.insertOrders(randomOrder())[0]
+
+			// a concurrent transaction updates the order without bumping its version
+			blocker, err := suite.pool.Begin(ctx)
+			require.NoError(t, err)
+			defer blocker.Rollback(ctx)
+
+			_, err = blocker.Exec(ctx, "UPDATE orders SET updated_at = NOW() WHERE id = $1", orderID)
+			require.NoError(t, err)
+
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- repo.AddTags(ctx, orderID, []string{"retried"}, 1)
+			}()
+
+			// the repository transaction takes its snapshot and waits for the row lock,
+			// once the blocker commits the snapshot is stale and the lock fails with 40001
+			require.Eventually(t, func() bool {
+				var waiting int
+				err := suite.pool.QueryRow(ctx, `SELECT COUNT(*)
+FROM pg_stat_activity
+WHERE wait_event_type = 'Lock'
+  AND query LIKE '%GetOrderVersionForUpdate%'`).Scan(&waiting)
+				return err == nil && waiting == 1
+			}, 5*time.Second, 10*time.Millisecond)
+
+			require.NoError(t, blocker.Commit(ctx))
+
+			err = <-errCh
+
+			// the failed attempt is rolled back whether it is retried or not
+			assert.Equal(t, int64(1), observer.rollbacks.Load())
+			assert.Equal(t, tt.wantRetry, observer.retries.Load() == 1)
+
+			if tt.wantError != nil {
+				require.ErrorIs(t, err, tt.wantError)
+				assert.Equal(t, int64(1), suite.orderVersion(orderID))
+				return
+			}
+			require.NoError(t, err)
+
+			order, err := suite.repo.GetOrder(ctx, orderID)
+			require.NoError(t, err)
+			assert.Contains(t, order.Tags, "retried")
+			assert.Equal(t, int64(2), order.Version)
+		})
+	}
+}
+
+type countingTxObserver struct {
+	retries   atomic.Int64
+	rollbacks atomic.Int64
+}
+
+func (o *countingTxObserver) TxRetried(error) {
+	o.retries.Add(1)
+}
+
+func (o *countingTxObserver) TxRolledBack() {
+	o.rollbacks.Add(1)
+}
+
+func (suite *orderRepositorySuite) TestWithTx_ReadOnly() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	repo, err := repository.NewOrder(suite.pool, repository.WithTxOptions(repository.WithReadOnly()))
+	require.NoError(t, err)
+
+	orderID := suite.insertOrders(randomOrder())[0]
+
+	// reads in a transaction work
+	_, err = repo.GetOrderSeparateQueries(ctx, orderID)
+	require.NoError(t, err)
+
+	// SQLSTATE 25006 read_only_sql_transaction
+	err = repo.SoftDeleteOrder(ctx, orderID)
+	require.ErrorContains(t, err, "25006")
+}
+
+func (suite *orderRepositorySuite) TestWithTx_Savepoint() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	orderID := suite.insertOrders(randomOrder())[0]
+	order, err := suite.repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+
+	tx, err := suite.pool.Begin(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+
+	txRepo, err := repository.NewOrder(tx)
+	require.NoError(t, err)
+
+	// the item is soft-deleted, then the missing item fails the second call
+	require.NoError(t, txRepo.SoftDeleteOrderItem(ctx, orderID, order.Items[0].ProductID))
+	err = txRepo.SoftDeleteOrderItem(ctx, orderID, order.Items[0].ProductID)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+
+	// a database error is rolled back to the savepoint and does not abort tx
+	broken := randomOrderWithID(uuid.New())
+	broken.Payload = []byte(`{"broken":`)
+	_, err = txRepo.InsertOrder(ctx, broken)
+	require.ErrorIs(t, err, repository.ErrInvalidInput)
+
+	insertedID, err := txRepo.InsertOrder(ctx, randomOrder())
+	require.NoError(t, err)
+
+	require.NoError(t, tx.Commit(ctx))
+
+	_, err = suite.repo.GetOrder(ctx, insertedID)
+	require.NoError(t, err)
+
+	actual, err := suite.repo.GetOrder(ctx, orderID)
+	require.NoError(t, err)
+	assert.Len(t, actual.Items, len(order.Items)-1)
+	assert.Equal(t, 0, suite.countRows("SELECT COUNT(*) FROM orders WHERE id = $1", broken.ID))
+}
@@ -0,0 +1,326 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	orderIDKey         = attribute.Key("order.id")
+	productIDKey       = attribute.Key("order.product_id")
+	expectedVersionKey = attribute.Key("order.expected_version")
+	statusKey          = attribute.Key("order.status")
+	lockModeKey        = attribute.Key("order.lock_mode")
+	itemCountKey       = attribute.Key("order.item_count")
+	tagCountKey        = attribute.Key("order.tag_count")
+	orderCountKey      = attribute.Key("order.count")
+	failedCountKey     = attribute.Key("order.failed_count")
+	historyCountKey    = attribute.Key("order.history_count")
+	filterFieldsKey    = attribute.Key("order.filter.fields")
+	filterDeletedKey   = attribute.Key("order.filter.deleted")
+	pageSizeKey        = attribute.Key("order.page.size")
+	pageSortKey        = attribute.Key("order.page.sort_key")
+	pageHasNextKey     = attribute.Key("order.page.has_next")
+	purgeOlderThanKey  = attribute.Key("order.purge.older_than")
+	purgeBatchSizeKey  = attribute.Key("order.purge.batch_size")
+	purgedOrdersKey    = attribute.Key("order.purge.orders")
+	purgedItemsKey     = attribute.Key("order.purge.items")
+)
+
+type orderRepository struct {
+	next   port.OrderRepository
+	tracer trace.Tracer
+}
+
+// NewOrderRepository wraps next so that each of its methods runs in a span,
+// the spans of the SQL statements are children of it when the pool has a QueryTracer.
+func NewOrderRepository(next port.OrderRepository, opts ...Option) (port.OrderRepository, error) {
+	if next == nil {
+		return nil, fmt.Errorf("next is nil")
+	}
+
+	return &orderRepository{
+		next:   next,
+		tracer: newTracer(opts),
+	}, nil
+}
+
+func (r *orderRepository) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return r.tracer.Start(ctx, "OrderRepository."+method, trace.WithAttributes(attrs...))
+}
+
+func (r *orderRepository) GetOrder(ctx context.Context, orderID uuid.UUID) (_ domain.Order, err error) {
+	ctx, span := r.start(ctx, "GetOrder", orderIDKey.String(orderID.String()))
+	defer func() { endSpan(span, err) }()
+
+	order, err := r.next.GetOrder(ctx, orderID)
+	span.SetAttributes(itemCountKey.Int(len(order.Items)))
+
+	return order, err
+}
+
+func (r *orderRepository) GetOrderSeparateQueries(ctx context.Context, orderID uuid.UUID) (_ domain.Order, err error) {
+	ctx, span := r.start(ctx, "GetOrderSeparateQueries", orderIDKey.String(orderID.String()))
+	defer func() { endSpan(span, err) }()
+
+	order, err := r.next.GetOrderSeparateQueries(ctx, orderID)
+	span.SetAttributes(itemCountKey.Int(len(order.Items)))
+
+	return order, err
+}
+
+func (r *orderRepository) GetOrderForUpdate(ctx context.Context, orderID uuid.UUID, mode domain.LockMode) (_ domain.Order, err error) {
+	ctx, span := r.start(ctx, "GetOrderForUpdate", orderIDKey.String(orderID.String()), lockModeKey.String(string(mode)))
+	defer func() { endSpan(span, err) }()
+
+	order, err := r.next.GetOrderForUpdate(ctx, orderID, mode)
+	span.SetAttributes(itemCountKey.Int(len(order.Items)))
+
+	return order, err
+}
+
+func (r *orderRepository) LockOrder(ctx context.Context, orderID uuid.UUID) (err error) {
+	ctx, span := r.start(ctx, "LockOrder", orderIDKey.String(orderID.String()))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.LockOrder(ctx, orderID)
+}
+
+func (r *orderRepository) SearchOrders(ctx context.Context, filter domain.OrderFilter) (_ []domain.Order, err error) {
+	ctx, span := r.start(ctx, "SearchOrders", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+
+	orders, err := r.next.SearchOrders(ctx, filter)
+	span.SetAttributes(orderCountKey.Int(len(orders)))
+
+	return orders, err
+}
+
+func (r *orderRepository) SearchOrdersPage(ctx context.Context, filter domain.OrderFilter, page domain.PageRequest) (_ domain.OrderPage, err error) {
+	attrs := append(filterAttributes(filter), pageSizeKey.Int(page.Size), pageSortKey.String(string(page.SortKey)))
+
+	ctx, span := r.start(ctx, "SearchOrdersPage", attrs...)
+	defer func() { endSpan(span, err) }()
+
+	p, err := r.next.SearchOrdersPage(ctx, filter, page)
+	span.SetAttributes(orderCountKey.Int(len(p.Orders)), pageHasNextKey.Bool(p.NextCursor != ""))
+
+	return p, err
+}
+
+func (r *orderRepository) CountOrders(ctx context.Context, filter domain.OrderFilter) (_ int64, err error) {
+	ctx, span := r.start(ctx, "CountOrders", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+
+	count, err := r.next.CountOrders(ctx, filter)
+	span.SetAttributes(orderCountKey.Int64(count))
+
+	return count, err
+}
+
+func (r *orderRepository) GetOrderFacets(ctx context.Context, filter domain.OrderFilter) (_ domain.OrderFacets, err error) {
+	ctx, span := r.start(ctx, "GetOrderFacets", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+
+	facets, err := r.next.GetOrderFacets(ctx, filter)
+
+	var count int64
+	for _, n := range facets.Statuses {
+		count += n
+	}
+	span.SetAttributes(orderCountKey.Int64(count))
+
+	return facets, err
+}
+
+func (r *orderRepository) InsertOrder(ctx context.Context, order domain.Order) (_ uuid.UUID, err error) {
+	ctx, span := r.start(ctx, "InsertOrder", itemCountKey.Int(len(order.Items)))
+	defer func() { endSpan(span, err) }()
+
+	orderID, err := r.next.InsertOrder(ctx, order)
+	if err == nil {
+		span.SetAttributes(orderIDKey.String(orderID.String()))
+	}
+
+	return orderID, err
+}
+
+func (r *orderRepository) InsertOrders(ctx context.Context, orders []domain.Order) (_ []domain.InsertOrderResult, err error) {
+	ctx, span := r.start(ctx, "InsertOrders", orderCountKey.Int(len(orders)))
+	defer func() { endSpan(span, err) }()
+
+	results, err := r.next.InsertOrders(ctx, orders)
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	span.SetAttributes(failedCountKey.Int(failed))
+
+	return results, err
+}
+
+func (r *orderRepository) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status domain.OrderStatus, expectedVersion int64) (err error) {
+	ctx, span := r.start(ctx, "UpdateOrderStatus", orderIDKey.String(orderID.String()), statusKey.String(string(status)), expectedVersionKey.Int64(expectedVersion))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.UpdateOrderStatus(ctx, orderID, status, expectedVersion)
+}
+
+func (r *orderRepository) UpdateOrder(ctx context.Context, order domain.Order) (err error) {
+	ctx, span := r.start(ctx, "UpdateOrder", orderIDKey.String(order.ID.String()), expectedVersionKey.Int64(order.Version), itemCountKey.Int(len(order.Items)))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.UpdateOrder(ctx, order)
+}
+
+func (r *orderRepository) AddOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) (err error) {
+	ctx, span := r.start(ctx, "AddOrderItems", orderIDKey.String(orderID.String()), itemCountKey.Int(len(items)), expectedVersionKey.Int64(expectedVersion))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.AddOrderItems(ctx, orderID, items, expectedVersion)
+}
+
+func (r *orderRepository) ReplaceOrderItems(ctx context.Context, orderID uuid.UUID, items []domain.OrderItem, expectedVersion int64) (err error) {
+	ctx, span := r.start(ctx, "ReplaceOrderItems", orderIDKey.String(orderID.String()), itemCountKey.Int(len(items)), expectedVersionKey.Int64(expectedVersion))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.ReplaceOrderItems(ctx, orderID, items, expectedVersion)
+}
+
+func (r *orderRepository) SetTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) (err error) {
+	ctx, span := r.start(ctx, "SetTags", orderIDKey.String(orderID.String()), tagCountKey.Int(len(tags)), expectedVersionKey.Int64(expectedVersion))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.SetTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *orderRepository) AddTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) (err error) {
+	ctx, span := r.start(ctx, "AddTags", orderIDKey.String(orderID.String()), tagCountKey.Int(len(tags)), expectedVersionKey.Int64(expectedVersion))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.AddTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *orderRepository) RemoveTags(ctx context.Context, orderID uuid.UUID, tags []string, expectedVersion int64) (err error) {
+	ctx, span := r.start(ctx, "RemoveTags", orderIDKey.String(orderID.String()), tagCountKey.Int(len(tags)), expectedVersionKey.Int64(expectedVersion))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.RemoveTags(ctx, orderID, tags, expectedVersion)
+}
+
+func (r *orderRepository) SetURL(ctx context.Context, orderID uuid.UUID, u *url.URL, expectedVersion int64) (err error) {
+	ctx, span := r.start(ctx, "SetURL", orderIDKey.String(orderID.String()), expectedVersionKey.Int64(expectedVersion))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.SetURL(ctx, orderID, u, expectedVersion)
+}
+
+func (r *orderRepository) SetPayload(ctx context.Context, orderID uuid.UUID, payload, payloadB []byte, expectedVersion int64) (err error) {
+	ctx, span := r.start(ctx, "SetPayload", orderIDKey.String(orderID.String()), expectedVersionKey.Int64(expectedVersion))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.SetPayload(ctx, orderID, payload, payloadB, expectedVersion)
+}
+
+func (r *orderRepository) SoftDeleteOrder(ctx context.Context, orderID uuid.UUID) (err error) {
+	ctx, span := r.start(ctx, "SoftDeleteOrder", orderIDKey.String(orderID.String()))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.SoftDeleteOrder(ctx, orderID)
+}
+
+func (r *orderRepository) SoftDeleteOrderItem(ctx context.Context, orderID, productID uuid.UUID) (err error) {
+	ctx, span := r.start(ctx, "SoftDeleteOrderItem", orderIDKey.String(orderID.String()), productIDKey.String(productID.String()))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.SoftDeleteOrderItem(ctx, orderID, productID)
+}
+
+func (r *orderRepository) RestoreOrder(ctx context.Context, orderID uuid.UUID) (err error) {
+	ctx, span := r.start(ctx, "RestoreOrder", orderIDKey.String(orderID.String()))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.RestoreOrder(ctx, orderID)
+}
+
+func (r *orderRepository) RestoreOrderItem(ctx context.Context, orderID, productID uuid.UUID) (err error) {
+	ctx, span := r.start(ctx, "RestoreOrderItem", orderIDKey.String(orderID.String()), productIDKey.String(productID.String()))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.RestoreOrderItem(ctx, orderID, productID)
+}
+
+func (r *orderRepository) DeleteOrder(ctx context.Context, orderID uuid.UUID) (err error) {
+	ctx, span := r.start(ctx, "DeleteOrder", orderIDKey.String(orderID.String()))
+	defer func() { endSpan(span, err) }()
+
+	return r.next.DeleteOrder(ctx, orderID)
+}
+
+func (r *orderRepository) Purge(ctx context.Context, olderThan time.Duration, batchSize int) (_ domain.PurgeResult, err error) {
+	ctx, span := r.start(ctx, "Purge", purgeOlderThanKey.String(olderThan.String()), purgeBatchSizeKey.Int(batchSize))
+	defer func() { endSpan(span, err) }()
+
+	result, err := r.next.Purge(ctx, olderThan, batchSize)
+	span.SetAttributes(purgedOrdersKey.Int64(result.Orders), purgedItemsKey.Int64(result.Items))
+
+	return result, err
+}
+
+func (r *orderRepository) GetOrderHistory(ctx context.Context, orderID uuid.UUID) (_ []domain.OrderHistoryEntry, err error) {
+	ctx, span := r.start(ctx, "GetOrderHistory", orderIDKey.String(orderID.String()))
+	defer func() { endSpan(span, err) }()
+
+	history, err := r.next.GetOrderHistory(ctx, orderID)
+	span.SetAttributes(historyCountKey.Int(len(history)))
+
+	return history, err
+}
+
+// filterAttributes summarizes the filter by the names of its non-empty fields, the values may hold personal data.
+func filterAttributes(filter domain.OrderFilter) []attribute.KeyValue {
+	var fields []string
+
+	if len(filter.IDs) > 0 {
+		fields = append(fields, "ids")
+	}
+	if len(filter.OwnerIDs) > 0 {
+		fields = append(fields, "owner_ids")
+	}
+	if len(filter.UrlPatterns) > 0 {
+		fields = append(fields, "url_patterns")
+	}
+	if len(filter.Statuses) > 0 {
+		fields = append(fields, "statuses")
+	}
+	if len(filter.Tags) > 0 {
+		fields = append(fields, "tags")
+	}
+	if filter.CreatedAt != nil {
+		fields = append(fields, "created_at")
+	}
+	if filter.UpdatedAt != nil {
+		fields = append(fields, "updated_at")
+	}
+	if filter.DeletedAt != nil {
+		fields = append(fields, "deleted_at")
+	}
+
+	attrs := []attribute.KeyValue{filterFieldsKey.StringSlice(fields)}
+	if filter.Deleted != domain.ExcludeDeleted {
+		attrs = append(attrs, filterDeletedKey.String(string(filter.Deleted)))
+	}
+
+	return attrs
+}
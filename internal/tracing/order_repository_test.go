@@ -0,0 +1,94 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp/internal/domain"
+	"github.com/nikolayk812/sqlcpp/internal/inmemory"
+	"github.com/nikolayk812/sqlcpp/internal/port"
+	"github.com/nikolayk812/sqlcpp/internal/repository"
+	"github.com/nikolayk812/sqlcpp/internal/repository/repositorytest"
+	"github.com/nikolayk812/sqlcpp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOrderRepository(t *testing.T) {
+	ctx := t.Context()
+
+	recorder := tracetest.NewSpanRecorder()
+	repo := newTracedRepository(t, recorder)
+
+	order := repositorytest.RandomOrder()
+	orderID, err := repo.InsertOrder(ctx, order)
+	require.NoError(t, err)
+
+	orders, err := repo.SearchOrders(ctx, domain.OrderFilter{
+		IDs:     []uuid.UUID{orderID},
+		Deleted: domain.IncludeDeleted,
+	})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+
+	_, err = repo.GetOrder(ctx, uuid.New())
+	require.ErrorIs(t, err, repository.ErrNotFound)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	insert := spans[0]
+	assert.Equal(t, "OrderRepository.InsertOrder", insert.Name())
+	assert.Equal(t, codes.Unset, insert.Status().Code)
+	assertAttribute(t, insert, "order.id", attribute.StringValue(orderID.String()))
+	assertAttribute(t, insert, "order.item_count", attribute.IntValue(len(order.Items)))
+
+	search := spans[1]
+	assert.Equal(t, "OrderRepository.SearchOrders", search.Name())
+	assertAttribute(t, search, "order.filter.fields", attribute.StringSliceValue([]string{"ids"}))
+	assertAttribute(t, search, "order.filter.deleted", attribute.StringValue(string(domain.IncludeDeleted)))
+	assertAttribute(t, search, "order.count", attribute.IntValue(1))
+
+	get := spans[2]
+	assert.Equal(t, "OrderRepository.GetOrder", get.Name())
+	assert.Equal(t, codes.Error, get.Status().Code)
+	require.Len(t, get.Events(), 1)
+	assert.Equal(t, "exception", get.Events()[0].Name)
+}
+
+func TestOrderRepository_NilNext(t *testing.T) {
+	_, err := tracing.NewOrderRepository(nil)
+	require.EqualError(t, err, "next is nil")
+}
+
+func newTracedRepository(t *testing.T, recorder *tracetest.SpanRecorder) port.OrderRepository {
+	t.Helper()
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+
+	repo, err := tracing.NewOrderRepository(inmemory.NewOrder(), tracing.WithTracerProvider(tp))
+	require.NoError(t, err)
+
+	return repo
+}
+
+func assertAttribute(t *testing.T, span sdktrace.ReadOnlySpan, key attribute.Key, want attribute.Value) {
+	t.Helper()
+
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			assert.Equal(t, want, attr.Value, "attribute[%s]", key)
+			return
+		}
+	}
+
+	assert.Failf(t, "attribute is missing", "attribute[%s] of span[%s]", key, span.Name())
+}
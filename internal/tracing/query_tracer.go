@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"cmp"
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/nikolayk812/sqlcpp/internal/dbtxmw"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var rowsAffectedKey = attribute.Key("db.response.rows_affected")
+
+// QueryTracer creates a span per SQL statement, set it as the Tracer of pgx.ConnConfig.
+// Transaction control statements are traced too, i.e. BEGIN, SAVEPOINT, COMMIT and ROLLBACK,
+// a batch gets a span with a child span per queued statement.
+type QueryTracer struct {
+	tracer trace.Tracer
+}
+
+var (
+	_ pgx.QueryTracer = (*QueryTracer)(nil)
+	_ pgx.BatchTracer = (*QueryTracer)(nil)
+)
+
+func NewQueryTracer(opts ...Option) *QueryTracer {
+	return &QueryTracer{
+		tracer: newTracer(opts),
+	}
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, statementName(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(statementAttributes(data.SQL)...),
+	)
+
+	return ctx
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(rowsAffectedKey.Int64(data.CommandTag.RowsAffected()))
+
+	endSpan(span, data.Err)
+}
+
+func (t *QueryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "BATCH",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName("BATCH"),
+			semconv.DBOperationBatchSize(data.Batch.Len()),
+		),
+	)
+
+	return ctx
+}
+
+// TraceBatchQuery is called once the result of the statement is read, so its span has no meaningful duration.
+func (t *QueryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	_, span := t.tracer.Start(ctx, statementName(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(statementAttributes(data.SQL)...),
+		trace.WithAttributes(rowsAffectedKey.Int64(data.CommandTag.RowsAffected())),
+	)
+
+	endSpan(span, data.Err)
+}
+
+func (t *QueryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	endSpan(trace.SpanFromContext(ctx), data.Err)
+}
+
+// statementName is the dbtxmw.QueryName of sql, i.e. InsertOrder or BEGIN, span names cannot be empty.
+func statementName(sql string) string {
+	return cmp.Or(dbtxmw.QueryName(sql), "SQL")
+}
+
+func statementAttributes(sql string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.DBSystemNamePostgreSQL,
+		semconv.DBOperationName(statementName(sql)),
+		semconv.DBQueryText(sql),
+	}
+}
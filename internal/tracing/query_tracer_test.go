@@ -0,0 +1,70 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp/internal/db"
+	"github.com/nikolayk812/sqlcpp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// the hooks are called directly, the Postgres suite covers them with a real pool
+func TestQueryTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := newQueryTracer(t, recorder)
+
+	ctx := tracer.TraceQueryStart(t.Context(), nil, pgx.TraceQueryStartData{SQL: "begin isolation level serializable"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("BEGIN")})
+
+	batch := &pgx.Batch{}
+	batch.Queue(db.InsertOrderItem)
+	batch.Queue(db.InsertOrderItem)
+
+	ctx = tracer.TraceBatchStart(t.Context(), nil, pgx.TraceBatchStartData{Batch: batch})
+	tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{SQL: db.InsertOrderItem, CommandTag: pgconn.NewCommandTag("INSERT 0 1")})
+	tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{SQL: db.InsertOrderItem, Err: errors.New("duplicate key")})
+	tracer.TraceBatchEnd(ctx, nil, pgx.TraceBatchEndData{Err: errors.New("duplicate key")})
+
+	ctx = tracer.TraceQueryStart(t.Context(), nil, pgx.TraceQueryStartData{SQL: "rollback"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("ROLLBACK")})
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 5)
+
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	assert.Equal(t, []string{"BEGIN", "InsertOrderItem", "InsertOrderItem", "BATCH", "ROLLBACK"}, names)
+
+	batchSpan := spans[3]
+	assertAttribute(t, batchSpan, "db.operation.batch.size", attribute.IntValue(2))
+	assert.Equal(t, codes.Error, batchSpan.Status().Code)
+
+	for _, item := range spans[1:3] {
+		assert.Equal(t, batchSpan.SpanContext().SpanID(), item.Parent().SpanID())
+		assertAttribute(t, item, "db.query.text", attribute.StringValue(db.InsertOrderItem))
+	}
+	assertAttribute(t, spans[1], "db.response.rows_affected", attribute.Int64Value(1))
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+}
+
+func newQueryTracer(t *testing.T, recorder *tracetest.SpanRecorder) *tracing.QueryTracer {
+	t.Helper()
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+
+	return tracing.NewQueryTracer(tracing.WithTracerProvider(tp))
+}
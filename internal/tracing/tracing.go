@@ -0,0 +1,44 @@
+// Package tracing adds OpenTelemetry spans to the order repository and to the SQL statements it runs.
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/nikolayk812/sqlcpp/internal/tracing"
+
+type config struct {
+	tracerProvider trace.TracerProvider
+}
+
+type Option func(*config)
+
+// WithTracerProvider sets the provider of the tracer, the global one is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tp
+	}
+}
+
+func newTracer(opts []Option) trace.Tracer {
+	cfg := config{
+		tracerProvider: otel.GetTracerProvider(),
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg.tracerProvider.Tracer(instrumentationName)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}